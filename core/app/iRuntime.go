@@ -41,4 +41,5 @@ type IRuntime interface {
 	Lang(ctx context.Context, langKey string) string
 	Config(ctx context.Context, key string) *gvar.Var
 	GetQueueMessage(id, routingKey string, value map[string]interface{}) (messageLib.IMessage, error)
+	Shutdown(ctx context.Context)
 }
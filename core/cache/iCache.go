@@ -10,6 +10,8 @@ type ICache interface {
 	String() string
 	Get(ctx context.Context, key string) (*gvar.Var, error)
 	Set(ctx context.Context, key string, val interface{}, expire int) error
+	// SetNX sets key only if it does not exist, false means it exists
+	SetNX(ctx context.Context, key string, val interface{}, expire int) (bool, error)
 	Del(ctx context.Context, key string) error
 	HashGet(ctx context.Context, hk, key string) (*gvar.Var, error)
 	HashDel(ctx context.Context, hk, key string) error
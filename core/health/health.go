@@ -0,0 +1,44 @@
+package health
+
+import "context"
+
+// LagUnknown is reported when a backend can not tell how many messages are waiting
+const LagUnknown int64 = -1
+
+// Status 组件的健康状态
+type Status struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	// Consumers is the number of registered consumers
+	Consumers int `json:"consumers"`
+	// Lag is the number of messages waiting to be consumed or acknowledged
+	Lag   int64  `json:"lag"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthChecker is implemented by the queues, caches and lockers able to report their state
+type HealthChecker interface {
+	Health(ctx context.Context) Status
+}
+
+// Up returns the status of a connected component without consumers
+func Up(name string) Status {
+	return Status{Name: name, Connected: true, Lag: LagUnknown}
+}
+
+// Down returns the status of a component that failed its check with err
+func Down(name string, err error) Status {
+	s := Status{Name: name, Lag: LagUnknown}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// Check returns the status of v, components not implementing HealthChecker are assumed connected
+func Check(ctx context.Context, name string, v interface{}) Status {
+	if c, ok := v.(HealthChecker); ok {
+		return c.Health(ctx)
+	}
+	return Up(name)
+}
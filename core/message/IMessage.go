@@ -2,6 +2,23 @@ package message
 
 const (
 	PrefixKey = "__host"
+	// ErrorKey holds the last consumer error of a dead-lettered message
+	ErrorKey = "__error"
+	// ErrorCountKey holds the failed delivery count of a dead-lettered message
+	ErrorCountKey = "__error_count"
+)
+
+// well-known header keys
+const (
+	HeaderTraceId     = "trace-id"
+	HeaderTenant      = "tenant"
+	HeaderPriority    = "priority"
+	HeaderTimestamp   = "timestamp"
+	HeaderPublisher   = "publisher"
+	HeaderOrderingKey = "ordering-key"
+	HeaderAttempt     = "attempt"
+	// HeaderRoutingKey keeps the routing key of a message republished straight to its queue
+	HeaderRoutingKey = "routing-key"
 )
 
 type IMessage interface {
@@ -16,4 +33,8 @@ type IMessage interface {
 	SetErrorIncr()
 	SetErrorCount(uint64)
 	GetErrorCount() uint64
+	SetHeaders(map[string]string)
+	GetHeaders() map[string]string
+	SetHeader(key, value string)
+	GetHeader(key string) string
 }
@@ -0,0 +1,58 @@
+package message
+
+import (
+	"strings"
+	"sync"
+)
+
+// ContentTypeKey carries the codec content type on backends without native content type support
+const ContentTypeKey = "__content_type"
+
+// Codec encodes message values to the wire format and back
+type Codec interface {
+	// Name is the short name used in config, e.g. json, msgpack+gzip
+	Name() string
+	// ContentType is recorded with every message so consumers pick the same codec
+	ContentType() string
+	Marshal(values map[string]interface{}) ([]byte, error)
+	Unmarshal(data []byte) (map[string]interface{}, error)
+}
+
+var (
+	codecs       sync.Map
+	DefaultCodec Codec = JsonCodec{}
+)
+
+func init() {
+	for _, c := range []Codec{JsonCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		RegisterCodec(c)
+		RegisterCodec(NewGzipCodec(c))
+	}
+}
+
+// RegisterCodec 注册编解码器, 可通过名称或content type获取
+func RegisterCodec(c Codec) {
+	codecs.Store(c.Name(), c)
+	codecs.Store(c.ContentType(), c)
+}
+
+// GetCodec 通过名称或content type获取编解码器
+func GetCodec(nameOrContentType string) (Codec, bool) {
+	// strip parameters like "; charset=utf-8"
+	key := strings.TrimSpace(strings.SplitN(nameOrContentType, ";", 2)[0])
+	if c, ok := codecs.Load(key); ok {
+		return c.(Codec), true
+	}
+	return nil, false
+}
+
+// GetCodecOrDefault 获取编解码器, 为空或未注册时使用def, def为nil时使用DefaultCodec
+func GetCodecOrDefault(nameOrContentType string, def Codec) Codec {
+	if c, ok := GetCodec(nameOrContentType); ok {
+		return c
+	}
+	if def == nil {
+		return DefaultCodec
+	}
+	return def
+}
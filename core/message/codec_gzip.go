@@ -0,0 +1,53 @@
+package message
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// GzipCodec compresses the output of another codec, its content type is suffixed with +gzip
+type GzipCodec struct {
+	Codec Codec
+}
+
+func NewGzipCodec(c Codec) GzipCodec {
+	return GzipCodec{Codec: c}
+}
+
+func (c GzipCodec) Name() string {
+	return c.Codec.Name() + "+gzip"
+}
+
+func (c GzipCodec) ContentType() string {
+	return c.Codec.ContentType() + "+gzip"
+}
+
+func (c GzipCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	rb, err := c.Codec.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(rb); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c GzipCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	rb, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.Unmarshal(rb)
+}
@@ -0,0 +1,24 @@
+package message
+
+import "encoding/json"
+
+// JsonCodec application/json
+type JsonCodec struct{}
+
+func (JsonCodec) Name() string {
+	return "json"
+}
+
+func (JsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (JsonCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	return json.Marshal(values)
+}
+
+func (JsonCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := json.Unmarshal(data, &values)
+	return values, err
+}
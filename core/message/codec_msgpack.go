@@ -0,0 +1,24 @@
+package message
+
+import "github.com/vmihailenco/msgpack/v5"
+
+// MsgpackCodec application/msgpack
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (MsgpackCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	return msgpack.Marshal(values)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := msgpack.Unmarshal(data, &values)
+	return values, err
+}
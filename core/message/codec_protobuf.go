@@ -0,0 +1,60 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ProtobufCodec application/x-protobuf, values are sent as a google.protobuf.Struct packed in google.protobuf.Any
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (ProtobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (ProtobufCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	s, err := structpb.NewStruct(values)
+	if err != nil {
+		// structpb only knows the json types, normalize the others through json
+		rb, jErr := json.Marshal(values)
+		if jErr != nil {
+			return nil, err
+		}
+		normalized := make(map[string]interface{})
+		if jErr = json.Unmarshal(rb, &normalized); jErr != nil {
+			return nil, err
+		}
+		if s, err = structpb.NewStruct(normalized); err != nil {
+			return nil, err
+		}
+	}
+	a, err := anypb.New(s)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(a)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	a := new(anypb.Any)
+	err := proto.Unmarshal(data, a)
+	if err != nil {
+		return nil, err
+	}
+	m, err := a.UnmarshalNew()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := m.(*structpb.Struct)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: unexpected message type %s", a.GetTypeUrl())
+	}
+	return s.AsMap(), nil
+}
@@ -0,0 +1,54 @@
+package message
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	values := map[string]interface{}{
+		"name":   "order",
+		"nested": map[string]interface{}{"ok": true},
+		"list":   []interface{}{"a", "b"},
+	}
+	for _, name := range []string{"json", "msgpack", "protobuf", "json+gzip", "msgpack+gzip", "protobuf+gzip"} {
+		t.Run(name, func(t *testing.T) {
+			c, ok := GetCodec(name)
+			if !ok {
+				t.Fatalf("codec %s not registered", name)
+			}
+			if byType, _ := GetCodec(c.ContentType()); byType == nil || byType.Name() != name {
+				t.Errorf("GetCodec(%s) = %v", c.ContentType(), byType)
+			}
+			rb, err := c.Marshal(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Unmarshal(rb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, values) {
+				t.Errorf("Unmarshal() = %v, want %v", got, values)
+			}
+		})
+	}
+}
+
+func TestCodec_Numbers(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		c, _ := GetCodec(name)
+		rb, err := c.Marshal(map[string]interface{}{"id": 42, "ids": []int{1, 2}})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := c.Unmarshal(rb)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprint(got["id"]) != "42" || fmt.Sprint(got["ids"]) != "[1 2]" {
+			t.Errorf("%s: Unmarshal() = %v", name, got)
+		}
+	}
+}
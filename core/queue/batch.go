@@ -0,0 +1,185 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is the max number of messages handed to a batch consumer at once
+	DefaultBatchSize = 100
+	// DefaultBatchTimeout is how long a batch waits for more messages after its first one
+	DefaultBatchTimeout = 100 * time.Millisecond
+)
+
+// BatchConsumerFunc handles up to BatchSize messages at once, the i-th error is the ack result of msgs[i],
+// a nil or shorter result acks the remaining messages
+type BatchConsumerFunc func(ctx context.Context, msgs []message.IMessage) []error
+
+// IBatchQueue is implemented by the queues publishing or consuming batches natively
+type IBatchQueue interface {
+	IQueue
+	// PublishBatch publishes the messages with the same options
+	PublishBatch(ctx context.Context, msgs []message.IMessage, optionFuncs ...func(*PublishOptions)) error
+	// BatchConsumer receives up to BatchSize messages or whatever arrives within BatchTimeout
+	BatchConsumer(ctx context.Context, name string, f BatchConsumerFunc, optionFuncs ...func(*ConsumeOptions))
+}
+
+// WithConsumeOptionsBatch returns a function that sets the size and the wait timeout of the batches
+func WithConsumeOptionsBatch(size int, timeout time.Duration) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.BatchSize = size
+		options.BatchTimeout = timeout
+	}
+}
+
+// GetBatch returns the batch size and timeout, the defaults if not set
+func (o *ConsumeOptions) GetBatch() (int, time.Duration) {
+	size, timeout := o.BatchSize, o.BatchTimeout
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultBatchTimeout
+	}
+	return size, timeout
+}
+
+// BatchResult returns the ack result of the i-th message of a batch
+func BatchResult(errs []error, i int) error {
+	if i < len(errs) {
+		return errs[i]
+	}
+	return nil
+}
+
+// PublishBatch publishes the messages natively if q implements IBatchQueue, one by one otherwise
+func PublishBatch(ctx context.Context, q IQueue, msgs []message.IMessage, optionFuncs ...func(*PublishOptions)) error {
+	if b, ok := q.(IBatchQueue); ok {
+		return b.PublishBatch(ctx, msgs, optionFuncs...)
+	}
+	return PublishEach(ctx, q, msgs, optionFuncs...)
+}
+
+// PublishEach publishes the messages one by one and stops at the first error
+func PublishEach(ctx context.Context, q IQueue, msgs []message.IMessage, optionFuncs ...func(*PublishOptions)) error {
+	for i, msg := range msgs {
+		if err := q.Publish(ctx, msg, optionFuncs...); err != nil {
+			return fmt.Errorf("publish batch message %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// BatchConsumer registers f natively if q implements IBatchQueue, through ConsumeBatches otherwise
+func BatchConsumer(ctx context.Context, q IQueue, name string, f BatchConsumerFunc, optionFuncs ...func(*ConsumeOptions)) {
+	if b, ok := q.(IBatchQueue); ok {
+		b.BatchConsumer(ctx, name, f, optionFuncs...)
+		return
+	}
+	ConsumeBatches(ctx, q, name, f, optionFuncs...)
+}
+
+// ConsumeBatches registers f on a single message consumer of q, the deliveries are held until their batch is handled
+// so the consumer concurrency is raised to the batch size
+func ConsumeBatches(ctx context.Context, q IQueue, name string, f BatchConsumerFunc, optionFuncs ...func(*ConsumeOptions)) {
+	options := GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	size, timeout := options.GetBatch()
+	b := NewBatcher(size, timeout, f)
+	optionFuncs = append(optionFuncs, func(options *ConsumeOptions) {
+		if options.Concurrency < size {
+			options.Concurrency = size
+		}
+		if options.QOSPrefetch > 0 && options.QOSPrefetch < size {
+			options.QOSPrefetch = size
+		}
+	})
+	q.Consumer(ctx, name, b.Handle, optionFuncs...)
+}
+
+// NewBatcher 将单条消息合并为批次, 达到size条或首条消息等待timeout后交给f处理
+func NewBatcher(size int, timeout time.Duration, f BatchConsumerFunc) *Batcher {
+	return &Batcher{
+		size:    size,
+		timeout: timeout,
+		f:       f,
+	}
+}
+
+// Batcher collects the messages of concurrent Handle calls into batches
+type Batcher struct {
+	size    int
+	timeout time.Duration
+	f       BatchConsumerFunc
+	mux     sync.Mutex
+	pending *batch
+}
+
+type batch struct {
+	ctx  context.Context
+	msgs []message.IMessage
+	errs []error
+	done chan struct{}
+}
+
+// Handle adds msg to the pending batch and returns its ack result once the batch has been handled
+func (b *Batcher) Handle(ctx context.Context, msg message.IMessage) error {
+	b.mux.Lock()
+	p := b.pending
+	if p == nil {
+		p = &batch{ctx: ctx, done: make(chan struct{})}
+		b.pending = p
+		time.AfterFunc(b.timeout, func() {
+			b.flush(p)
+		})
+	}
+	i := len(p.msgs)
+	p.msgs = append(p.msgs, msg)
+	full := len(p.msgs) >= b.size
+	if full {
+		b.pending = nil
+	}
+	b.mux.Unlock()
+	if full {
+		b.run(p)
+	}
+	select {
+	case <-p.done:
+		return BatchResult(p.errs, i)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush hands p to f unless it has already been flushed
+func (b *Batcher) flush(p *batch) {
+	b.mux.Lock()
+	if b.pending != p {
+		b.mux.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mux.Unlock()
+	b.run(p)
+}
+
+// run hands p to f, a panic fails every message of the batch
+func (b *Batcher) run(p *batch) {
+	defer close(p.done)
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("batch consumer panic: %v", r)
+			p.errs = make([]error, len(p.msgs))
+			for i := range p.errs {
+				p.errs[i] = err
+			}
+		}
+	}()
+	p.errs = b.f(p.ctx, p.msgs)
+}
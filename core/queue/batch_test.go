@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher_Handle(t *testing.T) {
+	failed := errors.New("failed")
+	var mux sync.Mutex
+	var sizes []int
+	b := NewBatcher(3, 50*time.Millisecond, func(ctx context.Context, msgs []message.IMessage) []error {
+		mux.Lock()
+		sizes = append(sizes, len(msgs))
+		mux.Unlock()
+		errs := make([]error, len(msgs))
+		for i, msg := range msgs {
+			if msg.GetId() == "fail" {
+				errs[i] = failed
+			}
+		}
+		return errs
+	})
+	ids := []string{"a", "b", "fail", "c", "d"}
+	results := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			results[i] = b.Handle(context.Background(), &testMessage{id: id})
+		}(i, id)
+	}
+	wg.Wait()
+	if len(sizes) != 2 || sizes[0]+sizes[1] != len(ids) || sizes[0] != 3 {
+		t.Errorf("batch sizes = %v, want [3 2]", sizes)
+	}
+	for i, id := range ids {
+		if want := id == "fail"; (results[i] != nil) != want {
+			t.Errorf("Handle(%s) error = %v", id, results[i])
+		}
+	}
+}
+
+func TestBatcher_Panic(t *testing.T) {
+	b := NewBatcher(2, 10*time.Millisecond, func(ctx context.Context, msgs []message.IMessage) []error {
+		panic("boom")
+	})
+	if err := b.Handle(context.Background(), &testMessage{}); err == nil {
+		t.Error("Handle() error = nil after a panic")
+	}
+}
+
+func TestDrain_TrackBatch(t *testing.T) {
+	var d Drain
+	f := d.TrackBatch(func(ctx context.Context, msgs []message.IMessage) []error {
+		return nil
+	})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	errs := f(context.Background(), []message.IMessage{&testMessage{}, &testMessage{}})
+	for i := 0; i < 2; i++ {
+		if !errors.Is(BatchResult(errs, i), ErrShuttingDown) {
+			t.Errorf("message %d error = %v, want %v", i, BatchResult(errs, i), ErrShuttingDown)
+		}
+	}
+}
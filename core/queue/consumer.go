@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"github.com/168yy/plus-core/core/v2/message"
+	"time"
 )
 
 type ConsumerFunc func(ctx context.Context, msg message.IMessage) error
@@ -20,4 +21,54 @@ type ConsumeOptions struct {
 	GroupName         string
 	MaxReconsumeTimes int32
 	AutoCommit        bool
+	// kafka, the consumer group, defaults to the topic name
+	GroupID string
+	// nats, how long a delivery waits for its ack and how many times a message is delivered
+	AckWait    time.Duration
+	MaxDeliver int
+	// nsq, the channel of the topic, defaults to the channel prefix + topic
+	Channel string
+	// dead letter, honored by every backend
+	DeadLetter *DeadLetterOptions
+	// middlewares of this consumer, run inside the queue middlewares
+	Middlewares []Middleware
+	// highest priority of the consumed queue, 0 disables priorities
+	MaxPriority uint8
+	// batch consumers, up to BatchSize messages or whatever arrives within BatchTimeout
+	BatchSize    int
+	BatchTimeout time.Duration
+	// memory, messages taken from the queue and not handled yet, defaults to Concurrency
+	MaxInFlight int
+}
+
+// WithConsumeOptionsConcurrency returns a function that sets how many messages are handled at the same time
+func WithConsumeOptionsConcurrency(concurrency int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.Concurrency = concurrency
+	}
+}
+
+// WithConsumeOptionsConsumerName returns a function that sets the name of this consumer on the server
+func WithConsumeOptionsConsumerName(consumerName string) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.ConsumerName = consumerName
+	}
+}
+
+// WithConsumeOptionsMaxInFlight returns a function that limits the messages a consumer takes from the queue before they are handled
+func WithConsumeOptionsMaxInFlight(maxInFlight int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.MaxInFlight = maxInFlight
+	}
+}
+
+// GetMaxInFlight returns MaxInFlight, Concurrency if it is not set
+func (o *ConsumeOptions) GetMaxInFlight() int {
+	if o.MaxInFlight > 0 {
+		return o.MaxInFlight
+	}
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return 1
 }
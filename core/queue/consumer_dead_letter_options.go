@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+const (
+	// DeadLetterSuffix is appended to the consumed queue name when no dead-letter queue name is given
+	DeadLetterSuffix = ".dlq"
+)
+
+// DeadLetterOptions describe when a failing message is moved to the dead-letter queue.
+type DeadLetterOptions struct {
+	// MaxAttempts is the number of failed deliveries after which the message is dead-lettered, 0 disables it
+	MaxAttempts uint64
+	// Queue is the dead-letter queue (routing key, topic or stream) name
+	Queue string
+}
+
+// WithDeadLetter returns a function that moves a message to the dead-letter queue after maxAttempts failures.
+// if queueName is empty, the consumed queue name with DeadLetterSuffix will be used
+func WithDeadLetter(queueName string, maxAttempts uint64) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.DeadLetter = &DeadLetterOptions{
+			MaxAttempts: maxAttempts,
+			Queue:       queueName,
+		}
+	}
+}
+
+// Enabled reports whether the dead-letter policy is active
+func (d *DeadLetterOptions) Enabled() bool {
+	return d != nil && d.MaxAttempts > 0
+}
+
+// GetQueue returns the dead-letter queue name for the consumed queue name
+func (d *DeadLetterOptions) GetQueue(name string) string {
+	if d == nil || d.Queue == "" {
+		return name + DeadLetterSuffix
+	}
+	return d.Queue
+}
+
+// Exhausted reports whether msg has used up its attempts or failed with a Permanent error,
+// the error count must already include the current failure
+func (d *DeadLetterOptions) Exhausted(msg message.IMessage, err error) bool {
+	return d.Enabled() && (msg.GetErrorCount() >= d.MaxAttempts || IsPermanent(err))
+}
+
+// SetDeadLetter records the last error on msg and routes it to the dead-letter queue
+func (d *DeadLetterOptions) SetDeadLetter(name string, msg message.IMessage, err error) {
+	values := msg.GetValues()
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	if err != nil {
+		values[message.ErrorKey] = err.Error()
+	}
+	values[message.ErrorCountKey] = msg.GetErrorCount()
+	msg.SetValues(values)
+	msg.SetRoutingKey(d.GetQueue(name))
+}
+
+// DropPermanent 未启用死信时确认Permanent失败的消息并记录日志, 重试无法修复的消息不再重复投递
+func DropPermanent(name string, f ConsumerFunc, options ConsumeOptions) ConsumerFunc {
+	if options.DeadLetter.Enabled() {
+		return f
+	}
+	return func(ctx context.Context, msg message.IMessage) error {
+		err := f(ctx, msg)
+		if IsPermanent(err) {
+			glog.Warningf(ctx, "queue %s drop message %s without dead-letter queue: %v", name, msg.GetId(), err)
+			return nil
+		}
+		return err
+	}
+}
+
+// DropPermanentBatch is DropPermanent for the native batch consumers
+func DropPermanentBatch(name string, f BatchConsumerFunc, options ConsumeOptions) BatchConsumerFunc {
+	if options.DeadLetter.Enabled() {
+		return f
+	}
+	return func(ctx context.Context, msgs []message.IMessage) []error {
+		errs := f(ctx, msgs)
+		for i, err := range errs {
+			if IsPermanent(err) && i < len(msgs) {
+				glog.Warningf(ctx, "queue %s drop message %s without dead-letter queue: %v", name, msgs[i].GetId(), err)
+				errs[i] = nil
+			}
+		}
+		return errs
+	}
+}
+
+// permanentError is a failure retrying cannot fix, e.g. an invalid payload
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent 标记err不可重试, 消费者返回后启用死信的消息直接进入死信队列, 未启用死信的消息记录日志后确认
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err or an error it wraps was marked by Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,11 @@
+package queue
+
+// WithKafkaGroupID returns a function that sets the consumer group of the kafka consumer
+func WithKafkaGroupID(id string) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if id == "" {
+			return
+		}
+		options.GroupID = id
+	}
+}
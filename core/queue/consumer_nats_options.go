@@ -0,0 +1,17 @@
+package queue
+
+import "time"
+
+// WithNatsAckWait returns a function that sets how long a delivery waits for its ack before it is redelivered
+func WithNatsAckWait(ackWait time.Duration) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.AckWait = ackWait
+	}
+}
+
+// WithNatsMaxDeliver returns a function that sets how many times a message is delivered, -1 is unlimited
+func WithNatsMaxDeliver(maxDeliver int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.MaxDeliver = maxDeliver
+	}
+}
@@ -0,0 +1,11 @@
+package queue
+
+// WithNsqChannel returns a function that sets the channel the nsq consumer reads the topic from
+func WithNsqChannel(channel string) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if channel == "" {
+			return
+		}
+		options.Channel = channel
+	}
+}
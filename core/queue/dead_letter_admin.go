@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"sync"
+)
+
+// ErrNotSupported is returned by the queues not implementing an optional operation
+var ErrNotSupported = errors.New("queue: operation is not supported")
+
+// IDeadLetterAdmin is implemented by the queues able to inspect and settle their dead-letter queues
+type IDeadLetterAdmin interface {
+	// DeadLetters returns the size of the dead-letter queue of every consumer registered with one
+	DeadLetters(ctx context.Context) (map[string]int64, error)
+	// RequeueDeadLetters moves up to limit messages of the dead-letter queue back to the queue they failed on,
+	// limit <= 0 moves all of them, it returns the number of messages moved
+	RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error)
+	// PurgeDeadLetters removes the messages of the dead-letter queue and returns their number
+	PurgeDeadLetters(ctx context.Context, name string) (int, error)
+}
+
+// DeadLetterQueues is embedded by the queue implementations to remember the dead-letter queue of each consumer
+type DeadLetterQueues struct {
+	mux     sync.RWMutex
+	origins map[string]string
+}
+
+// Add records the dead-letter queue of the consumer of name, if it has one
+func (d *DeadLetterQueues) Add(name string, options ConsumeOptions) {
+	if !options.DeadLetter.Enabled() {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.origins == nil {
+		d.origins = map[string]string{}
+	}
+	d.origins[options.DeadLetter.GetQueue(name)] = name
+}
+
+// Names returns the dead-letter queues with the queue their messages failed on
+func (d *DeadLetterQueues) Names() map[string]string {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	names := make(map[string]string, len(d.origins))
+	for dlq, origin := range d.origins {
+		names[dlq] = origin
+	}
+	return names
+}
+
+// Origin returns the queue the messages of the dead-letter queue failed on
+func (d *DeadLetterQueues) Origin(name string) (string, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	origin, ok := d.origins[name]
+	if !ok {
+		return "", fmt.Errorf("queue: %s is not the dead-letter queue of a consumer", name)
+	}
+	return origin, nil
+}
+
+// Requeue restores a dead-lettered msg as a new delivery to the queue name, it undoes SetDeadLetter
+func Requeue(msg message.IMessage, name string) {
+	values := msg.GetValues()
+	delete(values, message.ErrorKey)
+	delete(values, message.ErrorCountKey)
+	msg.SetValues(values)
+	msg.SetErrorCount(0)
+	msg.SetRoutingKey(name)
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"sync"
+)
+
+// ErrShuttingDown is returned for deliveries arriving after Shutdown started,
+// the queue implementations give them back to the broker without counting a failure
+var ErrShuttingDown = errors.New("queue is shutting down")
+
+// Drain is embedded by the queue implementations to stop accepting deliveries
+// and wait for the running handlers on Shutdown
+type Drain struct {
+	mux     sync.RWMutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+// Track wraps f so its calls are waited for by Close, calls after Close return ErrShuttingDown
+func (d *Drain) Track(f ConsumerFunc) ConsumerFunc {
+	return func(ctx context.Context, msg message.IMessage) error {
+		d.mux.RLock()
+		if d.closed {
+			d.mux.RUnlock()
+			return ErrShuttingDown
+		}
+		d.running.Add(1)
+		d.mux.RUnlock()
+		defer d.running.Done()
+		return f(ctx, msg)
+	}
+}
+
+// TrackBatch wraps f like Track, every message of a batch received after Close gets ErrShuttingDown
+func (d *Drain) TrackBatch(f BatchConsumerFunc) BatchConsumerFunc {
+	return func(ctx context.Context, msgs []message.IMessage) []error {
+		d.mux.RLock()
+		if d.closed {
+			d.mux.RUnlock()
+			errs := make([]error, len(msgs))
+			for i := range errs {
+				errs[i] = ErrShuttingDown
+			}
+			return errs
+		}
+		d.running.Add(1)
+		d.mux.RUnlock()
+		defer d.running.Done()
+		return f(ctx, msgs)
+	}
+}
+
+// Closed reports whether Close has been called
+func (d *Drain) Closed() bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return d.closed
+}
+
+// Close 停止接收新消息, 等待处理中的消息完成, ctx结束时返回ctx.Err()
+func (d *Drain) Close(ctx context.Context) error {
+	d.mux.Lock()
+	d.closed = true
+	d.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
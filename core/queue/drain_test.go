@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"testing"
+	"time"
+)
+
+func TestDrain_Close(t *testing.T) {
+	var d Drain
+	release := make(chan struct{})
+	started := make(chan struct{})
+	f := d.Track(func(ctx context.Context, msg message.IMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	go f(context.Background(), &testMessage{})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() with a running handler error = %v", err)
+	}
+	if err := f(context.Background(), &testMessage{}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("call after Close() error = %v, want %v", err, ErrShuttingDown)
+	}
+	close(release)
+	if err := d.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !d.Closed() {
+		t.Error("Closed() = false")
+	}
+}
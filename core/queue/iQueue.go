@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"github.com/168yy/plus-core/core/v2/message"
+	"time"
 )
 
 type IQueue interface {
@@ -12,3 +13,16 @@ type IQueue interface {
 	Run(ctx context.Context)
 	Shutdown(ctx context.Context)
 }
+
+// IPausable is implemented by the queues able to stop fetching from the broker,
+// Pause stops the deliveries to every consumer, the messages stay in the broker until Resume
+type IPausable interface {
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+}
+
+// IDelayLimited is implemented by the queues unable to delay a message longer than MaxDelay,
+// shorter delays may still be rounded up to the precision of the queue, see WithPublishOptionsDelay
+type IDelayLimited interface {
+	MaxDelay() time.Duration
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	metrics "github.com/168yy/gf-metrics"
+	"github.com/168yy/plus-core/core/v2/message"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queue metrics registered by EnableMetrics, all labelled by MetricLabels
+const (
+	MetricPublished = "queue_published_total"
+	MetricConsumed  = "queue_consumed_total"
+	MetricFailed    = "queue_failed_total"
+	MetricRequeued  = "queue_requeued_total"
+	MetricDuration  = "queue_consume_duration_seconds"
+)
+
+// MetricLabels are backend (IQueue.String()), queue (consumer name, the routing key when publishing) and routing_key
+var MetricLabels = []string{"backend", "queue", "routing_key"}
+
+// DefaultMetricBuckets are the consume duration buckets in seconds used when EnableMetrics gets none
+var DefaultMetricBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+var monitor atomic.Pointer[metrics.Monitor]
+
+// ConsumeStats counts the messages handled by the consumers of a backend in this process,
+// they are the observations reported to MetricConsumed and MetricFailed
+type ConsumeStats struct {
+	// Handled counts the messages handled with or without error
+	Handled uint64
+	Failed  uint64
+	// Since is when the first message was handled
+	Since time.Time
+}
+
+type consumeStats struct {
+	handled uint64
+	failed  uint64
+	since   time.Time
+}
+
+// stats of each backend, keyed by IQueue.String()
+var stats sync.Map
+
+// GetConsumeStats 返回backend在本进程的消费计数, 不依赖是否EnableMetrics
+func GetConsumeStats(system string) ConsumeStats {
+	v, ok := stats.Load(system)
+	if !ok {
+		return ConsumeStats{}
+	}
+	s := v.(*consumeStats)
+	return ConsumeStats{
+		Handled: atomic.LoadUint64(&s.handled),
+		Failed:  atomic.LoadUint64(&s.failed),
+		Since:   s.since,
+	}
+}
+
+// EnableMetrics 在m上注册队列指标, 之后所有队列实现的生产和消费都会上报, 指标在m的metricPath上暴露
+func EnableMetrics(m *metrics.Monitor, buckets ...float64) error {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricBuckets
+	}
+	list := []*metrics.Metric{
+		{Type: metrics.Counter, Name: MetricPublished, Description: "the messages published.", Labels: MetricLabels},
+		{Type: metrics.Counter, Name: MetricConsumed, Description: "the messages handled without error.", Labels: MetricLabels},
+		{Type: metrics.Counter, Name: MetricFailed, Description: "the messages whose handler returned an error.", Labels: MetricLabels},
+		{Type: metrics.Counter, Name: MetricRequeued, Description: "the messages delivered again after a failure.", Labels: MetricLabels},
+		{Type: metrics.Histogram, Name: MetricDuration, Description: "the time the handler took to process a message.", Labels: MetricLabels, Buckets: buckets},
+	}
+	for _, metric := range list {
+		if m.GetMetric(metric.Name).Type != metrics.None {
+			continue
+		}
+		if err := m.AddMetric(metric); err != nil {
+			return err
+		}
+	}
+	monitor.Store(m)
+	return nil
+}
+
+// ObservePublish 上报一条成功发布的消息, 各队列实现在Publish中调用
+func ObservePublish(system string, msg message.IMessage, err error) {
+	m := monitor.Load()
+	if m == nil || err != nil {
+		return
+	}
+	_ = m.GetMetric(MetricPublished).Inc([]string{system, msg.GetRoutingKey(), msg.GetRoutingKey()})
+}
+
+// observeConsume 上报一条消息的处理结果, 错误次数大于0的消息同时计入重新投递
+func observeConsume(system, queueName string, msg message.IMessage, duration time.Duration, err error) {
+	if errors.Is(err, ErrShuttingDown) {
+		return
+	}
+	v, ok := stats.Load(system)
+	if !ok {
+		v, _ = stats.LoadOrStore(system, &consumeStats{since: time.Now()})
+	}
+	s := v.(*consumeStats)
+	atomic.AddUint64(&s.handled, 1)
+	if err != nil {
+		atomic.AddUint64(&s.failed, 1)
+	}
+	m := monitor.Load()
+	if m == nil {
+		return
+	}
+	labels := []string{system, queueName, msg.GetRoutingKey()}
+	if msg.GetErrorCount() > 0 {
+		_ = m.GetMetric(MetricRequeued).Inc(labels)
+	}
+	if err != nil {
+		_ = m.GetMetric(MetricFailed).Inc(labels)
+	} else {
+		_ = m.GetMetric(MetricConsumed).Inc(labels)
+	}
+	_ = m.GetMetric(MetricDuration).Observe(labels, duration.Seconds())
+}
+
+// MetricsConsumerFunc 上报f处理每条消息的结果和耗时, 各队列实现在Consumer中包装
+func MetricsConsumerFunc(system, queueName string, f ConsumerFunc) ConsumerFunc {
+	return func(ctx context.Context, msg message.IMessage) error {
+		start := time.Now()
+		err := f(ctx, msg)
+		observeConsume(system, queueName, msg, time.Since(start), err)
+		return err
+	}
+}
+
+// MetricsBatchConsumerFunc 上报批次中每条消息的结果, 耗时为整个批次的处理时间, 由原生批量消费的队列实现包装
+func MetricsBatchConsumerFunc(system, queueName string, f BatchConsumerFunc) BatchConsumerFunc {
+	return func(ctx context.Context, msgs []message.IMessage) []error {
+		start := time.Now()
+		errs := f(ctx, msgs)
+		duration := time.Since(start)
+		for i, msg := range msgs {
+			observeConsume(system, queueName, msg, duration, BatchResult(errs, i))
+		}
+		return errs
+	}
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	metrics "github.com/168yy/gf-metrics"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+// gathered returns the counter value, or the histogram sample count, of the metric with the labels
+func gathered(t *testing.T, name string, labels ...string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for i, pair := range m.GetLabel() {
+				if pair.GetValue() != labels[i] {
+					continue next
+				}
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestEnableMetrics(t *testing.T) {
+	// the metrics are global, the test asserts what it adds so that it can run again in the same process
+	names := []string{MetricPublished, MetricConsumed, MetricFailed, MetricRequeued, MetricDuration}
+	before := map[string]float64{}
+	for _, name := range names {
+		before[name] = gathered(t, name, "test", "orders", "orders")
+	}
+	beforeStats := GetConsumeStats("test")
+	// a message published before EnableMetrics is not counted, unless an earlier run enabled them
+	published := 1.0
+	if monitor.Load() != nil {
+		published = 2
+	}
+	ObservePublish("test", &testMessage{routingKey: "orders"}, nil)
+	if err := EnableMetrics(metrics.GetMonitor()); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableMetrics(metrics.GetMonitor()); err != nil {
+		t.Fatal("enable twice:", err)
+	}
+	ObservePublish("test", &testMessage{routingKey: "orders"}, nil)
+	ObservePublish("test", &testMessage{routingKey: "orders"}, errors.New("closed"))
+	f := MetricsConsumerFunc("test", "orders", func(ctx context.Context, msg message.IMessage) error {
+		if msg.GetId() == "bad" {
+			return errors.New("bad")
+		}
+		return nil
+	})
+	_ = f(context.Background(), &testMessage{id: "ok", routingKey: "orders"})
+	_ = f(context.Background(), &testMessage{id: "bad", routingKey: "orders"})
+	_ = f(context.Background(), &testMessage{id: "ok", routingKey: "orders", errorCount: 1})
+	batch := MetricsBatchConsumerFunc("test", "orders", func(ctx context.Context, msgs []message.IMessage) []error {
+		return []error{nil, errors.New("bad")}
+	})
+	batch(context.Background(), []message.IMessage{&testMessage{routingKey: "orders"}, &testMessage{routingKey: "orders"}})
+
+	for name, want := range map[string]float64{
+		MetricPublished: published,
+		MetricConsumed:  3,
+		MetricFailed:    2,
+		MetricRequeued:  1,
+		MetricDuration:  5,
+	} {
+		if got := gathered(t, name, "test", "orders", "orders") - before[name]; got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if stats := GetConsumeStats("test"); stats.Handled-beforeStats.Handled != 5 || stats.Failed-beforeStats.Failed != 2 {
+		t.Errorf("GetConsumeStats = %+v", stats)
+	}
+}
@@ -0,0 +1,49 @@
+package queue
+
+import "sync"
+
+// Middleware wraps a ConsumerFunc, e.g. to recover panics or log every message
+type Middleware func(ConsumerFunc) ConsumerFunc
+
+// IMiddleware is implemented by queues accepting middlewares for all their consumers
+type IMiddleware interface {
+	Use(middlewares ...Middleware)
+}
+
+// Chain wraps f with the middlewares, the first middleware is the outermost
+func Chain(f ConsumerFunc, middlewares ...Middleware) ConsumerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](f)
+	}
+	return f
+}
+
+// WithConsumeOptionsMiddlewares returns a function that adds middlewares to the consumer
+func WithConsumeOptionsMiddlewares(middlewares ...Middleware) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		options.Middlewares = append(options.Middlewares, middlewares...)
+	}
+}
+
+// MiddlewareChain is embedded by the queue implementations to provide IMiddleware
+type MiddlewareChain struct {
+	mux         sync.RWMutex
+	middlewares []Middleware
+}
+
+// Use 注册队列级中间件, 对之后注册的消费者生效
+func (c *MiddlewareChain) Use(middlewares ...Middleware) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// Wrap 按队列中间件、消费者中间件的顺序包装f
+func (c *MiddlewareChain) Wrap(f ConsumerFunc, options ConsumeOptions) ConsumerFunc {
+	c.mux.RLock()
+	middlewares := make([]Middleware, 0, len(c.middlewares)+len(options.Middlewares))
+	middlewares = append(middlewares, c.middlewares...)
+	c.mux.RUnlock()
+	middlewares = append(middlewares, options.Middlewares...)
+	return Chain(f, middlewares...)
+}
@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IdempotencyState is the state of a message key in the IdempotencyStore
+type IdempotencyState int
+
+const (
+	IdempotencyClaimed  IdempotencyState = iota // claimed by this delivery
+	IdempotencyInFlight                         // being processed by another delivery
+	IdempotencyDone                             // already processed
+)
+
+const (
+	DefaultIdempotencyTTL      = 24 * time.Hour
+	DefaultIdempotencyInFlight = 5 * time.Minute
+)
+
+// ErrInFlight is returned for a duplicate delivered while the message is still processed,
+// the duplicate is redelivered later instead of being dropped in case the processing fails
+var ErrInFlight = errors.New("queue: message is in flight")
+
+// IdempotencyStore remembers the processed message keys
+type IdempotencyStore interface {
+	// Claim marks key in flight for inFlight unless it is in flight or done
+	Claim(ctx context.Context, key string, inFlight time.Duration) (IdempotencyState, error)
+	// Done marks key processed for ttl
+	Done(ctx context.Context, key string, ttl time.Duration) error
+	// Release forgets key so that a failed message can be processed again
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyKeyFunc returns the key identifying a message, empty keys are not deduplicated
+type IdempotencyKeyFunc func(msg message.IMessage) string
+
+// IdempotencyKey uses the routing key and message id
+func IdempotencyKey(msg message.IMessage) string {
+	if msg.GetId() == "" {
+		return ""
+	}
+	return msg.GetRoutingKey() + ":" + msg.GetId()
+}
+
+// IdempotencyOptions describe how long the message keys are kept
+type IdempotencyOptions struct {
+	// TTL keeps processed keys, DefaultIdempotencyTTL if 0
+	TTL time.Duration
+	// InFlight bounds the processing of a message, the key is claimable again once it expires, DefaultIdempotencyInFlight if 0
+	InFlight time.Duration
+	// Key identifies a message, IdempotencyKey if nil
+	Key IdempotencyKeyFunc
+}
+
+// Idempotency 跳过已处理的重复消息, 处理中的重复消息返回ErrInFlight等待重投, 处理失败的消息可再次处理
+func Idempotency(store IdempotencyStore, options IdempotencyOptions) Middleware {
+	if options.TTL <= 0 {
+		options.TTL = DefaultIdempotencyTTL
+	}
+	if options.InFlight <= 0 {
+		options.InFlight = DefaultIdempotencyInFlight
+	}
+	if options.Key == nil {
+		options.Key = IdempotencyKey
+	}
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			key := options.Key(msg)
+			if key == "" {
+				return next(ctx, msg)
+			}
+			state, err := store.Claim(ctx, key, options.InFlight)
+			if err != nil {
+				return err
+			}
+			switch state {
+			case IdempotencyDone:
+				glog.Debug(ctx, "queue duplicate message skipped:", key)
+				return nil
+			case IdempotencyInFlight:
+				return ErrInFlight
+			}
+			err = next(ctx, msg)
+			if err != nil {
+				if rErr := store.Release(ctx, key); rErr != nil {
+					glog.Warning(ctx, "queue idempotency release error:", rErr)
+				}
+				return err
+			}
+			if dErr := store.Done(ctx, key, options.TTL); dErr != nil {
+				glog.Warning(ctx, "queue idempotency done error:", dErr)
+			}
+			return nil
+		}
+	}
+}
+
+// memoryIdempotencySweep is the number of claims between two sweeps of the expired keys
+const memoryIdempotencySweep = 1024
+
+type memoryIdempotencyEntry struct {
+	done    bool
+	expires time.Time
+}
+
+// MemoryIdempotencyStore keeps the keys in process, only suitable for a single instance
+type MemoryIdempotencyStore struct {
+	mux    sync.Mutex
+	keys   map[string]memoryIdempotencyEntry
+	claims atomic.Uint64
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		keys: make(map[string]memoryIdempotencyEntry),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, key string, inFlight time.Duration) (IdempotencyState, error) {
+	now := time.Now()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.claims.Add(1)%memoryIdempotencySweep == 0 {
+		for k, e := range s.keys {
+			if !e.expires.After(now) {
+				delete(s.keys, k)
+			}
+		}
+	}
+	if e, ok := s.keys[key]; ok && e.expires.After(now) {
+		if e.done {
+			return IdempotencyDone, nil
+		}
+		return IdempotencyInFlight, nil
+	}
+	s.keys[key] = memoryIdempotencyEntry{expires: now.Add(inFlight)}
+	return IdempotencyClaimed, nil
+}
+
+func (s *MemoryIdempotencyStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.keys[key] = memoryIdempotencyEntry{done: true, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.keys, key)
+	return nil
+}
@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"golang.org/x/time/rate"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testMessage is a minimal message.IMessage, core has no implementation of its own
+type testMessage struct {
+	id, routingKey string
+	values         map[string]interface{}
+	headers        map[string]string
+	errorCount     uint64
+}
+
+func (m *testMessage) SetId(id string)                         { m.id = id }
+func (m *testMessage) GetId() string                           { return m.id }
+func (m *testMessage) SetRoutingKey(routingKey string)         { m.routingKey = routingKey }
+func (m *testMessage) GetRoutingKey() string                   { return m.routingKey }
+func (m *testMessage) SetValues(values map[string]interface{}) { m.values = values }
+func (m *testMessage) GetValues() map[string]interface{}       { return m.values }
+func (m *testMessage) GetPrefix() string                       { return "" }
+func (m *testMessage) SetPrefix(string)                        {}
+func (m *testMessage) SetErrorIncr()                           { m.errorCount++ }
+func (m *testMessage) SetErrorCount(count uint64)              { m.errorCount = count }
+func (m *testMessage) GetErrorCount() uint64                   { return m.errorCount }
+func (m *testMessage) SetHeaders(headers map[string]string)    { m.headers = headers }
+func (m *testMessage) GetHeaders() map[string]string           { return m.headers }
+func (m *testMessage) GetHeader(key string) string             { return m.headers[key] }
+func (m *testMessage) SetHeader(key, value string) {
+	if m.headers == nil {
+		m.headers = make(map[string]string)
+	}
+	m.headers[key] = value
+}
+
+func TestMiddlewareChain_Wrap(t *testing.T) {
+	var calls []string
+	named := func(name string) Middleware {
+		return func(next ConsumerFunc) ConsumerFunc {
+			return func(ctx context.Context, msg message.IMessage) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	var c MiddlewareChain
+	c.Use(named("queue"))
+	options := GetDefaultConsumeOptions()
+	WithConsumeOptionsMiddlewares(named("consumer1"), named("consumer2"))(&options)
+	f := c.Wrap(func(ctx context.Context, msg message.IMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	}, options)
+	if err := f(context.Background(), &testMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"queue", "consumer1", "consumer2", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	f := Recovery()(func(ctx context.Context, msg message.IMessage) error {
+		panic("boom")
+	})
+	if err := f(context.Background(), &testMessage{}); err == nil {
+		t.Error("Recovery() error = nil, want panic error")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := Timeout(10 * time.Millisecond)(func(ctx context.Context, msg message.IMessage) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err := f(context.Background(), &testMessage{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Timeout() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the handler has returned once Timeout returns, its panic reaches Recovery
+	returned := false
+	f = Recovery()(Timeout(time.Millisecond)(func(ctx context.Context, msg message.IMessage) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		returned = true
+		panic("late")
+	}))
+	if err := f(context.Background(), &testMessage{}); err == nil || !returned {
+		t.Errorf("Timeout() error = %v, handler returned = %v", err, returned)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	fail := true
+	f := Idempotency(NewMemoryIdempotencyStore(), IdempotencyOptions{})(func(ctx context.Context, msg message.IMessage) error {
+		calls++
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	msg := &testMessage{id: "1", routingKey: "test"}
+	ctx := context.Background()
+	if err := f(ctx, msg); err == nil {
+		t.Fatal("first delivery error = nil")
+	}
+	// a failed message is processed again, a processed one is skipped
+	fail = false
+	for i := 0; i < 2; i++ {
+		if err := f(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler calls = %d, want 2", calls)
+	}
+}
+
+func TestIdempotency_InFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	f := Idempotency(NewMemoryIdempotencyStore(), IdempotencyOptions{})(func(ctx context.Context, msg message.IMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	ctx := context.Background()
+	done := make(chan error)
+	go func() {
+		done <- f(ctx, &testMessage{id: "1", routingKey: "test"})
+	}()
+	<-started
+	if err := f(ctx, &testMessage{id: "1", routingKey: "test"}); !errors.Is(err, ErrInFlight) {
+		t.Errorf("concurrent delivery error = %v, want %v", err, ErrInFlight)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	f := RateLimit(rate.NewLimiter(rate.Every(20*time.Millisecond), 1))(func(ctx context.Context, msg message.IMessage) error {
+		return nil
+	})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := f(context.Background(), &testMessage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 messages took %v, want at least 40ms", elapsed)
+	}
+}
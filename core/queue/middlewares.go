@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"golang.org/x/time/rate"
+	"runtime/debug"
+	"time"
+)
+
+// Recovery 将消费者panic转为错误, 消息按失败处理
+func Recovery() Middleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("queue consumer panic: %v", r)
+					glog.Error(ctx, err, "\n", string(debug.Stack()))
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout 限制单条消息的处理时间, 超时后取消ctx并等待处理返回, 消息按失败处理.
+// 处理在当前协程执行, panic由外层的Recovery处理, 不响应ctx的处理不会被中断
+func Timeout(timeout time.Duration) Middleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := next(ctx, msg)
+			if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+				if err == nil {
+					return fmt.Errorf("queue consumer %s: %w", msg.GetRoutingKey(), ctxErr)
+				}
+				return fmt.Errorf("queue consumer %s: %w: %w", msg.GetRoutingKey(), ctxErr, err)
+			}
+			return err
+		}
+	}
+}
+
+// Logging 记录每条消息的处理结果, 失败为warning, 成功为debug
+func Logging(logger *glog.Logger) Middleware {
+	if logger == nil {
+		logger = glog.DefaultLogger()
+	}
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			fields := g.Map{
+				"routingKey": msg.GetRoutingKey(),
+				"id":         msg.GetId(),
+				"errorCount": msg.GetErrorCount(),
+				"duration":   time.Since(start).String(),
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+				logger.Warning(ctx, "queue consume failed", fields)
+			} else {
+				logger.Debug(ctx, "queue consumed", fields)
+			}
+			return err
+		}
+	}
+}
+
+// MetricsRecorder receives the outcome of every consumed message
+type MetricsRecorder interface {
+	Observe(ctx context.Context, msg message.IMessage, duration time.Duration, err error)
+}
+
+// Metrics 上报每条消息的处理耗时和结果
+func Metrics(recorder MetricsRecorder) Middleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			recorder.Observe(ctx, msg, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// RateLimit 限制消费速率, 超出时等待令牌
+func RateLimit(limiter *rate.Limiter) Middleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			err := limiter.Wait(ctx)
+			if err != nil {
+				return err
+			}
+			return next(ctx, msg)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package queue
 
+import "time"
+
 // PublishOptions are used to control how data is published
 type PublishOptions struct {
 	Exchange string
-	// MIME content type
+	// MIME content type, selects the codec on every backend
 	ContentType string
 	// address to reply to (ex: RPC)
 	ReplyTo string
 	// message identifier
 	MessageID string
+	// correlates a reply with its request (ex: RPC)
+	CorrelationID string
 	// creating user id - ex: "guest"
 	UserID string
 	// creating application id
@@ -17,4 +21,21 @@ type PublishOptions struct {
 	// rocketmq
 	GroupName  string
 	RetryTimes int
+
+	// kafka and rocketmq, the topic written to, defaults to the routing key.
+	// kafka keeps the routing key as the partition key, rocketmq as the tag
+	Topic string
+
+	// rabbitmq, the queue published to through the default exchange, the routing key is kept in a header
+	Queue string
+
+	// delay, honored by every backend
+	Delay     time.Duration
+	DeliverAt time.Time
+
+	// priority, honored by memory, redis and rabbitmq
+	Priority uint8
+
+	// ordering key, messages sharing it are handled in order by the memory consumers
+	OrderingKey string
 }
@@ -0,0 +1,29 @@
+package queue
+
+import (
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+)
+
+// WithPublishOptionsCodec returns a function that encodes the message with the codec instead of the queue default
+func WithPublishOptionsCodec(codec messageLib.Codec) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.ContentType = codec.ContentType()
+	}
+}
+
+// GetCodec returns the codec of ContentType, def is used when no content type is set
+func (o *PublishOptions) GetCodec(def messageLib.Codec) (messageLib.Codec, error) {
+	if o.ContentType == "" {
+		if def == nil {
+			def = messageLib.DefaultCodec
+		}
+		o.ContentType = def.ContentType()
+		return def, nil
+	}
+	codec, ok := messageLib.GetCodec(o.ContentType)
+	if !ok {
+		return nil, fmt.Errorf("queue: no codec registered for content type %s", o.ContentType)
+	}
+	return codec, nil
+}
@@ -0,0 +1,33 @@
+package queue
+
+import "time"
+
+// WithPublishOptionsDelay returns a function that delays the delivery of the message by delay.
+// A backend may round delay up to its precision, rabbitmq keeps two significant digits in seconds (61m is 61m40s)
+// and rocketmq takes the next delay level, the message is never delivered earlier
+func WithPublishOptionsDelay(delay time.Duration) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.Delay = delay
+	}
+}
+
+// WithPublishOptionsDeliverAt returns a function that delays the delivery of the message until deliverAt
+func WithPublishOptionsDeliverAt(deliverAt time.Time) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.DeliverAt = deliverAt
+	}
+}
+
+// GetDelay returns how long the delivery must be delayed from now, the later one wins if both Delay and DeliverAt are set
+func (o *PublishOptions) GetDelay() time.Duration {
+	delay := o.Delay
+	if !o.DeliverAt.IsZero() {
+		if until := time.Until(o.DeliverAt); until > delay {
+			delay = until
+		}
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
@@ -0,0 +1,8 @@
+package queue
+
+// WithKafkaPublishOptionsTopic returns a function that sets the kafka topic, the routing key stays the partition key
+func WithKafkaPublishOptionsTopic(topic string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.Topic = topic
+	}
+}
@@ -0,0 +1,25 @@
+package queue
+
+import (
+	"github.com/168yy/plus-core/core/v2/message"
+)
+
+// WithPublishOptionsOrderingKey returns a function that sets the ordering key of the message,
+// the concurrent memory consumers handle the messages sharing a key one after another
+func WithPublishOptionsOrderingKey(key string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.OrderingKey = key
+	}
+}
+
+// SetOrderingKey records the ordering key in the message headers
+func SetOrderingKey(msg message.IMessage, key string) {
+	if key != "" {
+		msg.SetHeader(message.HeaderOrderingKey, key)
+	}
+}
+
+// GetOrderingKey returns the ordering key recorded in the message headers
+func GetOrderingKey(msg message.IMessage) string {
+	return msg.GetHeader(message.HeaderOrderingKey)
+}
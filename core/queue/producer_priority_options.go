@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"github.com/168yy/plus-core/core/v2/message"
+	"strconv"
+)
+
+// MaxPriority is the highest message priority, rabbitmq recommends at most 10 priorities
+const MaxPriority uint8 = 9
+
+// WithPublishOptionsPriority returns a function that sets the priority of the message from 0 to MaxPriority,
+// it is honored by the queues consumed with WithConsumeOptionsMaxPriority and limited to their max priority
+func WithPublishOptionsPriority(priority uint8) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.Priority = priority
+	}
+}
+
+// GetPriority returns the priority limited to max
+func (o *PublishOptions) GetPriority(max uint8) uint8 {
+	if o.Priority > max {
+		return max
+	}
+	return o.Priority
+}
+
+// WithConsumeOptionsMaxPriority returns a function that declares the consumed queue with priorities from 0 to max
+func WithConsumeOptionsMaxPriority(max uint8) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if max > MaxPriority {
+			max = MaxPriority
+		}
+		options.MaxPriority = max
+	}
+}
+
+// PriorityWeight is the share of the deliveries given to a priority when the queues drain several priorities,
+// a message of priority p is delivered p+1 times as often as one of priority 0
+func PriorityWeight(priority uint8) int {
+	return int(priority) + 1
+}
+
+// SetPriority records the priority in the message headers
+func SetPriority(msg message.IMessage, priority uint8) {
+	if priority > 0 {
+		msg.SetHeader(message.HeaderPriority, strconv.Itoa(int(priority)))
+	}
+}
+
+// GetPriority returns the priority recorded in the message headers
+func GetPriority(msg message.IMessage) uint8 {
+	p, err := strconv.ParseUint(msg.GetHeader(message.HeaderPriority), 10, 8)
+	if err != nil {
+		return 0
+	}
+	return uint8(p)
+}
@@ -7,6 +7,13 @@ func WithRabbitMqPublishOptionsExchange(exchange string) func(*PublishOptions) {
 	}
 }
 
+// WithRabbitMqPublishOptionsQueue returns a function that publishes to the queue only, through the default exchange
+func WithRabbitMqPublishOptionsQueue(queue string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.Queue = queue
+	}
+}
+
 // WithRabbitMqPublishOptionsContentType returns a function that sets the content type, i.e. "application/json"
 func WithRabbitMqPublishOptionsContentType(contentType string) func(*PublishOptions) {
 	return func(options *PublishOptions) {
@@ -16,3 +16,10 @@ func WithRocketMqPublishRetry(times int) func(*PublishOptions) {
 		options.RetryTimes = times
 	}
 }
+
+// WithRocketMqPublishTopic returns a function that sends the message to topic, its routing key is sent as the tag
+func WithRocketMqPublishTopic(topic string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.Topic = topic
+	}
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/gogf/gf/v2/util/gconv"
+	"sync"
+)
+
+const (
+	// ReplyToKey is the header holding the reply queue name of a request
+	ReplyToKey = "reply-to"
+	// CorrelationIdKey is the header holding the id matching a reply with its request
+	CorrelationIdKey = "correlation-id"
+	// ReplyDataKey holds the handler result of a reply
+	ReplyDataKey = "data"
+	// ReplyQueuePrefix is the name prefix of the temporary reply queues
+	ReplyQueuePrefix = "reply."
+)
+
+// IRequester is implemented by the queues supporting request/reply over the queue
+type IRequester interface {
+	IQueue
+	// Request publishes the message and waits for its reply until the context is done
+	Request(ctx context.Context, message message.IMessage, optionFuncs ...func(*PublishOptions)) (message.IMessage, error)
+	// Reply sends the handler result of the request back to the requester
+	Reply(ctx context.Context, request message.IMessage, data interface{}, err error) error
+}
+
+// WithPublishOptionsCorrelationID returns a function that sets the correlation id
+func WithPublishOptionsCorrelationID(correlationID string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		options.CorrelationID = correlationID
+	}
+}
+
+// GetReplyTo returns the reply queue name of a request, empty if no reply is expected
+func GetReplyTo(msg message.IMessage) string {
+	return msg.GetHeader(ReplyToKey)
+}
+
+// GetCorrelationId returns the correlation id of a request or reply
+func GetCorrelationId(msg message.IMessage) string {
+	return msg.GetHeader(CorrelationIdKey)
+}
+
+// GetReplyValues builds the values of a reply carrying the handler result
+func GetReplyValues(data interface{}, err error) map[string]interface{} {
+	values := map[string]interface{}{
+		ReplyDataKey: data,
+	}
+	if err != nil {
+		values[message.ErrorKey] = err.Error()
+	}
+	return values
+}
+
+// Pending matches replies with the in-flight requests by correlation id
+type Pending struct {
+	calls sync.Map
+}
+
+// Add registers a request waiting for its reply
+func (p *Pending) Add(correlationId string) chan message.IMessage {
+	ch := make(chan message.IMessage, 1)
+	p.calls.Store(correlationId, ch)
+	return ch
+}
+
+// Remove forgets a request, late replies will be dropped
+func (p *Pending) Remove(correlationId string) {
+	p.calls.Delete(correlationId)
+}
+
+// Resolve hands the reply to its waiting request, false if nobody is waiting anymore
+func (p *Pending) Resolve(reply message.IMessage) bool {
+	v, ok := p.calls.LoadAndDelete(GetCorrelationId(reply))
+	if !ok {
+		return false
+	}
+	v.(chan message.IMessage) <- reply
+	return true
+}
+
+// Wait blocks until the reply arrives or the context is done,
+// an error recorded in the reply is returned with it
+func (p *Pending) Wait(ctx context.Context, correlationId string, ch chan message.IMessage) (message.IMessage, error) {
+	select {
+	case reply := <-ch:
+		if e, ok := reply.GetValues()[message.ErrorKey]; ok {
+			return reply, errors.New(gconv.String(e))
+		}
+		return reply, nil
+	case <-ctx.Done():
+		p.Remove(correlationId)
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strconv"
+	"time"
+)
+
+const tracerName = "github.com/168yy/plus-core/core/v2/queue"
+
+// Propagator carries the trace in message headers, W3C traceparent and baggage
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Publisher is written to the HeaderPublisher of the published messages, the host name by default
+var Publisher, _ = os.Hostname()
+
+// headerCarrier adapts message headers to propagation.TextMapCarrier
+type headerCarrier struct {
+	msg message.IMessage
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.msg.GetHeader(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.msg.SetHeader(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.GetHeaders()))
+	for k := range c.msg.GetHeaders() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// StartPublishSpan 创建生产者span并将trace写入消息头, 需调用EndSpan结束.
+// 同时写入HeaderTraceId, 未设置时写入HeaderTimestamp(毫秒)和HeaderPublisher, 重新发布的消息保留原值.
+// 消息头先复制再写入, 不修改调用方传入的map
+func StartPublishSpan(ctx context.Context, system string, msg message.IMessage) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, msg.GetRoutingKey()+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationKey.String(msg.GetRoutingKey()),
+		),
+	)
+	headers := make(map[string]string, len(msg.GetHeaders())+5)
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	msg.SetHeaders(headers)
+	Propagator.Inject(ctx, headerCarrier{msg: msg})
+	setPublishHeaders(span, msg)
+	return ctx, span
+}
+
+// setPublishHeaders writes the well-known headers of a published message
+func setPublishHeaders(span trace.Span, msg message.IMessage) {
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		msg.SetHeader(message.HeaderTraceId, sc.TraceID().String())
+	}
+	if msg.GetHeader(message.HeaderTimestamp) == "" {
+		msg.SetHeader(message.HeaderTimestamp, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	}
+	if Publisher != "" && msg.GetHeader(message.HeaderPublisher) == "" {
+		msg.SetHeader(message.HeaderPublisher, Publisher)
+	}
+}
+
+// StartConsumeSpan 从消息头恢复trace并创建消费者span, 需调用EndSpan结束
+func StartConsumeSpan(ctx context.Context, system, queueName string, msg message.IMessage) (context.Context, trace.Span) {
+	ctx = Propagator.Extract(ctx, headerCarrier{msg: msg})
+	return otel.Tracer(tracerName).Start(ctx, queueName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationKey.String(queueName),
+			semconv.MessagingMessageIDKey.String(msg.GetId()),
+			semconv.MessagingOperationProcess,
+		),
+	)
+}
+
+// EndSpan 记录错误并结束span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceConsumerFunc 在f执行前恢复消息中的trace, 各队列实现在Consumer中包装
+func TraceConsumerFunc(system, queueName string, f ConsumerFunc) ConsumerFunc {
+	return func(ctx context.Context, msg message.IMessage) (err error) {
+		ctx, span := StartConsumeSpan(ctx, system, queueName, msg)
+		defer func() {
+			EndSpan(span, err)
+		}()
+		return f(ctx, msg)
+	}
+}
@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/message"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestStartPublishSpan_Headers(t *testing.T) {
+	traceId := trace.TraceID{1, 2, 3}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	}))
+	headers := map[string]string{"key": "value"}
+	msg := &testMessage{routingKey: "orders", headers: headers}
+	_, span := StartPublishSpan(ctx, "test", msg)
+	EndSpan(span, nil)
+	if len(headers) != 1 {
+		t.Errorf("caller's headers changed: %v", headers)
+	}
+	if got := msg.GetHeader(message.HeaderTraceId); got != traceId.String() {
+		t.Errorf("trace id = %q, want %q", got, traceId.String())
+	}
+	if msg.GetHeader(message.HeaderTimestamp) == "" || msg.GetHeader(message.HeaderPublisher) != Publisher {
+		t.Errorf("headers = %v", msg.GetHeaders())
+	}
+
+	// a republished message keeps when and where it was first published
+	msg.SetHeader(message.HeaderTimestamp, "1")
+	msg.SetHeader(message.HeaderPublisher, "origin")
+	_, span = StartPublishSpan(context.Background(), "test", msg)
+	EndSpan(span, nil)
+	if msg.GetHeader(message.HeaderTimestamp) != "1" || msg.GetHeader(message.HeaderPublisher) != "origin" {
+		t.Errorf("republished headers = %v", msg.GetHeaders())
+	}
+}
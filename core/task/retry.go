@@ -0,0 +1,105 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/os/glog"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRetryInitialDelay is the delay before the first retry when RetryPolicy.InitialDelay is 0
+	DefaultRetryInitialDelay = time.Second
+)
+
+// RetryPolicy 任务失败后按指数退避延时重新发布消息, 重试次数记录在消息头message.HeaderAttempt
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a message is handled including the first one, 0 or 1 disables retrying
+	MaxAttempts int
+	// InitialDelay before the first retry, doubled for every further retry, DefaultRetryInitialDelay if 0
+	InitialDelay time.Duration
+	// MaxDelay caps the delay, 0 leaves it unbounded
+	MaxDelay time.Duration
+	// Jitter randomizes the delay by up to this fraction, 0.2 gives the delay ± 20%
+	Jitter float64
+	// Retryable reports whether the error is retried, nil retries every error but the queue.Permanent ones
+	Retryable func(err error) bool
+}
+
+// Delay returns the delay before the given retry, the first retry is 1
+func (p *RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.InitialDelay
+	if delay <= 0 {
+		delay = DefaultRetryInitialDelay
+	}
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+	return delay
+}
+
+// delayOn returns the delay before the given retry, capped to the longest delay q supports
+func (p *RetryPolicy) delayOn(q queue.IQueue, retry int) time.Duration {
+	delay := p.Delay(retry)
+	if l, ok := q.(queue.IDelayLimited); ok && delay > l.MaxDelay() {
+		delay = l.MaxDelay()
+	}
+	return delay
+}
+
+// retryable reports whether err is retried by the policy
+func (p *RetryPolicy) retryable(err error) bool {
+	if queue.IsPermanent(err) || errors.Is(err, queue.ErrShuttingDown) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// GetAttempt returns the attempt the message is handled in, 1 for the first delivery
+func GetAttempt(msg message.IMessage) int {
+	attempt, err := strconv.Atoi(msg.GetHeader(message.HeaderAttempt))
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// Retry 按policy处理失败的消息: 可重试时以递增的attempt延时重新发布到q并确认本次投递, 延时不超过q支持的最大延时,
+// 次数用尽后返回queue.Permanent错误, 启用死信的消息进入死信队列. routingKey为空时发布到消息的routing key.
+// 需作为第一个消费者中间件, 使幂等等中间件看到本次处理的失败
+func Retry(q queue.IQueue, policy *RetryPolicy, routingKey string, optionFuncs ...func(*queue.PublishOptions)) queue.Middleware {
+	return func(next queue.ConsumerFunc) queue.ConsumerFunc {
+		return func(ctx context.Context, msg message.IMessage) error {
+			err := next(ctx, msg)
+			if err == nil || !policy.retryable(err) {
+				return err
+			}
+			attempt := GetAttempt(msg)
+			if attempt >= policy.MaxAttempts {
+				return queue.Permanent(err)
+			}
+			if routingKey != "" {
+				msg.SetRoutingKey(routingKey)
+			}
+			msg.SetHeader(message.HeaderAttempt, strconv.Itoa(attempt+1))
+			delay := policy.delayOn(q, attempt)
+			options := append([]func(*queue.PublishOptions){queue.WithPublishOptionsDelay(delay)}, optionFuncs...)
+			if pErr := q.Publish(ctx, msg, options...); pErr != nil {
+				glog.Warning(ctx, "task retry publish error:", pErr)
+				return err
+			}
+			glog.Debugf(ctx, "task %s attempt %d failed, retry in %s: %v", msg.GetRoutingKey(), attempt, delay, err)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package task
+
+import (
+	"github.com/168yy/plus-core/core/v2/queue"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := &RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		64: time.Second,
+	} {
+		if got := policy.Delay(retry); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", retry, got, want)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.Delay(2); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("Delay(2) with jitter = %s, want within 200ms ± 50%%", got)
+		}
+	}
+}
+
+// limitedQueue supports delays up to an hour
+type limitedQueue struct {
+	queue.IQueue
+}
+
+func (limitedQueue) MaxDelay() time.Duration {
+	return time.Hour
+}
+
+func TestRetryPolicy_DelayOn(t *testing.T) {
+	policy := &RetryPolicy{InitialDelay: 30 * time.Minute}
+	if got := policy.delayOn(limitedQueue{}, 4); got != time.Hour {
+		t.Errorf("delayOn(4) = %s, want the queue limit 1h", got)
+	}
+	if got := policy.delayOn(limitedQueue{}, 1); got != 30*time.Minute {
+		t.Errorf("delayOn(1) = %s, want 30m", got)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"github.com/168yy/plus-core/core/v2/message"
 	"github.com/168yy/plus-core/core/v2/queue"
 	"github.com/gogf/gf/v2/os/glog"
+	"go.opentelemetry.io/otel"
 )
 
 const (
 	DefaultQueue = "default"
+	tracerName   = "github.com/168yy/plus-core/core/v2/task"
 )
 
 // IHandler 任务MQ路由的回调接口/**/
@@ -21,15 +23,32 @@ type SubTask interface {
 	Handle(ctx context.Context, msg message.IMessage) (interface{}, error)
 }
 
+// TasksService manages the task services of all backends
 type TasksService interface {
 	IService
+	// AddServices registers the services, errors are logged
 	AddServices(services ...IService) TasksService
+	// Register adds the services, rejecting duplicate service names and routing keys
+	Register(ctx context.Context, services ...IService) error
+	// Pause stops handling the messages of the service until Resume
+	Pause(name string) error
+	Resume(name string) error
+	Status(ctx context.Context) []Status
+}
+
+// handle 在子span中执行SubTask, 消费者span由队列实现创建
+func handle(ctx context.Context, handler SubTask, msg message.IMessage) (data interface{}, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "task "+handler.RoutingKey())
+	defer func() {
+		queue.EndSpan(span, err)
+	}()
+	return handler.Handle(ctx, msg)
 }
 
 func WrapHandler(handler SubTask) queue.ConsumerFunc {
 	return queue.ConsumerFunc(
 		func(ctx context.Context, msg message.IMessage) error {
-			_, err := handler.Handle(ctx, msg)
+			_, err := handle(ctx, handler, msg)
 			if err != nil {
 				glog.Error(ctx, "task handler error", err.Error())
 			}
@@ -44,7 +63,7 @@ type CallbackFunc func(context.Context, interface{}) error
 func CallbackWrapHandler(handler SubTask, callback CallbackFunc) queue.ConsumerFunc {
 	return queue.ConsumerFunc(
 		func(ctx context.Context, msg message.IMessage) error {
-			data, err := handler.Handle(ctx, msg)
+			data, err := handle(ctx, handler, msg)
 			if err != nil {
 				glog.Error(ctx, "task handler error", err.Error())
 			}
@@ -56,3 +75,24 @@ func CallbackWrapHandler(handler SubTask, callback CallbackFunc) queue.ConsumerF
 		},
 	)
 }
+
+// ReplyWrapHandler 将SubTask的返回值回复给请求方, 未携带回复队列的消息与WrapHandler一致
+func ReplyWrapHandler(handler SubTask, requester queue.IRequester) queue.ConsumerFunc {
+	return queue.ConsumerFunc(
+		func(ctx context.Context, msg message.IMessage) error {
+			data, err := handle(ctx, handler, msg)
+			if err != nil {
+				glog.Error(ctx, "task handler error", err.Error())
+			}
+			if queue.GetReplyTo(msg) == "" {
+				return err
+			}
+			// the error is delivered to the requester, the request itself is done
+			replyErr := requester.Reply(ctx, msg, data, err)
+			if replyErr != nil {
+				glog.Error(ctx, "task reply error", replyErr.Error())
+			}
+			return replyErr
+		},
+	)
+}
@@ -0,0 +1,33 @@
+package task
+
+import (
+	"context"
+)
+
+type KafkaSpec struct {
+	TaskName  string
+	GroupID   string
+	TopicName string
+	SubTasks  []SubTask
+	// ConsumerNum members of the consumer group, at most one per partition receives messages
+	ConsumerNum int
+}
+
+func (r *KafkaSpec) Route(routingKey string) (handler SubTask, ifExist bool) {
+	for _, subHandler := range r.SubTasks {
+		if subHandler.RoutingKey() == routingKey {
+			return subHandler, true
+		}
+	}
+	return nil, false
+}
+
+type KafkaTask interface {
+	GetSpec(ctx context.Context) *KafkaSpec
+	IHandler
+}
+
+type KafkaService interface {
+	IService
+	AddTasks(task ...KafkaTask) KafkaService
+}
@@ -3,6 +3,10 @@ package task
 type MemorySpec struct {
 	TaskName   string
 	RoutingKey string
+	// Concurrency handlers of the routing key, the messages sharing an ordering key are handled one after another
+	Concurrency int
+	// Retry republishes failed messages with a backoff delay, nil requeues them at once
+	Retry *RetryPolicy
 }
 
 type MemoryTask interface {
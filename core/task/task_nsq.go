@@ -2,17 +2,40 @@ package task
 
 import (
 	"context"
+	"sort"
 )
 
 type NsqSpec struct {
-	TaskName     string
+	TaskName string
+	// RoutingKey is a topic handled by the task itself
 	RoutingKey   string
 	Exchange     string
 	ExchangeType string
-	QueueName    string
-	RoutingMap   map[string]SubTask
-	ConsumerNum  int
-	CTag         string
+	// QueueName is the channel of every topic, empty uses the channel prefix + topic
+	QueueName string
+	// RoutingMap topic => SubTask, every topic gets its own consumer
+	RoutingMap map[string]SubTask
+	// ConsumerNum handlers of each topic
+	ConsumerNum int
+	CTag        string
+}
+
+// GetTopics returns RoutingKey and the topics of RoutingMap
+func (r *NsqSpec) GetTopics() []string {
+	topics := make([]string, 0, len(r.RoutingMap)+1)
+	if _, ok := r.RoutingMap[r.RoutingKey]; r.RoutingKey != "" && !ok {
+		topics = append(topics, r.RoutingKey)
+	}
+	for topic := range r.RoutingMap {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (r *NsqSpec) Route(routingKey string) (handler SubTask, ifExist bool) {
+	handler, ifExist = r.RoutingMap[routingKey]
+	return handler, ifExist && handler != nil
 }
 
 type NsqTask interface {
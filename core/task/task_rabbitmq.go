@@ -17,6 +17,8 @@ type RabbitMqSpec struct {
 	CoroutineNum int
 	Prefetch     int
 	AutoAck      bool
+	// Retry republishes failed messages with a backoff delay, nil leaves them to the broker
+	Retry *RetryPolicy
 }
 
 func (r *RabbitMqSpec) GetRoutingKeys() []string {
@@ -12,6 +12,8 @@ type RocketMqSpec struct {
 	ConsumerNum       int
 	MaxReconsumeTimes int32
 	AutoCommit        bool
+	// Retry republishes failed messages to TopicName with a backoff delay, nil leaves them to the broker
+	Retry *RetryPolicy
 }
 
 func (r *RocketMqSpec) Route(routingKey string) (handler SubTask, ifExist bool) {
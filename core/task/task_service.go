@@ -2,9 +2,62 @@ package task
 
 import (
 	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/queue"
+)
+
+// states of a task service reported by Status
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateStopped = "stopped"
+)
+
+var (
+	// ErrUnknownService is returned for a service name not registered
+	ErrUnknownService = errors.New("task: service is not registered")
+	// ErrDuplicate is returned when a service name or routing key is registered twice
+	ErrDuplicate = errors.New("task: duplicate service or routing key")
 )
 
 type IService interface {
 	String() string
 	Start(ctx context.Context)
+	// Stop 停止消费并等待处理中的任务, ctx结束时不再等待
+	Stop(ctx context.Context)
+}
+
+// IMiddlewareService applies consumer middlewares to every task it starts
+type IMiddlewareService interface {
+	IService
+	Use(middlewares ...queue.Middleware)
+}
+
+// IPausableService is implemented by the services stopping their consumers on Pause,
+// the deliveries of the other services are held by a middleware until Resume
+type IPausableService interface {
+	IService
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+}
+
+// Route is a routing key consumed by a task, Queue scopes it to the queue, topic and group or channel it is consumed from
+type Route struct {
+	Queue      string `json:"queue,omitempty"`
+	RoutingKey string `json:"routingKey"`
+}
+
+// IRoutingService is implemented by the services reporting the routes of their tasks
+type IRoutingService interface {
+	IService
+	// Routes returns the routes of the tasks, a routing key is handled by a single task of a queue
+	Routes(ctx context.Context) []Route
+}
+
+// Status of a registered task service
+type Status struct {
+	Name   string  `json:"name"`
+	State  string  `json:"state"`
+	Routes []Route `json:"routes,omitempty"`
 }
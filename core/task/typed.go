@@ -0,0 +1,66 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/gvalid"
+	"strings"
+)
+
+const (
+	// BodyKey holds the whole payload as an encoded string, e.g. published by the rabbitmq and rocketmq clients
+	BodyKey = "body"
+	// ReservedPrefix prefixes the values added by the queues and the workflow, e.g. message.ErrorKey
+	ReservedPrefix = "__"
+)
+
+// Bind 将消息内容解码到T并按gvalid标签校验, 忽略队列保留的"__"前缀字段, 只有BodyKey一个字段时解码该字段的内容
+func Bind[T any](ctx context.Context, msg message.IMessage) (payload T, err error) {
+	values := make(map[string]interface{}, len(msg.GetValues()))
+	for k, v := range msg.GetValues() {
+		if !strings.HasPrefix(k, ReservedPrefix) {
+			values[k] = v
+		}
+	}
+	var data interface{} = values
+	if body, ok := values[BodyKey]; ok && len(values) == 1 {
+		switch body.(type) {
+		case string, []byte:
+			data = body
+		}
+	}
+	if err = gconv.Scan(data, &payload); err != nil {
+		return payload, fmt.Errorf("task: decode %s payload: %w", msg.GetRoutingKey(), err)
+	}
+	if vErr := gvalid.New().Data(payload).Run(ctx); vErr != nil {
+		return payload, fmt.Errorf("task: invalid %s payload: %w", msg.GetRoutingKey(), vErr)
+	}
+	return payload, nil
+}
+
+// typed is the SubTask returned by Typed
+type typed[T, R any] struct {
+	routingKey string
+	handle     func(ctx context.Context, payload T) (R, error)
+}
+
+// Typed 返回处理T类型消息的SubTask, 消息经Bind解码校验后交给handle,
+// 解码或校验失败时返回queue.Permanent错误, 启用死信的消息不再重试直接进入死信队列, 未启用时确认丢弃
+func Typed[T, R any](routingKey string, handle func(ctx context.Context, payload T) (R, error)) SubTask {
+	return &typed[T, R]{routingKey: routingKey, handle: handle}
+}
+
+func (t *typed[T, R]) RoutingKey() string {
+	return t.routingKey
+}
+
+func (t *typed[T, R]) Handle(ctx context.Context, msg message.IMessage) (interface{}, error) {
+	payload, err := Bind[T](ctx, msg)
+	if err != nil {
+		return nil, queue.Permanent(err)
+	}
+	return t.handle(ctx, payload)
+}
@@ -0,0 +1,312 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"strconv"
+	"time"
+)
+
+const (
+	// WorkflowRoutingKeyPrefix prefixes the name of a workflow to the routing key of its step messages
+	WorkflowRoutingKeyPrefix = "workflow."
+
+	// WorkflowIdKey, WorkflowStepKey and WorkflowPhaseKey are the values of a step message reserved by the workflow
+	WorkflowIdKey    = "__workflow_id"
+	WorkflowStepKey  = "__workflow_step"
+	WorkflowPhaseKey = "__workflow_phase"
+
+	phaseForward    = "forward"
+	phaseCompensate = "compensate"
+)
+
+const (
+	WorkflowRunning      = "running"      // the steps are run forward
+	WorkflowCompensating = "compensating" // a step failed, the completed steps are compensated
+	WorkflowCompleted    = "completed"    // every step succeeded
+	WorkflowCompensated  = "compensated"  // every completed step is compensated
+	WorkflowFailed       = "failed"       // a compensation failed permanently
+)
+
+var (
+	ErrWorkflowNotFound = errors.New("task: workflow not found")
+	ErrWorkflowExists   = errors.New("task: workflow already exists")
+)
+
+// Step 工作流的一步, Forward的返回值合并到后续步骤的输入
+type Step struct {
+	Name string
+	// Forward runs the step, an error compensates the completed steps in reverse order
+	Forward func(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error)
+	// Compensate undoes a completed step, nil if there is nothing to undo.
+	// An error redelivers the compensation, a queue.Permanent error fails the workflow
+	Compensate func(ctx context.Context, data map[string]interface{}) error
+	// Retry runs a failed Forward again before compensating, nil compensates at once
+	Retry *RetryPolicy
+}
+
+// WorkflowState 工作流实例的状态, 每步完成后先保存再发布下一步的消息
+type WorkflowState struct {
+	Id       string `json:"id"`
+	Workflow string `json:"workflow"`
+	Status   string `json:"status"`
+	// Step is the next step run forward, or the next step compensated
+	Step int `json:"step"`
+	// Data is the input of the workflow merged with the outputs of the completed steps
+	Data      map[string]interface{} `json:"data"`
+	Error     string                 `json:"error"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+// Finished reports whether no step message is expected anymore
+func (s *WorkflowState) Finished() bool {
+	return s.Status != WorkflowRunning && s.Status != WorkflowCompensating
+}
+
+// phase returns the phase of the step message the state expects
+func (s *WorkflowState) phase() string {
+	if s.Status == WorkflowCompensating {
+		return phaseCompensate
+	}
+	return phaseForward
+}
+
+// follows reports whether the step message was handled last, its successor may not have been published
+func (s *WorkflowState) follows(phase string, step int) bool {
+	switch s.Status {
+	case WorkflowRunning:
+		return phase == phaseForward && step == s.Step-1
+	case WorkflowCompensating:
+		// a compensated step, or the failed step that started the compensation
+		return step == s.Step+1
+	}
+	return false
+}
+
+// IWorkflowStore 持久化工作流状态, 多实例部署需使用共享的存储
+type IWorkflowStore interface {
+	// Load returns nil if the workflow does not exist
+	Load(ctx context.Context, id string) (*WorkflowState, error)
+	// Create saves a new workflow only if its id does not exist yet, ErrWorkflowExists otherwise
+	Create(ctx context.Context, state *WorkflowState) error
+	Save(ctx context.Context, state *WorkflowState) error
+}
+
+// IWorkflowLister is implemented by the stores able to list the unfinished workflows for Resume
+type IWorkflowLister interface {
+	Unfinished(ctx context.Context, workflow string) ([]*WorkflowState, error)
+}
+
+// Workflow 基于SubTask的saga编排: 每一步由发布到Queue的消息触发, 步骤的输出经消息传给下一步,
+// 某步失败时按相反顺序补偿已完成的步骤. 消息至少投递一次, 步骤和补偿需幂等
+type Workflow struct {
+	Name  string
+	Steps []Step
+	Queue queue.IQueue
+	Store IWorkflowStore
+	// NewMessage creates the step messages, e.g. Runtime.GetQueueMessage
+	NewMessage func(id, routingKey string, values map[string]interface{}) (message.IMessage, error)
+	// PublishOptions are applied to every step message, e.g. the exchange of rabbitmq
+	PublishOptions []func(*queue.PublishOptions)
+}
+
+// RoutingKey 工作流所有步骤消息的routing key, 在Queue上以WrapHandler(w)消费
+func (w *Workflow) RoutingKey() string {
+	return WorkflowRoutingKeyPrefix + w.Name
+}
+
+// Start 以input开始id的工作流, id已存在时返回ErrWorkflowExists
+func (w *Workflow) Start(ctx context.Context, id string, input map[string]interface{}) error {
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("task: workflow %s has no steps", w.Name)
+	}
+	data := make(map[string]interface{}, len(input))
+	for k, v := range input {
+		data[k] = v
+	}
+	now := time.Now()
+	state := &WorkflowState{
+		Id:        id,
+		Workflow:  w.Name,
+		Status:    WorkflowRunning,
+		Data:      data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := w.Store.Create(ctx, state); err != nil {
+		return err
+	}
+	return w.publish(ctx, state, 1, 0)
+}
+
+// Get 返回id的工作流状态, 不存在时返回nil
+func (w *Workflow) Get(ctx context.Context, id string) (*WorkflowState, error) {
+	return w.Store.Load(ctx, id)
+}
+
+// Resume 重新发布未完成工作流当前步骤的消息, 用于重启后继续保存状态后未发布的步骤.
+// ids为空时恢复存储中所有未完成的工作流, 存储需实现IWorkflowLister. 返回恢复的数量
+func (w *Workflow) Resume(ctx context.Context, ids ...string) (int, error) {
+	var states []*WorkflowState
+	if len(ids) == 0 {
+		lister, ok := w.Store.(IWorkflowLister)
+		if !ok {
+			return 0, fmt.Errorf("task: workflow store %T cannot list the unfinished workflows", w.Store)
+		}
+		var err error
+		if states, err = lister.Unfinished(ctx, w.Name); err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range ids {
+		state, err := w.Store.Load(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if state == nil {
+			return 0, fmt.Errorf("%w: %s", ErrWorkflowNotFound, id)
+		}
+		states = append(states, state)
+	}
+	resumed := 0
+	for _, state := range states {
+		if state.Finished() {
+			continue
+		}
+		if err := w.publish(ctx, state, 1, 0); err != nil {
+			return resumed, err
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// Handle 执行消息对应的步骤或补偿, 上一步的消息重新发布当前步骤, 其他与状态不符的重复消息直接确认. 返回处理后的*WorkflowState
+func (w *Workflow) Handle(ctx context.Context, msg message.IMessage) (interface{}, error) {
+	values := msg.GetValues()
+	id := gconv.String(values[WorkflowIdKey])
+	step := gconv.Int(values[WorkflowStepKey])
+	phase := gconv.String(values[WorkflowPhaseKey])
+	state, err := w.Store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, queue.Permanent(fmt.Errorf("%w: %s", ErrWorkflowNotFound, id))
+	}
+	if state.follows(phase, step) {
+		// the state was saved but the publish of the current step failed, the last message is redelivered
+		glog.Debugf(ctx, "workflow %s %s: %s step %d redelivered, publish step %d", w.Name, id, phase, step, state.Step)
+		return state, w.publish(ctx, state, 1, 0)
+	}
+	if state.Finished() || state.phase() != phase || state.Step != step {
+		glog.Debugf(ctx, "workflow %s %s: skip stale %s step %d", w.Name, id, phase, step)
+		return state, nil
+	}
+	data := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		switch k {
+		case WorkflowIdKey, WorkflowStepKey, WorkflowPhaseKey:
+		default:
+			data[k] = v
+		}
+	}
+	state.Data = data
+	if phase == phaseCompensate {
+		return state, w.compensate(ctx, state)
+	}
+	return state, w.forward(ctx, state, GetAttempt(msg))
+}
+
+// forward runs the current step, compensating the completed steps once it fails for good
+func (w *Workflow) forward(ctx context.Context, state *WorkflowState, attempt int) error {
+	step := w.Steps[state.Step]
+	output, err := step.Forward(ctx, state.Data)
+	if err != nil {
+		if errors.Is(err, queue.ErrShuttingDown) {
+			return err
+		}
+		if p := step.Retry; p != nil && p.retryable(err) && attempt < p.MaxAttempts {
+			glog.Debugf(ctx, "workflow %s %s: step %s attempt %d failed: %v", w.Name, state.Id, step.Name, attempt, err)
+			return w.publish(ctx, state, attempt+1, p.delayOn(w.Queue, attempt))
+		}
+		glog.Warningf(ctx, "workflow %s %s: step %s failed, compensating: %v", w.Name, state.Id, step.Name, err)
+		state.Status = WorkflowCompensating
+		state.Error = fmt.Sprintf("%s: %v", step.Name, err)
+		return w.advance(ctx, state, state.Step-1)
+	}
+	for k, v := range output {
+		state.Data[k] = v
+	}
+	return w.advance(ctx, state, state.Step+1)
+}
+
+// compensate undoes the current step, the queue redelivers the message if it fails
+func (w *Workflow) compensate(ctx context.Context, state *WorkflowState) error {
+	step := w.Steps[state.Step]
+	if step.Compensate != nil {
+		if err := step.Compensate(ctx, state.Data); err != nil {
+			if !queue.IsPermanent(err) {
+				return err
+			}
+			glog.Errorf(ctx, "workflow %s %s: compensate %s failed: %v", w.Name, state.Id, step.Name, err)
+			state.Status = WorkflowFailed
+			state.Error = fmt.Sprintf("%s; compensate %s: %v", state.Error, step.Name, err)
+			state.UpdatedAt = time.Now()
+			if sErr := w.Store.Save(ctx, state); sErr != nil {
+				return sErr
+			}
+			return err
+		}
+	}
+	return w.advance(ctx, state, state.Step-1)
+}
+
+// advance saves the state moved to step, then publishes the message of the step if the workflow goes on
+func (w *Workflow) advance(ctx context.Context, state *WorkflowState, step int) error {
+	state.Step = step
+	switch {
+	case state.Status == WorkflowRunning && step >= len(w.Steps):
+		state.Status = WorkflowCompleted
+	case state.Status == WorkflowCompensating && step < 0:
+		state.Status = WorkflowCompensated
+	}
+	state.UpdatedAt = time.Now()
+	if err := w.Store.Save(ctx, state); err != nil {
+		return err
+	}
+	if state.Finished() {
+		glog.Debugf(ctx, "workflow %s %s %s", w.Name, state.Id, state.Status)
+		return nil
+	}
+	return w.publish(ctx, state, 1, 0)
+}
+
+// publish sends the message of the current step, ordered by the workflow id
+func (w *Workflow) publish(ctx context.Context, state *WorkflowState, attempt int, delay time.Duration) error {
+	values := make(map[string]interface{}, len(state.Data)+3)
+	for k, v := range state.Data {
+		values[k] = v
+	}
+	values[WorkflowIdKey] = state.Id
+	values[WorkflowStepKey] = state.Step
+	values[WorkflowPhaseKey] = state.phase()
+	// a distinct id per step and attempt, the idempotency middleware must not drop the next step
+	id := fmt.Sprintf("%s:%s:%d:%d", state.Id, state.phase(), state.Step, attempt)
+	msg, err := w.NewMessage(id, w.RoutingKey(), values)
+	if err != nil {
+		return err
+	}
+	msg.SetHeader(message.HeaderAttempt, strconv.Itoa(attempt))
+	options := []func(*queue.PublishOptions){queue.WithPublishOptionsOrderingKey(state.Id)}
+	if delay > 0 {
+		options = append(options, queue.WithPublishOptionsDelay(delay))
+	}
+	return w.Queue.Publish(ctx, msg, append(options, w.PublishOptions...)...)
+}
@@ -8,11 +8,11 @@ replace (
 )
 
 require (
+	github.com/168yy/plus-core/core/v2 v2.0.0
+	github.com/168yy/plus-core/sdk/v2 v2.0.0
 	github.com/gogf/gf/v2 v2.5.1
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
-	github.com/168yy/plus-core/core/v2 v2.0.0
-	github.com/168yy/plus-core/sdk/v2 v2.0.0
 	github.com/mojocn/base64Captcha v1.3.5
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stretchr/testify v1.8.4
@@ -44,10 +44,10 @@ require (
 	go.opentelemetry.io/otel v1.7.0 // indirect
 	go.opentelemetry.io/otel/sdk v1.7.0 // indirect
 	go.opentelemetry.io/otel/trace v1.7.0 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/image v0.5.0 // indirect
-	golang.org/x/net v0.9.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/168yy/plus-core/core/v2/cache"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/gogf/gf/v2/container/gvar"
 	"time"
 )
@@ -36,6 +37,11 @@ func (e *Cache) getPrefixKey(key string) string {
 	return key
 }
 
+// Health 检查缓存连接
+func (e *Cache) Health(ctx context.Context) health.Status {
+	return health.Check(ctx, e.String(), e.store)
+}
+
 // SetPrefix 设置前缀
 func (e *Cache) SetPrefix(prefix string) {
 	e.prefix = prefix
@@ -57,6 +63,11 @@ func (e *Cache) Set(ctx context.Context, key string, val interface{}, expire int
 	return e.store.Set(ctx, e.getPrefixKey(key), val, expire)
 }
 
+// SetNX set val in cache if key does not exist
+func (e *Cache) SetNX(ctx context.Context, key string, val interface{}, expire int) (bool, error) {
+	return e.store.SetNX(ctx, e.getPrefixKey(key), val, expire)
+}
+
 // Del delete key in cache
 func (e *Cache) Del(ctx context.Context, key string) error {
 	return e.store.Del(ctx, e.getPrefixKey(key))
@@ -2,6 +2,7 @@ package gredis
 
 import (
 	"context"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/gogf/gf/v2/container/gvar"
 	"github.com/gogf/gf/v2/database/gredis"
 	"time"
@@ -29,6 +30,14 @@ func (r *Gredis) connect() error {
 	return nil
 }
 
+// Health 使用PING检查连接
+func (r *Gredis) Health(ctx context.Context) health.Status {
+	if _, err := r.client.Do(ctx, "PING"); err != nil {
+		return health.Down(r.String(), err)
+	}
+	return health.Up(r.String())
+}
+
 // Get from key
 func (r *Gredis) Get(ctx context.Context, key string) (*gvar.Var, error) {
 	return r.client.Do(ctx, "GET", key)
@@ -46,6 +55,21 @@ func (r *Gredis) Set(ctx context.Context, key string, val interface{}, expire in
 	return err
 }
 
+// SetNX set value with key and expire time if key does not exist
+func (r *Gredis) SetNX(ctx context.Context, key string, val interface{}, expire int) (bool, error) {
+	var v *gvar.Var
+	var err error
+	if expire != 0 {
+		v, err = r.client.Do(ctx, "SET", key, val, "NX", "EX", expire)
+	} else {
+		v, err = r.client.Do(ctx, "SET", key, val, "NX")
+	}
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNil(), nil
+}
+
 // Del delete key in redis
 func (r *Gredis) Del(ctx context.Context, key string) error {
 	_, err := r.client.Do(ctx, "DEL", key)
@@ -3,8 +3,10 @@ package memory
 import (
 	"context"
 	"fmt"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/gogf/gf/v2/container/gvar"
 	"github.com/gogf/gf/v2/os/gcache"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,7 @@ func NewMemory() *Memory {
 
 type Memory struct {
 	cache *gcache.Cache
+	nxMux sync.Mutex
 }
 
 func (*Memory) String() string {
@@ -26,6 +29,11 @@ func (*Memory) String() string {
 func (m *Memory) connect() {
 }
 
+// Health 内存缓存总是可用
+func (m *Memory) Health(ctx context.Context) health.Status {
+	return health.Up(m.String())
+}
+
 func (m *Memory) Get(ctx context.Context, key string) (*gvar.Var, error) {
 	return m.getItem(ctx, key)
 }
@@ -42,6 +50,17 @@ func (m *Memory) setItem(ctx context.Context, key string, item interface{}, expi
 	return m.cache.Set(ctx, key, item, time.Duration(expire)*time.Second)
 }
 
+// SetNX gcache的SetIfNotExist并发时不是原子的, 这里加锁保证只有一个设置成功
+func (m *Memory) SetNX(ctx context.Context, key string, val interface{}, expire int) (bool, error) {
+	m.nxMux.Lock()
+	defer m.nxMux.Unlock()
+	ok, err := m.cache.Contains(ctx, key)
+	if err != nil || ok {
+		return false, err
+	}
+	return true, m.setItem(ctx, key, val, expire)
+}
+
 func (m *Memory) Del(ctx context.Context, key string) error {
 	return m.del(ctx, key)
 }
@@ -50,12 +50,13 @@ type Config struct {
 // Bootstrap 载入启动配置文件
 func (e *Settings) Bootstrap(ctx context.Context, fs ...boot.Initialize) {
 	e.config = Config{
-		Jwt:    map[string]*Jwt{},
-		Cache:  CacheConfig(),
-		Queue:  QueueConfig(),
-		Extend: ExtendConfig,
-		Locker: LockerConfig(),
-		Ws:     &ws.Config{},
+		Jwt:     map[string]*Jwt{},
+		Cache:   CacheConfig(),
+		Queue:   QueueConfig(),
+		Extend:  ExtendConfig,
+		Locker:  LockerConfig(),
+		Ws:      &ws.Config{},
+		Metrics: MetricsConfig(),
 	}
 	e.callbacks = fs
 	e.runCallback(ctx)
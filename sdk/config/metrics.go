@@ -1,8 +1,89 @@
 package config
 
+import (
+	"context"
+	metrics "github.com/168yy/gf-metrics"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+const (
+	MetricsCfgName = "metricsConfig"
+)
+
+var insMetrics = Metrics{}
+
 type Metrics struct {
 	Enable          bool      `json:"enable" yaml:"enable"`
 	Path            string    `json:"path" yaml:"path"`
 	SlowTime        int32     `json:"slowTime" yaml:"slowTime"`
 	RequestDuration []float64 `json:"requestDuration" yaml:"requestDuration"`
 }
+
+func MetricsConfig() *Metrics {
+	return &insMetrics
+}
+
+func (e *Metrics) String() string {
+	return MetricsCfgName
+}
+
+// Init 载入settings.metrics, 启用时在Runtime.MetricsRegister()注册Monitor, 队列指标从此开始记录
+func (e *Metrics) Init(ctx context.Context) error {
+	c, err := Setting().Cfg().Get(ctx, "settings.metrics", "")
+	if err != nil {
+		return err
+	}
+	if c.String() == "" {
+		return nil
+	}
+	if err = c.Scan(e); err != nil {
+		return err
+	}
+	if !e.Enable {
+		return nil
+	}
+	_, err = e.monitor(ctx)
+	return err
+}
+
+// monitor returns the Monitor registered in the runtime, it is set up and registered first if there is none
+func (e *Metrics) monitor(ctx context.Context) (*metrics.Monitor, error) {
+	if m := sdk.Runtime.MetricsRegister().Get(""); m != nil {
+		return m, nil
+	}
+	m, err := e.GetMonitor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m, sdk.Runtime.MetricsRegister().Register("", m)
+}
+
+// GetMonitor 按配置设置gf-metrics Monitor, 并在其上注册队列指标
+func (e *Metrics) GetMonitor(ctx context.Context) (*metrics.Monitor, error) {
+	m := metrics.GetMonitor()
+	if e.Path != "" {
+		m.SetMetricPath(e.Path)
+	}
+	if e.SlowTime > 0 {
+		m.SetSlowTime(e.SlowTime)
+	}
+	if len(e.RequestDuration) > 0 {
+		m.SetDuration(e.RequestDuration)
+	}
+	return m, queueLib.EnableMetrics(m)
+}
+
+// Setup 未启用时返回nil, 否则在s上注册请求指标中间件, 请求和队列指标都在Path上暴露
+func (e *Metrics) Setup(ctx context.Context, s *ghttp.Server) (*metrics.Monitor, error) {
+	if e == nil || !e.Enable {
+		return nil, nil
+	}
+	m, err := e.monitor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.Use(s)
+	return m, nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type KafkaOptions struct {
+	Brokers  []string `yaml:"brokers" json:"brokers"`
+	ClientID string   `yaml:"clientId" json:"client_id"`
+	// DialTimeout in seconds
+	DialTimeout time.Duration `yaml:"dialTimeout" json:"dial_timeout"`
+	// Mechanism plain, scram-sha-256 or scram-sha-512, empty disables SASL
+	Mechanism string `yaml:"mechanism" json:"mechanism"`
+	Username  string `yaml:"username" json:"username"`
+	Password  string `yaml:"password" json:"password"`
+	Tls       *Tls   `yaml:"tls" json:"tls"`
+	// Codec json, msgpack, protobuf or their +gzip variants
+	Codec string `yaml:"codec" json:"codec"`
+}
+
+func (e *KafkaOptions) GetKafkaOptions(ctx context.Context, s *Settings) (*KafkaOptions, error) {
+	opt, err := s.Cfg().Get(ctx, "settings.queue.kafka", "")
+	if err != nil {
+		return nil, err
+	}
+	err = opt.Scan(&e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetDialer returns the dialer connecting to the brokers with the configured SASL and TLS
+func (e *KafkaOptions) GetDialer() (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+		ClientID:  e.ClientID,
+	}
+	if e.DialTimeout > 0 {
+		dialer.Timeout = e.DialTimeout * time.Second
+	}
+	var err error
+	dialer.TLS, err = getTLS(e.Tls)
+	if err != nil {
+		return nil, err
+	}
+	dialer.SASLMechanism, err = e.getMechanism()
+	if err != nil {
+		return nil, err
+	}
+	return dialer, nil
+}
+
+func (e *KafkaOptions) getMechanism() (sasl.Mechanism, error) {
+	switch e.Mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: e.Username, Password: e.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, e.Username, e.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, e.Username, e.Password)
+	}
+	return nil, fmt.Errorf("kafka sasl mechanism %s is not supported", e.Mechanism)
+}
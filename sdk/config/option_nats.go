@@ -0,0 +1,78 @@
+package config
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+type NatsOptions struct {
+	Url      string `yaml:"url" json:"url"`
+	Name     string `yaml:"name" json:"name"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	Token    string `yaml:"token" json:"token"`
+	Tls      *Tls   `yaml:"tls" json:"tls"`
+	// Stream is created if not exist, capturing every subject under SubjectPrefix
+	Stream        string `yaml:"stream" json:"stream"`
+	SubjectPrefix string `yaml:"subjectPrefix" json:"subject_prefix"`
+	// Storage file or memory
+	Storage  string `yaml:"storage" json:"storage"`
+	Replicas int    `yaml:"replicas" json:"replicas"`
+	// MaxAge of the stream messages in seconds, 0 keeps them until the stream limits
+	MaxAge time.Duration `yaml:"maxAge" json:"max_age"`
+	// AckWait in seconds and MaxDeliver are the defaults of the consumers
+	AckWait    time.Duration `yaml:"ackWait" json:"ack_wait"`
+	MaxDeliver int           `yaml:"maxDeliver" json:"max_deliver"`
+	// Codec json, msgpack, protobuf or their +gzip variants
+	Codec string `yaml:"codec" json:"codec"`
+}
+
+func (e *NatsOptions) GetNatsOptions(ctx context.Context, s *Settings) (*NatsOptions, error) {
+	opt, err := s.Cfg().Get(ctx, "settings.queue.nats", "")
+	if err != nil {
+		return nil, err
+	}
+	err = opt.Scan(&e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetOptions returns the connection options
+func (e *NatsOptions) GetOptions() ([]nats.Option, error) {
+	options := []nats.Option{nats.MaxReconnects(-1)}
+	if e.Name != "" {
+		options = append(options, nats.Name(e.Name))
+	}
+	if e.Username != "" {
+		options = append(options, nats.UserInfo(e.Username, e.Password))
+	}
+	if e.Token != "" {
+		options = append(options, nats.Token(e.Token))
+	}
+	tlsConfig, err := getTLS(e.Tls)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		options = append(options, nats.Secure(tlsConfig))
+	}
+	return options, nil
+}
+
+// GetStreamConfig returns the config of the stream holding the queues
+func (e *NatsOptions) GetStreamConfig() *nats.StreamConfig {
+	cfg := &nats.StreamConfig{
+		Name:     e.Stream,
+		Storage:  nats.FileStorage,
+		Replicas: e.Replicas,
+		MaxAge:   e.MaxAge * time.Second,
+	}
+	if e.Storage == "memory" {
+		cfg.Storage = nats.MemoryStorage
+	}
+	return cfg
+}
@@ -16,6 +16,9 @@ type NSQOptions struct {
 
 	// Addresses is the local address to use when dialing a nsqd.
 	Addresses []string `opt:"addresses"`
+	// LookupdAddresses are the nsqlookupd http addresses the consumers discover the nsqd from,
+	// empty connects the consumers to Addresses
+	LookupdAddresses []string `opt:"lookupd_addresses"`
 
 	// Duration between polling lookupd for new producers, and fractional jitter to add to
 	// the lookupd pool loop. this helps evenly distribute requests even if multiple consumers
@@ -84,8 +87,15 @@ type NSQOptions struct {
 }
 
 func (e *NSQOptions) GetNsqOptions(ctx context.Context, s *Settings) (*nsq.Config, error) {
+	opt, err := s.Cfg().Get(ctx, "settings.queue.nsq", "")
+	if err != nil {
+		return nil, err
+	}
+	err = opt.Scan(e)
+	if err != nil {
+		return nil, err
+	}
 	cfg := nsq.NewConfig()
-	var err error
 	cfg.TlsConfig, err = getTLS(e.Tls)
 	if err != nil {
 		return nil, err
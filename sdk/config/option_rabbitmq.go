@@ -28,6 +28,8 @@ type RabbitOptions struct {
 	// ServerName from the URL is used.
 	Tls *Tls             `json:"tls" yaml:"tls"`
 	Cfg *rabbitmq.Config `json:"cfg" yaml:"cfg"`
+	// Codec json, msgpack, protobuf or their +gzip variants
+	Codec string `yaml:"codec" json:"codec"`
 	// log
 	LogPath   string `yaml:"logPath" json:"log_path"`
 	LogFile   string `yaml:"logFile" json:"log_file"`
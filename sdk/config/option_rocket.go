@@ -13,6 +13,8 @@ type RocketOptions struct {
 	LogFile   string `yaml:"logFile" json:"log_file"`
 	LogLevel  string `yaml:"logLevel" json:"log_level"`
 	LogStdout bool   `yaml:"logStdout" json:"log_stdout"`
+	// Codec json, msgpack, protobuf or their +gzip variants
+	Codec string `yaml:"codec" json:"codec"`
 }
 
 func (e *RocketOptions) GetRocketOptions(ctx context.Context, s *Settings) (*RocketOptions, error) {
@@ -2,7 +2,9 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"github.com/168yy/plus-core/core/v2/boot"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
 	"github.com/gogf/gf/v2/os/glog"
 )
 
@@ -16,6 +18,18 @@ type Queue struct {
 	CfgList []boot.QueueInitialize
 }
 
+// getCodec returns the codec named in queue config, empty uses messageLib.DefaultCodec
+func getCodec(name string) (messageLib.Codec, error) {
+	if name == "" {
+		return messageLib.DefaultCodec, nil
+	}
+	codec, ok := messageLib.GetCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("queue codec %s is not registered", name)
+	}
+	return codec, nil
+}
+
 func QueueConfig() *Queue {
 	return &insQueue
 }
@@ -40,6 +54,13 @@ func (q *Queue) Init(ctx context.Context) error {
 	if memory.String() != "" {
 		q.CfgList = append(q.CfgList, QueueMemory())
 	}
+	durable, err := s.Cfg().Get(ctx, "settings.queue.durable", "")
+	if err != nil {
+		return err
+	}
+	if durable.String() != "" {
+		q.CfgList = append(q.CfgList, QueueDurable())
+	}
 	rocket, err := s.Cfg().Get(ctx, "settings.queue.rocketmq", "")
 	if err != nil {
 		return err
@@ -54,6 +75,20 @@ func (q *Queue) Init(ctx context.Context) error {
 	if nsq.String() != "" {
 		q.CfgList = append(q.CfgList, QueueNsq())
 	}
+	kafka, err := s.Cfg().Get(ctx, "settings.queue.kafka", "")
+	if err != nil {
+		return err
+	}
+	if kafka.String() != "" {
+		q.CfgList = append(q.CfgList, QueueKafka())
+	}
+	nats, err := s.Cfg().Get(ctx, "settings.queue.nats", "")
+	if err != nil {
+		return err
+	}
+	if nats.String() != "" {
+		q.CfgList = append(q.CfgList, QueueNats())
+	}
 	redis, err := s.Cfg().Get(ctx, "redis.default", "")
 	if err != nil {
 		return err
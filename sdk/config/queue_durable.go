@@ -0,0 +1,61 @@
+package config
+
+import (
+	"context"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/queue/durable"
+)
+
+const (
+	DurableQueueName = "durable"
+)
+
+var insQueueDurable = cQueueDurable{}
+
+type cQueueDurable struct {
+	Path      string `json:"path" yaml:"path"`
+	MaxLength int    `json:"maxLength" yaml:"maxLength"`
+	Codec     string `json:"codec" yaml:"codec"`
+}
+
+func QueueDurable() *cQueueDurable {
+	return &insQueueDurable
+}
+
+func (c *cQueueDurable) String() string {
+	return DurableQueueName
+}
+
+func (c *cQueueDurable) Init(ctx context.Context) error {
+	cfg := Setting().Cfg()
+	path, err := cfg.Get(ctx, "settings.queue.durable.path", "temp/queue.db")
+	if err != nil {
+		return err
+	}
+	maxLength, err := cfg.Get(ctx, "settings.queue.durable.maxLength", 10000)
+	if err != nil {
+		return err
+	}
+	codec, err := cfg.Get(ctx, "settings.queue.durable.codec", "")
+	if err != nil {
+		return err
+	}
+	c.Path = path.String()
+	c.MaxLength = maxLength.Int()
+	c.Codec = codec.String()
+	return nil
+}
+
+// GetQueue get durable local queue
+func (c *cQueueDurable) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	q, err := durable.NewDurable(c.Path, c.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+	q.Codec = codec
+	return q, nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/queue/kafka"
+)
+
+const (
+	KafkaQueueName = "kafka"
+)
+
+var insQueueKafka = cQueueKafka{
+	KafkaOptions: &KafkaOptions{},
+}
+
+type cQueueKafka struct {
+	*KafkaOptions
+}
+
+func QueueKafka() *cQueueKafka {
+	return &insQueueKafka
+}
+
+func (c *cQueueKafka) String() string {
+	return KafkaQueueName
+}
+
+func (c *cQueueKafka) Init(ctx context.Context) error {
+	var err error
+	c.KafkaOptions, err = c.GetKafkaOptions(ctx, Setting())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetQueue get Kafka queue
+func (c *cQueueKafka) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := c.GetDialer()
+	if err != nil {
+		return nil, err
+	}
+	q, err := kafka.NewKafka(c.Brokers, dialer)
+	if err != nil {
+		return nil, err
+	}
+	q.Codec = codec
+	return q, nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	natsQueue "github.com/168yy/plus-core/sdk/v2/queue/nats"
+	"time"
+)
+
+const (
+	NatsQueueName = "nats"
+)
+
+var insQueueNats = cQueueNats{
+	NatsOptions: &NatsOptions{},
+}
+
+type cQueueNats struct {
+	*NatsOptions
+}
+
+func QueueNats() *cQueueNats {
+	return &insQueueNats
+}
+
+func (c *cQueueNats) String() string {
+	return NatsQueueName
+}
+
+func (c *cQueueNats) Init(ctx context.Context) error {
+	var err error
+	c.NatsOptions, err = c.GetNatsOptions(ctx, Setting())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetQueue get NATS JetStream queue
+func (c *cQueueNats) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	options, err := c.GetOptions()
+	if err != nil {
+		return nil, err
+	}
+	q, err := natsQueue.NewNATS(c.Url, c.GetStreamConfig(), c.SubjectPrefix, options...)
+	if err != nil {
+		return nil, err
+	}
+	if c.AckWait > 0 {
+		q.AckWait = c.AckWait * time.Second
+	}
+	q.MaxDeliver = c.MaxDeliver
+	q.Codec = codec
+	return q, nil
+}
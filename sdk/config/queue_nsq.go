@@ -18,6 +18,7 @@ type cQueueNsq struct {
 	Cfg *nsq.Config
 	NSQOptions
 	ChannelPrefix string
+	Codec         string
 }
 
 func QueueNsq() *cQueueNsq {
@@ -34,10 +35,30 @@ func (c *cQueueNsq) Init(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	codec, err := Setting().Cfg().Get(ctx, "settings.queue.nsq.codec", "")
+	if err != nil {
+		return err
+	}
+	c.Codec = codec.String()
+	channelPrefix, err := Setting().Cfg().Get(ctx, "settings.queue.nsq.channelPrefix", "")
+	if err != nil {
+		return err
+	}
+	c.ChannelPrefix = channelPrefix.String()
 	return nil
 }
 
 // GetQueue get NSQ queue
 func (c *cQueueNsq) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
-	return nsq2.NewNSQ(c.Addresses, c.Cfg, c.ChannelPrefix)
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	q, err := nsq2.NewNSQ(c.Addresses, c.Cfg, c.ChannelPrefix)
+	if err != nil {
+		return nil, err
+	}
+	q.LookupdAddresses = c.LookupdAddresses
+	q.Codec = codec
+	return q, nil
 }
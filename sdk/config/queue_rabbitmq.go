@@ -50,11 +50,20 @@ func (c *cQueueRabbit) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
 	if err != nil {
 		return nil, err
 	}
-	return rabbitmq.NewRabbitMQ(
+	codec, err := getCodec(c.RabbitOptions.Codec)
+	if err != nil {
+		return nil, err
+	}
+	q, err := rabbitmq.NewRabbitMQ(
 		ctx,
 		c.RabbitOptions.Dsn,
 		c.RabbitOptions.ReconnectInterval,
 		c.RabbitOptions.Cfg,
 		logger,
 	)
+	if err != nil {
+		return nil, err
+	}
+	q.Codec = codec
+	return q, nil
 }
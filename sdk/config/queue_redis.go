@@ -20,6 +20,7 @@ type cQueueRedis struct {
 	RedisConnectOptions
 	Producer *redisqueue.ProducerOptions
 	Consumer *redisqueue.ConsumerOptions
+	Codec    string
 }
 
 func QueueRedis() *cQueueRedis {
@@ -45,10 +46,24 @@ func (c *cQueueRedis) Init(ctx context.Context) error {
 	c.Consumer.ReclaimInterval = c.Consumer.ReclaimInterval * time.Second
 	c.Consumer.BlockingTimeout = c.Consumer.BlockingTimeout * time.Second
 	c.Consumer.VisibilityTimeout = c.Consumer.VisibilityTimeout * time.Second
+	codec, err := Setting().Cfg().Get(ctx, "settings.queue.redis.codec", "")
+	if err != nil {
+		return err
+	}
+	c.Codec = codec.String()
 	return nil
 }
 
 // GetQueue get Redis queue
 func (c *cQueueRedis) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
-	return redis2.NewRedis(c.Producer, c.Consumer)
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	q, err := redis2.NewRedis(c.Producer, c.Consumer)
+	if err != nil {
+		return nil, err
+	}
+	q.Codec = codec
+	return q, nil
 }
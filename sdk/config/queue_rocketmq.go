@@ -57,10 +57,19 @@ func (c *cQueueRocket) GetQueue(ctx context.Context) (queueLib.IQueue, error) {
 	if err != nil {
 		return nil, err
 	}
-	return rocketmq.NewRocketMQ(
+	codec, err := getCodec(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	q, err := rocketmq.NewRocketMQ(
 		ctx,
 		c.Urls,
 		c.Credentials,
 		logger,
 	)
+	if err != nil {
+		return nil, err
+	}
+	q.Codec = codec
+	return q, nil
 }
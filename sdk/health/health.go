@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"net/http"
+	"sort"
+)
+
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+
+	StatusOk          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Report is the body of the probes
+type Report struct {
+	Status     string          `json:"status"`
+	Components []health.Status `json:"components,omitempty"`
+}
+
+// Check 汇总Runtime中注册的队列、缓存和锁的状态, 任一组件未连接时为StatusUnavailable
+func Check(ctx context.Context) Report {
+	report := Report{Status: StatusOk}
+	add := func(kind, name string, v interface{}) {
+		s := health.Check(ctx, name, v)
+		s.Name = kind + "." + name
+		if !s.Connected {
+			report.Status = StatusUnavailable
+		}
+		report.Components = append(report.Components, s)
+	}
+	for name, q := range sdk.Runtime.QueueRegistry().GetAll() {
+		add("queue", name, q)
+	}
+	for name, c := range sdk.Runtime.CacheRegistry().GetAll() {
+		add("cache", name, c)
+	}
+	for name, l := range sdk.Runtime.LockerRegistry().GetAll() {
+		add("locker", name, l)
+	}
+	sort.Slice(report.Components, func(i, j int) bool {
+		return report.Components[i].Name < report.Components[j].Name
+	})
+	return report
+}
+
+// Healthz 存活探针, 进程能处理请求即返回200
+func Healthz(r *ghttp.Request) {
+	r.Response.WriteJsonExit(Report{Status: StatusOk})
+}
+
+// Readyz 就绪探针, 所有组件连接正常时返回200, 否则返回503
+func Readyz(r *ghttp.Request) {
+	report := Check(r.GetCtx())
+	if report.Status != StatusOk {
+		r.Response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	r.Response.WriteJsonExit(report)
+}
+
+// Mount 在servers上注册探针, 不传时注册到ServerRegistry中的所有server
+func Mount(servers ...*ghttp.Server) {
+	if len(servers) == 0 {
+		for _, s := range sdk.Runtime.ServerRegistry().GetAll() {
+			servers = append(servers, s)
+		}
+	}
+	for _, s := range servers {
+		s.BindHandler(HealthzPath, Healthz)
+		s.BindHandler(ReadyzPath, Readyz)
+	}
+}
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/cache"
+	cacheMemory "github.com/168yy/plus-core/sdk/v2/cache/memory"
+	"github.com/168yy/plus-core/sdk/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/queue/memory"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	q := memory.NewMemory(10)
+	if err := sdk.Runtime.QueueRegistry().Register("health", queue.NewQueue("", q)); err != nil {
+		t.Fatal(err)
+	}
+	defer sdk.Runtime.QueueRegistry().Unregister("health")
+	if err := sdk.Runtime.CacheRegistry().Register("health", cache.NewCache("", cacheMemory.NewMemory())); err != nil {
+		t.Fatal(err)
+	}
+	defer sdk.Runtime.CacheRegistry().Unregister("health")
+
+	report := Check(ctx)
+	if report.Status != StatusOk || len(report.Components) != 2 {
+		t.Fatalf("Check() = %+v", report)
+	}
+	if got := report.Components[1]; got.Name != "queue.health" || got.Lag != 0 {
+		t.Errorf("queue status = %+v", got)
+	}
+
+	q.Shutdown(ctx)
+	report = Check(ctx)
+	if report.Status != StatusUnavailable {
+		t.Errorf("Check() after shutdown = %+v", report)
+	}
+}
@@ -1,7 +1,9 @@
 package locker
 
 import (
+	"context"
 	"fmt"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/168yy/plus-core/core/v2/locker"
 	"github.com/168yy/redislock"
 )
@@ -23,6 +25,11 @@ func (e *Locker) String() string {
 	return e.locker.String()
 }
 
+// Health 检查锁的存储连接
+func (e *Locker) Health(ctx context.Context) health.Status {
+	return health.Check(ctx, e.String(), e.locker)
+}
+
 func (e *Locker) getPrefixKey(key string) string {
 	return fmt.Sprintf("%s:%s", e.prefix, key)
 }
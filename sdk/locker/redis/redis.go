@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/168yy/redislock"
 	"github.com/168yy/redislock/redis/gredis"
 	glib "github.com/gogf/gf/v2/database/gredis"
@@ -23,6 +25,14 @@ func (Redis) String() string {
 	return "redis"
 }
 
+// Health 使用PING检查连接
+func (r *Redis) Health(ctx context.Context) health.Status {
+	if _, err := r.client.Do(ctx, "PING"); err != nil {
+		return health.Down(r.String(), err)
+	}
+	return health.Up(r.String())
+}
+
 func (r *Redis) Lock(key string, ttl int64, options ...redislock.Option) (*redislock.Mutex, error) {
 	if r.mutex == nil {
 		r.mutex = redislock.New(gredis.NewPool(r.client))
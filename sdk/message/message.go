@@ -10,6 +10,7 @@ type Message struct {
 	Values     map[string]interface{}
 	GroupId    string
 	ErrorCount uint64
+	Headers    map[string]string
 }
 
 func (m *Message) GetId() string {
@@ -63,3 +64,22 @@ func (m *Message) SetErrorCount(count uint64) {
 func (m *Message) GetErrorCount() uint64 {
 	return m.ErrorCount
 }
+
+func (m *Message) SetHeaders(headers map[string]string) {
+	m.Headers = headers
+}
+
+func (m *Message) GetHeaders() map[string]string {
+	return m.Headers
+}
+
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+func (m *Message) GetHeader(key string) string {
+	return m.Headers[key]
+}
@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultPrefix is the path the handlers are mounted under
+	DefaultPrefix = "/admin/queues"
+)
+
+// Info is the state of a registered queue
+type Info struct {
+	Name      string `json:"name"`
+	Backend   string `json:"backend"`
+	Connected bool   `json:"connected"`
+	Consumers int    `json:"consumers"`
+	Lag       int64  `json:"lag"`
+	Error     string `json:"error,omitempty"`
+	// Consumed and Failed count the messages handled by the consumers of the backend in this process,
+	// the same observations as the queue_consumed_total and queue_failed_total metrics
+	Consumed uint64 `json:"consumed"`
+	Failed   uint64 `json:"failed"`
+	// Throughput is the average number of messages handled per second since the first one
+	Throughput float64 `json:"throughput"`
+	// DeadLetters is the size of each dead-letter queue, omitted if the backend can not tell
+	DeadLetters map[string]int64 `json:"deadLetters,omitempty"`
+}
+
+// PublishReq is the test message published by Publish
+type PublishReq struct {
+	RoutingKey string                 `json:"routingKey" v:"required"`
+	Values     map[string]interface{} `json:"values"`
+	Headers    map[string]string      `json:"headers"`
+}
+
+// Get 返回队列的状态, 队列未注册时返回false
+func Get(ctx context.Context, name string) (Info, bool) {
+	q := sdk.Runtime.QueueRegistry().Get(name)
+	if q == nil {
+		return Info{}, false
+	}
+	s := health.Check(ctx, name, q)
+	info := Info{
+		Name:      name,
+		Backend:   q.String(),
+		Connected: s.Connected,
+		Consumers: s.Consumers,
+		Lag:       s.Lag,
+		Error:     s.Error,
+	}
+	if stats := queueLib.GetConsumeStats(q.String()); stats.Handled > 0 {
+		info.Consumed = stats.Handled
+		info.Failed = stats.Failed
+		if elapsed := time.Since(stats.Since).Seconds(); elapsed > 0 {
+			info.Throughput = float64(stats.Handled) / elapsed
+		}
+	}
+	if d, ok := q.(queueLib.IDeadLetterAdmin); ok {
+		sizes, err := d.DeadLetters(ctx)
+		if err == nil {
+			info.DeadLetters = sizes
+		} else if !errors.Is(err, queueLib.ErrNotSupported) && info.Error == "" {
+			info.Error = err.Error()
+		}
+	}
+	return info, true
+}
+
+// List 返回Runtime中注册的所有队列的状态
+func List(ctx context.Context) []Info {
+	infos := make([]Info, 0)
+	for name := range sdk.Runtime.QueueRegistry().GetAll() {
+		if info, ok := Get(ctx, name); ok {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func writeError(r *ghttp.Request, status int, err error) {
+	r.Response.WriteHeader(status)
+	r.Response.WriteJsonExit(map[string]string{"error": err.Error()})
+}
+
+// getQueue returns the queue named in the path, it writes 404 if it is not registered
+func getQueue(r *ghttp.Request) queueLib.IQueue {
+	name := r.Get("name").String()
+	q := sdk.Runtime.QueueRegistry().Get(name)
+	if q == nil {
+		writeError(r, http.StatusNotFound, errors.New("queue "+name+" is not registered"))
+	}
+	return q
+}
+
+// getDeadLetterAdmin returns the dead-letter admin of the queue named in the path, it writes 501 if the backend has none
+func getDeadLetterAdmin(r *ghttp.Request) queueLib.IDeadLetterAdmin {
+	q := getQueue(r)
+	d, ok := q.(queueLib.IDeadLetterAdmin)
+	if !ok {
+		writeError(r, http.StatusNotImplemented, queueLib.ErrNotSupported)
+	}
+	return d
+}
+
+// writeCount writes the number of messages an action affected
+func writeCount(r *ghttp.Request, n int, err error) {
+	switch {
+	case errors.Is(err, queueLib.ErrNotSupported):
+		writeError(r, http.StatusNotImplemented, err)
+	case err != nil && n == 0:
+		writeError(r, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(r, http.StatusInternalServerError, err)
+	}
+	r.Response.WriteJsonExit(map[string]int{"count": n})
+}
+
+// ListHandler GET {prefix}
+func ListHandler(r *ghttp.Request) {
+	r.Response.WriteJsonExit(List(r.GetCtx()))
+}
+
+// GetHandler GET {prefix}/{name}
+func GetHandler(r *ghttp.Request) {
+	name := r.Get("name").String()
+	info, ok := Get(r.GetCtx(), name)
+	if !ok {
+		writeError(r, http.StatusNotFound, errors.New("queue "+name+" is not registered"))
+	}
+	r.Response.WriteJsonExit(info)
+}
+
+// RequeueHandler POST {prefix}/{name}/dead-letters/{dlq}/requeue?limit=n, 将死信重新发布到原队列, 不传limit时全部重新发布
+func RequeueHandler(r *ghttp.Request) {
+	d := getDeadLetterAdmin(r)
+	n, err := d.RequeueDeadLetters(r.GetCtx(), r.Get("dlq").String(), r.Get("limit").Int())
+	writeCount(r, n, err)
+}
+
+// PurgeHandler DELETE {prefix}/{name}/dead-letters/{dlq}, 清空死信队列
+func PurgeHandler(r *ghttp.Request) {
+	d := getDeadLetterAdmin(r)
+	n, err := d.PurgeDeadLetters(r.GetCtx(), r.Get("dlq").String())
+	writeCount(r, n, err)
+}
+
+// PublishHandler POST {prefix}/{name}/publish, 发布一条测试消息
+func PublishHandler(r *ghttp.Request) {
+	q := getQueue(r)
+	var req PublishReq
+	if err := r.Parse(&req); err != nil {
+		writeError(r, http.StatusBadRequest, err)
+	}
+	msg := &message.Message{
+		Id:         uuid.New().String(),
+		RoutingKey: req.RoutingKey,
+		Values:     req.Values,
+		Headers:    req.Headers,
+	}
+	if err := q.Publish(r.GetCtx(), msg); err != nil {
+		writeError(r, http.StatusInternalServerError, err)
+	}
+	r.Response.WriteJsonExit(map[string]string{"id": msg.GetId()})
+}
+
+// BindReadOnly 在路由组上注册只读的查询接口
+func BindReadOnly(group *ghttp.RouterGroup) {
+	group.GET("/", ListHandler)
+	group.GET("/{name}", GetHandler)
+}
+
+// Bind 在路由组上注册全部管理接口, 发布、重投和清空死信的接口会修改队列, 鉴权等中间件由调用方在路由组上添加
+func Bind(group *ghttp.RouterGroup) {
+	BindReadOnly(group)
+	group.POST("/{name}/publish", PublishHandler)
+	group.POST("/{name}/dead-letters/{dlq}/requeue", RequeueHandler)
+	group.DELETE("/{name}/dead-letters/{dlq}", PurgeHandler)
+}
+
+// Mount 在servers的prefix下注册管理接口, prefix为空时使用DefaultPrefix, 不传servers时注册到ServerRegistry中的所有server.
+// auth为空时只注册只读的查询接口, 否则以auth为中间件注册全部接口
+func Mount(prefix string, auth ghttp.HandlerFunc, servers ...*ghttp.Server) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	if len(servers) == 0 {
+		for _, s := range sdk.Runtime.ServerRegistry().GetAll() {
+			servers = append(servers, s)
+		}
+	}
+	for _, s := range servers {
+		if auth == nil {
+			s.Group(prefix, BindReadOnly)
+			continue
+		}
+		s.Group(prefix, func(group *ghttp.RouterGroup) {
+			group.Middleware(auth)
+			Bind(group)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/queue/memory"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+const token = "Bearer admin"
+
+// auth lets the requests with the token through
+func auth(r *ghttp.Request) {
+	if r.GetHeader("Authorization") != token {
+		r.Response.WriteStatusExit(http.StatusUnauthorized)
+	}
+	r.Middleware.Next()
+}
+
+func call(t *testing.T, method, url, body string, v interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil {
+		if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestMount(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewQueue("", memory.NewMemory(10))
+	if err := sdk.Runtime.QueueRegistry().Register("admin", q); err != nil {
+		t.Fatal(err)
+	}
+	defer sdk.Runtime.QueueRegistry().Unregister("admin")
+	s := ghttp.GetServer("admin_test")
+	s.SetAddr("127.0.0.1:0")
+	s.SetDumpRouterMap(false)
+	Mount("", auth, s)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", s.GetListenedPort(), DefaultPrefix)
+
+	unauthorized, err := http.Post(url+"/admin/publish", "application/json", strings.NewReader(`{"routingKey":"orders"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unauthorized.Body.Close()
+	if unauthorized.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("publish without token = %d", unauthorized.StatusCode)
+	}
+
+	var healthy int32
+	handled := make(chan struct{}, 2)
+	q.Consumer(ctx, "orders", func(ctx context.Context, msg messageLib.IMessage) error {
+		handled <- struct{}{}
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	}, queueLib.WithDeadLetter("", 1))
+	go q.Run(ctx)
+	defer q.Shutdown(ctx)
+
+	var published map[string]string
+	status := call(t, http.MethodPost, url+"/admin/publish", `{"routingKey":"orders","values":{"key":"value"}}`, &published)
+	if status != http.StatusOK || published["id"] == "" {
+		t.Fatalf("publish = %d, %v", status, published)
+	}
+	<-handled
+	dlq := "orders" + queueLib.DeadLetterSuffix
+	var info Info
+	deadline := time.Now().Add(3 * time.Second)
+	for info.DeadLetters[dlq] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("info = %+v", info)
+		}
+		time.Sleep(10 * time.Millisecond)
+		call(t, http.MethodGet, url+"/admin", "", &info)
+	}
+	if info.Backend != "memory" || !info.Connected || info.Consumed != 1 || info.Failed != 1 {
+		t.Errorf("info = %+v", info)
+	}
+	var infos []Info
+	if call(t, http.MethodGet, url, "", &infos); len(infos) != 1 || infos[0].Name != "admin" {
+		t.Errorf("list = %+v", infos)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	var res map[string]int
+	status = call(t, http.MethodPost, url+"/admin/dead-letters/"+dlq+"/requeue", "", &res)
+	if status != http.StatusOK || res["count"] != 1 {
+		t.Fatalf("requeue = %d, %v", status, res)
+	}
+	select {
+	case <-handled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("requeued message was not consumed")
+	}
+	status = call(t, http.MethodDelete, url+"/admin/dead-letters/"+dlq, "", &res)
+	if status != http.StatusOK || res["count"] != 0 {
+		t.Errorf("purge = %d, %v", status, res)
+	}
+	if status = call(t, http.MethodDelete, url+"/admin/dead-letters/unknown", "", nil); status != http.StatusBadRequest {
+		t.Errorf("purge unknown dead-letter queue = %d", status)
+	}
+	if status = call(t, http.MethodGet, url+"/missing", "", nil); status != http.StatusNotFound {
+		t.Errorf("get missing queue = %d", status)
+	}
+}
+
+func TestMount_ReadOnly(t *testing.T) {
+	if err := sdk.Runtime.QueueRegistry().Register("admin_read_only", queue.NewQueue("", memory.NewMemory(10))); err != nil {
+		t.Fatal(err)
+	}
+	defer sdk.Runtime.QueueRegistry().Unregister("admin_read_only")
+	s := ghttp.GetServer("admin_read_only_test")
+	s.SetAddr("127.0.0.1:0")
+	s.SetDumpRouterMap(false)
+	Mount("", nil, s)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s/admin_read_only", s.GetListenedPort(), DefaultPrefix)
+
+	var info Info
+	if status := call(t, http.MethodGet, url, "", &info); status != http.StatusOK || info.Name != "admin_read_only" {
+		t.Errorf("get = %d, %+v", status, info)
+	}
+	if status := call(t, http.MethodPost, url+"/publish", `{"routingKey":"orders"}`, nil); status != http.StatusNotFound {
+		t.Errorf("publish = %d, want %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,73 @@
+package dedup
+
+import (
+	"context"
+	cacheLib "github.com/168yy/plus-core/core/v2/cache"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"time"
+)
+
+const (
+	DefaultPrefix = "queue:dedup:"
+
+	inFlightValue = "in_flight"
+	doneValue     = "done"
+)
+
+// NewStore 使用缓存记录已处理的消息, 支持memory和gredis缓存, 多实例部署需使用gredis
+func NewStore(cache cacheLib.ICache, prefix string) *Store {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Store{
+		cache:  cache,
+		prefix: prefix,
+	}
+}
+
+// Store implements queueLib.IdempotencyStore with cacheLib.ICache
+type Store struct {
+	cache  cacheLib.ICache
+	prefix string
+}
+
+// Middleware 使用缓存去重的消费者中间件
+func Middleware(cache cacheLib.ICache, options queueLib.IdempotencyOptions) queueLib.Middleware {
+	return queueLib.Idempotency(NewStore(cache, ""), options)
+}
+
+func (s *Store) Claim(ctx context.Context, key string, inFlight time.Duration) (queueLib.IdempotencyState, error) {
+	ok, err := s.cache.SetNX(ctx, s.prefix+key, inFlightValue, seconds(inFlight))
+	if err != nil {
+		return queueLib.IdempotencyInFlight, err
+	}
+	if ok {
+		return queueLib.IdempotencyClaimed, nil
+	}
+	v, err := s.cache.Get(ctx, s.prefix+key)
+	if err != nil {
+		return queueLib.IdempotencyInFlight, err
+	}
+	if v != nil && v.String() == doneValue {
+		return queueLib.IdempotencyDone, nil
+	}
+	// expired or released meanwhile, the message is redelivered and claimed again
+	return queueLib.IdempotencyInFlight, nil
+}
+
+func (s *Store) Done(ctx context.Context, key string, ttl time.Duration) error {
+	return s.cache.Set(ctx, s.prefix+key, doneValue, seconds(ttl))
+}
+
+func (s *Store) Release(ctx context.Context, key string) error {
+	return s.cache.Del(ctx, s.prefix+key)
+}
+
+// seconds 缓存过期时间以秒为单位, 向上取整且不为0, 0表示不过期
+func seconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/cache/memory"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	memoryQueue "github.com/168yy/plus-core/sdk/v2/queue/memory"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewMemory(), "")
+	tests := []struct {
+		name string
+		do   func() error
+		want queueLib.IdempotencyState
+	}{
+		{"claim", func() error { return nil }, queueLib.IdempotencyClaimed},
+		{"in flight", func() error { return nil }, queueLib.IdempotencyInFlight},
+		{"done", func() error { return s.Done(ctx, "k", time.Minute) }, queueLib.IdempotencyDone},
+		{"released", func() error { return s.Release(ctx, "k") }, queueLib.IdempotencyClaimed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.do(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Claim(ctx, "k", time.Minute)
+			if err != nil || got != tt.want {
+				t.Errorf("Claim() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MemoryQueue(t *testing.T) {
+	ctx := context.Background()
+	q := memoryQueue.NewMemory(100)
+	var calls int32
+	fail := int32(1)
+	received := make(chan struct{}, 10)
+	q.Consumer(ctx, "dedup", func(ctx context.Context, msg messageLib.IMessage) error {
+		atomic.AddInt32(&calls, 1)
+		defer func() { received <- struct{}{} }()
+		// the first delivery fails and is requeued by the memory queue
+		if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+			return errors.New("failed")
+		}
+		return nil
+	}, queueLib.WithConsumeOptionsMiddlewares(Middleware(memory.NewMemory(), queueLib.IdempotencyOptions{})))
+
+	for i := 0; i < 2; i++ {
+		err := q.Publish(ctx, &message.Message{Id: "order-1", RoutingKey: "dedup"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.After(3 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-deadline:
+			t.Fatalf("handler called %d times, want 2", atomic.LoadInt32(&calls))
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("handler calls = %d, want 2", got)
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]int{0: 1, time.Millisecond: 1, time.Second: 1, 1500 * time.Millisecond: 2, time.Hour: 3600} {
+		if got := seconds(d); got != want {
+			t.Errorf("seconds(%v) = %d, want %d", d, got, want)
+		}
+	}
+}
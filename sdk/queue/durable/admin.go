@@ -0,0 +1,118 @@
+package durable
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sync/atomic"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DeadLetters 死信队列中的消息数
+func (d *Durable) DeadLetters(ctx context.Context) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	for name := range d.dead.Names() {
+		n, err := d.Len(name)
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = int64(n)
+	}
+	return sizes, nil
+}
+
+// RequeueDeadLetters 在一个事务中将死信队列中最多limit条消息移回原队列, 处理中的消息不移动
+func (d *Durable) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	origin, err := d.dead.Origin(name)
+	if err != nil {
+		return 0, err
+	}
+	t, err := d.getTopic(name)
+	if err != nil {
+		return 0, err
+	}
+	target, err := d.getTopic(origin)
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.remove(t, limit, func(tx *bolt.Tx, rec *record) error {
+		msg, err := d.decode(rec)
+		if err != nil {
+			return err
+		}
+		queueLib.Requeue(msg, origin)
+		out, err := newRecord(messageLib.GetCodecOrDefault(rec.ContentType, d.Codec), msg, queueLib.PublishOptions{})
+		if err != nil {
+			return err
+		}
+		return put(tx, out)
+	})
+	if n > 0 {
+		atomic.AddInt64(&target.size, int64(n))
+		signal(target.notify)
+	}
+	return n, err
+}
+
+// PurgeDeadLetters 删除死信队列中的消息, 处理中的消息不删除
+func (d *Durable) PurgeDeadLetters(ctx context.Context, name string) (int, error) {
+	if _, err := d.dead.Origin(name); err != nil {
+		return 0, err
+	}
+	t, err := d.getTopic(name)
+	if err != nil {
+		return 0, err
+	}
+	return d.remove(t, 0, nil)
+}
+
+// remove deletes up to limit messages of t that are not in flight in the order of the index, limit <= 0 deletes all of them;
+// move is called for every message in the same transaction
+func (d *Durable) remove(t *topic, limit int, move func(tx *bolt.Tx, rec *record) error) (int, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var n int
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		n = 0
+		b, idx := tx.Bucket(bucketName(t.name)), tx.Bucket(dueBucketName(t.name))
+		if b == nil || idx == nil {
+			return nil
+		}
+		var keys [][]byte
+		c := idx.Cursor()
+		for k, _ := c.First(); k != nil && (limit <= 0 || len(keys) < limit); k, _ = c.Next() {
+			seq := binary.BigEndian.Uint64(k[8:])
+			if _, ok := t.inFlight[seq]; ok {
+				continue
+			}
+			if move != nil {
+				rec := new(record)
+				if err := json.Unmarshal(b.Get(itob(seq)), rec); err != nil {
+					return err
+				}
+				if err := move(tx, rec); err != nil {
+					return err
+				}
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := del(tx, t.name, binary.BigEndian.Uint64(k[8:]), int64(binary.BigEndian.Uint64(k))); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		atomic.AddInt64(&t.size, -int64(n))
+		signal(t.space)
+	}
+	return n, nil
+}
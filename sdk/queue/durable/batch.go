@@ -0,0 +1,90 @@
+package durable
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 所有消息在一个事务中写入文件; 队列满时阻塞, 有空间后整批写入
+func (d *Durable) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(d.String(), msgs[i], err)
+		}
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	codec, err := options.GetCodec(d.Codec)
+	if err != nil {
+		return err
+	}
+	recs := make([]*record, 0, len(msgs))
+	topics := map[*topic]int64{}
+	for _, msg := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, d.String(), msg)
+		spans = append(spans, span)
+		rec, err := newRecord(codec, msg, options)
+		if err != nil {
+			return err
+		}
+		t, err := d.getTopic(rec.RoutingKey)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, rec)
+		topics[t]++
+	}
+	for {
+		for t, n := range topics {
+			if err = d.waitSpace(ctx, t, n); err != nil {
+				return err
+			}
+		}
+		reserved := map[*topic]int64{}
+		err = d.db.Update(func(tx *bolt.Tx) error {
+			for t, n := range topics {
+				if err := d.reserve(t, n); err != nil {
+					return err
+				}
+				reserved[t] = n
+			}
+			for _, rec := range recs {
+				if err := put(tx, rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			for t, n := range reserved {
+				atomic.AddInt64(&t.size, -n)
+			}
+		}
+		if !errors.Is(err, errFull) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for t := range topics {
+		signal(t.notify)
+	}
+	return nil
+}
+
+// BatchConsumer 批量消费, 由Concurrency个协程的单条消费合并批次
+func (d *Durable) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	queueLib.ConsumeBatches(ctx, d, name, f, optionFuncs...)
+}
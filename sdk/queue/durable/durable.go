@@ -0,0 +1,617 @@
+package durable
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultRetryDelay is how long a failed message waits before it is delivered again
+	DefaultRetryDelay = time.Second
+	// DefaultPollInterval bounds the wait for delayed messages becoming due
+	DefaultPollInterval = time.Second
+
+	bucketPrefix = "queue:"
+	// duePrefix names the index of a queue ordered by delivery time, its keys are the DeliverAt and the sequence of a message
+	duePrefix = "due:"
+)
+
+var errFull = errors.New("durable: queue is full")
+
+// NewDurable 本地持久化队列, 消息写入bbolt文件, 重启后未确认的消息会重新投递;
+// maxLength限制每个队列的消息数, 队列满时Publish阻塞直到有空间或ctx结束, 0不限制
+func NewDurable(path string, maxLength int) (*Durable, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Durable{
+		db:           db,
+		MaxLength:    maxLength,
+		RetryDelay:   DefaultRetryDelay,
+		PollInterval: DefaultPollInterval,
+		Codec:        messageLib.DefaultCodec,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// Durable at-least-once local queue, a message is removed from the file once its consumer succeeded
+type Durable struct {
+	queueLib.MiddlewareChain
+	db           *bolt.DB
+	topics       sync.Map
+	workers      sync.WaitGroup
+	drain        queueLib.Drain
+	dead         queueLib.DeadLetterQueues
+	consumers    int64
+	done         chan struct{}
+	stop         sync.Once
+	MaxLength    int
+	RetryDelay   time.Duration
+	PollInterval time.Duration
+	Codec        messageLib.Codec
+}
+
+// record is the stored message
+type record struct {
+	Id          string            `json:"id"`
+	RoutingKey  string            `json:"routingKey"`
+	ContentType string            `json:"contentType"`
+	Body        []byte            `json:"body"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ErrorCount  uint64            `json:"errorCount"`
+	DeliverAt   int64             `json:"deliverAt,omitempty"`
+}
+
+type delivery struct {
+	seq uint64
+	at  int64 // the DeliverAt the message is indexed at
+	rec *record
+	raw []byte // the stored value if it cannot be decoded into rec
+	err error
+}
+
+// topic keeps the in-process state of a stored queue
+type topic struct {
+	name     string
+	size     int64
+	mux      sync.Mutex
+	inFlight map[uint64]struct{}
+	notify   chan struct{} // messages added or released
+	space    chan struct{} // messages removed
+}
+
+func (*Durable) String() string {
+	return "durable"
+}
+
+func bucketName(name string) []byte {
+	return []byte(bucketPrefix + name)
+}
+
+func dueBucketName(name string) []byte {
+	return []byte(duePrefix + name)
+}
+
+func dueKey(at int64, seq uint64) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(at))
+	binary.BigEndian.PutUint64(b[8:], seq)
+	return b
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// getTopic returns the state of the queue, its size is loaded from the file on first use
+func (d *Durable) getTopic(name string) (*topic, error) {
+	if t, ok := d.topics.Load(name); ok {
+		return t.(*topic), nil
+	}
+	t := &topic{
+		name:     name,
+		inFlight: map[uint64]struct{}{},
+		notify:   make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketName(name))
+		if err != nil {
+			return err
+		}
+		t.size = int64(b.Stats().KeyN)
+		if tx.Bucket(dueBucketName(name)) != nil {
+			return nil
+		}
+		// a file written before the index existed
+		idx, err := tx.CreateBucket(dueBucketName(name))
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			rec := new(record)
+			if json.Unmarshal(v, rec) != nil {
+				rec.DeliverAt = 0
+			}
+			return idx.Put(dueKey(rec.DeliverAt, binary.BigEndian.Uint64(k)), nil)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	v, _ := d.topics.LoadOrStore(name, t)
+	return v.(*topic), nil
+}
+
+// Publish 消息写入文件后返回
+func (d *Durable) Publish(ctx context.Context, msg messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, d.String(), msg)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(d.String(), msg, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	codec, err := options.GetCodec(d.Codec)
+	if err != nil {
+		return err
+	}
+	rec, err := newRecord(codec, msg, options)
+	if err != nil {
+		return err
+	}
+	t, err := d.getTopic(rec.RoutingKey)
+	if err != nil {
+		return err
+	}
+	for {
+		if err = d.waitSpace(ctx, t, 1); err != nil {
+			return err
+		}
+		reserved := false
+		err = d.db.Update(func(tx *bolt.Tx) error {
+			if err := d.reserve(t, 1); err != nil {
+				return err
+			}
+			reserved = true
+			return put(tx, rec)
+		})
+		if err != nil && reserved {
+			atomic.AddInt64(&t.size, -1)
+		}
+		if !errors.Is(err, errFull) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	signal(t.notify)
+	return nil
+}
+
+// reserve adds n messages to the size of t, errFull if they do not fit in MaxLength.
+// It is called in a writable transaction, bbolt runs them one at a time so concurrent publishers cannot both take the last space
+func (d *Durable) reserve(t *topic, n int64) error {
+	if !d.fits(t, n) {
+		return errFull
+	}
+	atomic.AddInt64(&t.size, n)
+	return nil
+}
+
+// fits reports whether n more messages fit in t, a batch larger than MaxLength fits an empty queue
+func (d *Durable) fits(t *topic, n int64) bool {
+	size := atomic.LoadInt64(&t.size)
+	return d.MaxLength <= 0 || size == 0 || size+n <= int64(d.MaxLength)
+}
+
+// newRecord encodes msg into its stored record
+func newRecord(codec messageLib.Codec, msg messageLib.IMessage, options queueLib.PublishOptions) (*record, error) {
+	body, err := codec.Marshal(msg.GetValues())
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{
+		Id:          msg.GetId(),
+		RoutingKey:  msg.GetRoutingKey(),
+		ContentType: codec.ContentType(),
+		Body:        body,
+		Headers:     msg.GetHeaders(),
+		ErrorCount:  msg.GetErrorCount(),
+	}
+	if rec.Id == "" {
+		rec.Id = uuid.New().String()
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		rec.DeliverAt = time.Now().Add(delay).UnixNano()
+	}
+	return rec, nil
+}
+
+// waitSpace blocks until n messages fit in the queue, reserve checks it again in the transaction
+func (d *Durable) waitSpace(ctx context.Context, t *topic, n int64) error {
+	for !d.fits(t, n) {
+		select {
+		case <-t.space:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.done:
+			return bolt.ErrDatabaseNotOpen
+		}
+	}
+	return nil
+}
+
+func put(tx *bolt.Tx, rec *record) error {
+	b, err := tx.CreateBucketIfNotExists(bucketName(rec.RoutingKey))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = b.Put(itob(seq), v); err != nil {
+		return err
+	}
+	idx, err := tx.CreateBucketIfNotExists(dueBucketName(rec.RoutingKey))
+	if err != nil {
+		return err
+	}
+	return idx.Put(dueKey(rec.DeliverAt, seq), nil)
+}
+
+// del removes the message and its index entry
+func del(tx *bolt.Tx, name string, seq uint64, at int64) error {
+	if err := tx.Bucket(bucketName(name)).Delete(itob(seq)); err != nil {
+		return err
+	}
+	return tx.Bucket(dueBucketName(name)).Delete(dueKey(at, seq))
+}
+
+// Consumer 监听消费者, Concurrency个协程并发消费
+func (d *Durable) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = d.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(d.String(), name, f)
+	f = queueLib.TraceConsumerFunc(d.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = d.drain.Track(f)
+	d.dead.Add(name, options)
+	t, err := d.getTopic(name)
+	if err != nil {
+		glog.Error(ctx, "durable queue consumer error:", err)
+		return
+	}
+	atomic.AddInt64(&d.consumers, 1)
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	deliveries := make(chan delivery)
+	d.workers.Add(concurrency + 1)
+	go d.dispatch(t, concurrency, deliveries)
+	for i := 0; i < concurrency; i++ {
+		go d.work(ctx, t, f, options, deliveries)
+	}
+}
+
+// dispatch feeds the workers with the due messages in order, it waits while every worker is busy
+func (d *Durable) dispatch(t *topic, limit int, deliveries chan<- delivery) {
+	defer d.workers.Done()
+	defer close(deliveries)
+	for {
+		batch, next, err := d.fetch(t, limit)
+		if err != nil {
+			glog.Warning(context.Background(), "durable queue fetch error:", err)
+		}
+		for _, dl := range batch {
+			select {
+			case deliveries <- dl:
+			case <-d.done:
+				return
+			}
+		}
+		if len(batch) > 0 {
+			continue
+		}
+		wait := d.PollInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-t.notify:
+		case <-timer.C:
+		case <-d.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// fetch claims up to limit due messages in the order of the index, next is the earliest delivery time of the delayed ones
+func (d *Durable) fetch(t *topic, limit int) (batch []delivery, next time.Time, err error) {
+	now := time.Now().UnixNano()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b, idx := tx.Bucket(bucketName(t.name)), tx.Bucket(dueBucketName(t.name))
+		if b == nil || idx == nil {
+			return nil
+		}
+		c := idx.Cursor()
+		for k, _ := c.First(); k != nil && len(batch) < limit; k, _ = c.Next() {
+			at, seq := int64(binary.BigEndian.Uint64(k)), binary.BigEndian.Uint64(k[8:])
+			if at > now {
+				next = time.Unix(0, at)
+				break
+			}
+			if _, ok := t.inFlight[seq]; ok {
+				continue
+			}
+			v := b.Get(itob(seq))
+			if v == nil {
+				continue
+			}
+			dl := delivery{seq: seq, at: at, rec: new(record)}
+			if dl.err = json.Unmarshal(v, dl.rec); dl.err != nil {
+				dl.raw = append([]byte(nil), v...)
+			}
+			batch = append(batch, dl)
+		}
+		return nil
+	})
+	for _, dl := range batch {
+		t.inFlight[dl.seq] = struct{}{}
+	}
+	return batch, next, err
+}
+
+func (d *Durable) work(ctx context.Context, t *topic, f queueLib.ConsumerFunc, options queueLib.ConsumeOptions, deliveries <-chan delivery) {
+	defer d.workers.Done()
+	for dl := range deliveries {
+		var m messageLib.IMessage
+		err := dl.err
+		if err == nil {
+			m, err = d.decode(dl.rec)
+		}
+		if err != nil {
+			if err = d.reject(t, dl, err, options); err != nil {
+				glog.Warning(ctx, "durable queue settle error:", err)
+			}
+			continue
+		}
+		err = f(ctx, m)
+		if errors.Is(err, queueLib.ErrShuttingDown) {
+			// left in the file, it is delivered again after restart
+			return
+		}
+		if err == nil {
+			err = d.ack(t, dl)
+		} else {
+			err = d.nack(t, dl, m, err, options)
+		}
+		if err != nil {
+			glog.Warning(ctx, "durable queue settle error:", err)
+		}
+	}
+}
+
+func (d *Durable) decode(rec *record) (messageLib.IMessage, error) {
+	values, err := messageLib.GetCodecOrDefault(rec.ContentType, d.Codec).Unmarshal(rec.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &message.Message{
+		Id:         rec.Id,
+		RoutingKey: rec.RoutingKey,
+		Values:     values,
+		Headers:    rec.Headers,
+		ErrorCount: rec.ErrorCount,
+	}, nil
+}
+
+// ack removes the consumed message
+func (d *Durable) ack(t *topic, dl delivery) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		return del(tx, t.name, dl.seq, dl.at)
+	})
+	d.release(t, dl.seq)
+	if err == nil {
+		atomic.AddInt64(&t.size, -1)
+		signal(t.space)
+	}
+	return err
+}
+
+// reject moves a message that cannot be decoded to the dead-letter queue with the error in its headers,
+// it is dropped if the dead-letter queue is not enabled; retrying cannot decode it
+func (d *Durable) reject(t *topic, dl delivery, cause error, options queueLib.ConsumeOptions) error {
+	glog.Warning(context.Background(), "durable queue decode msg error:", cause)
+	var dlq *record
+	var dlqTopic *topic
+	if options.DeadLetter.Enabled() {
+		dlq = &record{
+			Id:          dl.rec.Id,
+			RoutingKey:  options.DeadLetter.GetQueue(t.name),
+			ContentType: dl.rec.ContentType,
+			Body:        dl.rec.Body,
+			Headers:     map[string]string{},
+			ErrorCount:  dl.rec.ErrorCount,
+		}
+		if dl.raw != nil {
+			dlq.Body = dl.raw
+		}
+		for k, v := range dl.rec.Headers {
+			dlq.Headers[k] = v
+		}
+		dlq.Headers[messageLib.ErrorKey] = cause.Error()
+		var err error
+		if dlqTopic, err = d.getTopic(dlq.RoutingKey); err != nil {
+			d.release(t, dl.seq)
+			return err
+		}
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if err := del(tx, t.name, dl.seq, dl.at); err != nil || dlq == nil {
+			return err
+		}
+		return put(tx, dlq)
+	})
+	d.release(t, dl.seq)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&t.size, -1)
+	signal(t.space)
+	if dlqTopic != nil {
+		atomic.AddInt64(&dlqTopic.size, 1)
+		signal(dlqTopic.notify)
+	}
+	return nil
+}
+
+// nack stores the failed message with its error count for redelivery after RetryDelay,
+// or moves it to the dead-letter queue once the attempts are exhausted
+func (d *Durable) nack(t *topic, dl delivery, m messageLib.IMessage, cause error, options queueLib.ConsumeOptions) error {
+	rec := dl.rec
+	rec.ErrorCount++
+	rec.DeliverAt = time.Now().Add(d.RetryDelay).UnixNano()
+	var dlq *record
+	m.SetErrorCount(rec.ErrorCount)
+	if options.DeadLetter.Exhausted(m, cause) {
+		options.DeadLetter.SetDeadLetter(t.name, m, cause)
+		body, err := messageLib.GetCodecOrDefault(rec.ContentType, d.Codec).Marshal(m.GetValues())
+		if err != nil {
+			return err
+		}
+		dlq = &record{
+			Id:          rec.Id,
+			RoutingKey:  m.GetRoutingKey(),
+			ContentType: rec.ContentType,
+			Body:        body,
+			Headers:     m.GetHeaders(),
+			ErrorCount:  rec.ErrorCount,
+		}
+	}
+	var dlqTopic *topic
+	if dlq != nil {
+		var err error
+		if dlqTopic, err = d.getTopic(dlq.RoutingKey); err != nil {
+			return err
+		}
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if dlq != nil {
+			if err := del(tx, t.name, dl.seq, dl.at); err != nil {
+				return err
+			}
+			return put(tx, dlq)
+		}
+		v, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket(bucketName(t.name)).Put(itob(dl.seq), v); err != nil {
+			return err
+		}
+		idx := tx.Bucket(dueBucketName(t.name))
+		if err = idx.Delete(dueKey(dl.at, dl.seq)); err != nil {
+			return err
+		}
+		return idx.Put(dueKey(rec.DeliverAt, dl.seq), nil)
+	})
+	d.release(t, dl.seq)
+	if err == nil && dlq != nil {
+		glog.Warning(context.Background(), "durable queue dead letter msg:", rec.Id)
+		atomic.AddInt64(&t.size, -1)
+		atomic.AddInt64(&dlqTopic.size, 1)
+		signal(t.space)
+		signal(dlqTopic.notify)
+	}
+	return err
+}
+
+func (d *Durable) release(t *topic, seq uint64) {
+	t.mux.Lock()
+	delete(t.inFlight, seq)
+	t.mux.Unlock()
+	signal(t.notify)
+}
+
+// Len 队列中的消息数, 包括处理中和延迟的消息
+func (d *Durable) Len(name string) (int, error) {
+	t, err := d.getTopic(name)
+	if err != nil {
+		return 0, err
+	}
+	return int(atomic.LoadInt64(&t.size)), nil
+}
+
+func (d *Durable) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-d.done:
+	}
+}
+
+// Shutdown 停止投递, 等待处理中的消息直到ctx结束后关闭文件, 未确认的消息在重启后重新投递
+func (d *Durable) Shutdown(ctx context.Context) {
+	d.stop.Do(func() {
+		close(d.done)
+		if err := d.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "durable queue shutdown before handlers finished:", err)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			d.workers.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+		if err := d.db.Close(); err != nil {
+			glog.Warning(ctx, "durable queue close error:", err)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package durable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestDurable(t *testing.T, path string, maxLength int) *Durable {
+	q, err := NewDurable(path, maxLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.RetryDelay = 10 * time.Millisecond
+	return q
+}
+
+func publish(t *testing.T, q *Durable, routingKey string, value interface{}) {
+	err := q.Publish(context.Background(), &message.Message{
+		RoutingKey: routingKey,
+		Values:     map[string]interface{}{"key": value},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurable_Recovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	ctx := context.Background()
+	q := newTestDurable(t, path, 0)
+	for i := 0; i < 3; i++ {
+		publish(t, q, "test", i)
+	}
+	q.Shutdown(ctx)
+
+	q = newTestDurable(t, path, 0)
+	defer q.Shutdown(ctx)
+	if n, _ := q.Len("test"); n != 3 {
+		t.Fatalf("Len() = %d, want 3", n)
+	}
+	received := make(chan interface{}, 3)
+	q.Consumer(ctx, "test", func(ctx context.Context, m messageLib.IMessage) error {
+		received <- m.GetValues()["key"]
+		return nil
+	}, queueLib.WithConsumeOptionsConcurrency(1))
+	for i := 0; i < 3; i++ {
+		select {
+		case v := <-received:
+			if v != float64(i) {
+				t.Errorf("message %d = %v", i, v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("recovered message was not consumed")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for n, _ := q.Len("test"); n != 0 && time.Now().Before(deadline); n, _ = q.Len("test") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, _ := q.Len("test"); n != 0 {
+		t.Errorf("Len() = %d after ack, want 0", n)
+	}
+}
+
+func TestDurable_DeadLetter(t *testing.T) {
+	ctx := context.Background()
+	q := newTestDurable(t, filepath.Join(t.TempDir(), "queue.db"), 0)
+	defer q.Shutdown(ctx)
+	attempts := make(chan uint64, 3)
+	q.Consumer(ctx, "test", func(ctx context.Context, m messageLib.IMessage) error {
+		attempts <- m.GetErrorCount()
+		return errors.New("failed")
+	}, queueLib.WithDeadLetter("", 3))
+	dead := make(chan messageLib.IMessage, 1)
+	q.Consumer(ctx, "test"+queueLib.DeadLetterSuffix, func(ctx context.Context, m messageLib.IMessage) error {
+		dead <- m
+		return nil
+	})
+	publish(t, q, "test", "value")
+	for i := uint64(0); i < 3; i++ {
+		select {
+		case n := <-attempts:
+			if n != i {
+				t.Errorf("attempt %d error count = %d", i, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("failed message was not redelivered")
+		}
+	}
+	select {
+	case m := <-dead:
+		if m.GetErrorCount() != 3 || m.GetValues()[messageLib.ErrorKey] != "failed" {
+			t.Errorf("dead letter = %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not dead-lettered")
+	}
+}
+
+func TestDurable_Backpressure(t *testing.T) {
+	q := newTestDurable(t, filepath.Join(t.TempDir(), "queue.db"), 1)
+	defer q.Shutdown(context.Background())
+	publish(t, q, "test", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := q.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": 2}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish() on a full queue error = %v", err)
+	}
+
+	// concurrent publishers cannot both take the space left by a consumed message
+	q = newTestDurable(t, filepath.Join(t.TempDir(), "queue.db"), 2)
+	defer q.Shutdown(context.Background())
+	publish(t, q, "test", 1)
+	var wg sync.WaitGroup
+	var published int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if q.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": 2}}) == nil {
+				atomic.AddInt32(&published, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := q.Len("test"); n != 2 || published != 1 {
+		t.Errorf("Len() = %d with %d published, want 2 with 1", n, published)
+	}
+}
+
+func TestDurable_DecodeError(t *testing.T) {
+	ctx := context.Background()
+	q := newTestDurable(t, filepath.Join(t.TempDir(), "queue.db"), 0)
+	defer q.Shutdown(ctx)
+	// written without the due-time index, as by an older version
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(bucketName("test"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put(itob(1), []byte("{corrupt")); err != nil {
+			return err
+		}
+		v, err := json.Marshal(&record{Id: "2", RoutingKey: "test", Body: []byte(`{"key":"value"}`)})
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(2), v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan messageLib.IMessage, 2)
+	q.Consumer(ctx, "test", func(ctx context.Context, m messageLib.IMessage) error {
+		received <- m
+		return nil
+	}, queueLib.WithDeadLetter("", 3))
+	select {
+	case m := <-received:
+		if m.GetId() != "2" {
+			t.Errorf("consumed = %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("indexed message was not consumed")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for n, _ := q.Len("test"); n > 0 && time.Now().Before(deadline); n, _ = q.Len("test") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, _ := q.Len("test"); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+	if n, _ := q.Len("test" + queueLib.DeadLetterSuffix); n != 1 {
+		t.Errorf("dead letters = %d, want 1", n)
+	}
+	select {
+	case m := <-received:
+		t.Errorf("undecodable message consumed: %v", m)
+	default:
+	}
+}
+
+func TestDurable_Batch(t *testing.T) {
+	ctx := context.Background()
+	q := newTestDurable(t, filepath.Join(t.TempDir(), "queue.db"), 0)
+	defer q.Shutdown(ctx)
+	msgs := make([]messageLib.IMessage, 0, 5)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &message.Message{
+			RoutingKey: "test",
+			Values:     map[string]interface{}{"key": i},
+		})
+	}
+	if err := q.PublishBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.Len("test"); n != 5 {
+		t.Fatalf("Len() = %d, want 5", n)
+	}
+	received := make(chan int, 5)
+	q.BatchConsumer(ctx, "test", func(ctx context.Context, msgs []messageLib.IMessage) []error {
+		received <- len(msgs)
+		return nil
+	}, queueLib.WithConsumeOptionsBatch(5, time.Second))
+	select {
+	case n := <-received:
+		if n != 5 {
+			t.Errorf("batch size = %d, want 5", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not consumed")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for n, _ := q.Len("test"); n > 0 && time.Now().Before(deadline); n, _ = q.Len("test") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, _ := q.Len("test"); n != 0 {
+		t.Errorf("Len() after ack = %d, want 0", n)
+	}
+}
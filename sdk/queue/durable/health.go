@@ -0,0 +1,25 @@
+package durable
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"sync/atomic"
+)
+
+// Health Lag为文件中未确认的消息数
+func (d *Durable) Health(ctx context.Context) health.Status {
+	select {
+	case <-d.done:
+		return health.Down(d.String(), queueLib.ErrShuttingDown)
+	default:
+	}
+	s := health.Up(d.String())
+	s.Consumers = int(atomic.LoadInt64(&d.consumers))
+	s.Lag = 0
+	d.topics.Range(func(key, value interface{}) bool {
+		s.Lag += atomic.LoadInt64(&value.(*topic).size)
+		return true
+	})
+	return s
+}
@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 批量写入, 所有消息在一次WriteMessages中发送
+func (k *Kafka) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(k.String(), msgs[i], err)
+		}
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	out := make([]kafka.Message, 0, len(msgs))
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, k.String(), message)
+		spans = append(spans, span)
+		m, err := k.encode(message, options)
+		if err != nil {
+			return err
+		}
+		out = append(out, m)
+	}
+	return k.client.WriteMessages(ctx, out...)
+}
+
+// BatchConsumer 批量消费, 每个消费者组成员拉取BatchSize条或BatchTimeout内到达的消息,
+// 逐条处理失败结果后一次提交整批offset, 队列中间件按单条消息执行不作用于批量消费
+func (k *Kafka) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = queueLib.MetricsBatchConsumerFunc(k.String(), name, f)
+	f = queueLib.DropPermanentBatch(name, f, options)
+	f = k.drain.TrackBatch(f)
+	members := options.Concurrency
+	if members < 1 {
+		members = 1
+	}
+	for i := 0; i < members; i++ {
+		k.register(&consumer{ctx: ctx, batch: f, options: options}, name)
+	}
+}
+
+// consumeBatches handles the messages of c in batches
+func (k *Kafka) consumeBatches(c *consumer) {
+	size, timeout := c.options.GetBatch()
+	for {
+		first, ok := k.fetch(k.fetchCtx, c)
+		if !ok {
+			return
+		}
+		fetched := []kafka.Message{first}
+		window, cancel := context.WithTimeout(k.fetchCtx, timeout)
+		for len(fetched) < size {
+			m, ok := k.fetch(window, c)
+			if !ok {
+				break
+			}
+			fetched = append(fetched, m)
+		}
+		cancel()
+		raws := make([]kafka.Message, 0, len(fetched))
+		msgs := make([]messageLib.IMessage, 0, len(fetched))
+		for _, m := range fetched {
+			msg, err := k.decode(m)
+			if err != nil {
+				if !k.reject(c, m, err) {
+					return
+				}
+				continue
+			}
+			raws = append(raws, m)
+			msgs = append(msgs, msg)
+		}
+		if len(msgs) > 0 {
+			errs := c.batch(c.ctx, msgs)
+			if errors.Is(queueLib.BatchResult(errs, 0), queueLib.ErrShuttingDown) {
+				return
+			}
+			for i, msg := range msgs {
+				if !k.settle(c, raws[i], msg, queueLib.BatchResult(errs, i)) {
+					return
+				}
+			}
+		}
+		k.commit(c, fetched...)
+	}
+}
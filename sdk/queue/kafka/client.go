@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// client is the kafka connection used by the queue
+type client interface {
+	// WriteMessages writes the messages to their topics, partitioned by key
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	// NewReader returns a member of the consumer group reading topic
+	NewReader(topic, groupID string) reader
+	// Ping checks the connection to the brokers
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// reader fetches the messages of a consumer group member, offsets are committed manually
+type reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Stats() kafka.ReaderStats
+	Close() error
+}
+
+// DefaultBatchTimeout bounds how long a write waits for more messages before it is sent
+const DefaultBatchTimeout = 10 * time.Millisecond
+
+type kafkaClient struct {
+	brokers []string
+	dialer  *kafka.Dialer
+	writer  *kafka.Writer
+}
+
+func newClient(brokers []string, dialer *kafka.Dialer) *kafkaClient {
+	if dialer == nil {
+		dialer = kafka.DefaultDialer
+	}
+	return &kafkaClient{
+		brokers: brokers,
+		dialer:  dialer,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           DefaultBatchTimeout,
+			AllowAutoTopicCreation: true,
+			Transport: &kafka.Transport{
+				DialTimeout: dialer.Timeout,
+				ClientID:    dialer.ClientID,
+				TLS:         dialer.TLS,
+				SASL:        dialer.SASLMechanism,
+			},
+		},
+	}
+}
+
+func (c *kafkaClient) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return c.writer.WriteMessages(ctx, msgs...)
+}
+
+func (c *kafkaClient) NewReader(topic, groupID string) reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers: c.brokers,
+		GroupID: groupID,
+		Topic:   topic,
+		Dialer:  c.dialer,
+		// offsets are committed synchronously after the handler succeeded
+		CommitInterval: 0,
+		StartOffset:    kafka.FirstOffset,
+	})
+}
+
+func (c *kafkaClient) Ping(ctx context.Context) error {
+	var err error
+	for _, broker := range c.brokers {
+		var conn *kafka.Conn
+		conn, err = c.dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
+
+func (c *kafkaClient) Close() error {
+	return c.writer.Close()
+}
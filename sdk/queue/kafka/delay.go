@@ -0,0 +1,158 @@
+package kafka
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/segmentio/kafka-go"
+)
+
+// delayed is a message of a delay topic waiting to be forwarded
+type delayed struct {
+	m    kafka.Message
+	due  time.Time
+	done bool
+}
+
+// forwardDelayed holds up to MaxDelayed messages of the delay topic of c and writes each one to its topic once due,
+// the offsets are committed in order as the messages are forwarded, a later message does not wait for an earlier one
+func (k *Kafka) forwardDelayed(c *consumer) {
+	fetched := make(chan kafka.Message)
+	go func() {
+		defer close(fetched)
+		for {
+			m, ok := k.fetch(k.fetchCtx, c)
+			if !ok {
+				return
+			}
+			select {
+			case fetched <- m:
+			case <-k.fetchCtx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		for range fetched {
+		}
+	}()
+	// by partition in offset order
+	pending := map[int][]*delayed{}
+	held := 0
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		in := fetched
+		if held >= k.MaxDelayed {
+			in = nil
+		}
+		var wake <-chan time.Time
+		if next := nextDue(pending); !next.IsZero() {
+			resetTimer(timer, time.Until(next))
+			wake = timer.C
+		}
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+			pending[m.Partition] = append(pending[m.Partition], &delayed{m: m, due: deliverAt(m)})
+			held++
+		case <-wake:
+		case <-k.fetchCtx.Done():
+			return
+		}
+		if !k.forwardDue(c, pending) {
+			return
+		}
+		for partition, msgs := range pending {
+			n := 0
+			for n < len(msgs) && msgs[n].done {
+				n++
+			}
+			if n == 0 {
+				continue
+			}
+			k.commit(c, msgs[n-1].m)
+			pending[partition] = msgs[n:]
+			held -= n
+		}
+	}
+}
+
+// forwardDue writes the due messages of pending to their topics, false on shutdown
+func (k *Kafka) forwardDue(c *consumer, pending map[int][]*delayed) bool {
+	now := time.Now()
+	var due []*delayed
+	var out []kafka.Message
+	for _, msgs := range pending {
+		for _, d := range msgs {
+			if !d.done && !d.due.After(now) {
+				due = append(due, d)
+				out = append(out, forwarded(d.m))
+			}
+		}
+	}
+	if len(out) == 0 {
+		return true
+	}
+	if !k.write(c, out...) {
+		return false
+	}
+	for _, d := range due {
+		d.done = true
+	}
+	return true
+}
+
+// nextDue returns the earliest due time of the messages not forwarded yet, zero if there is none
+func nextDue(pending map[int][]*delayed) time.Time {
+	var next time.Time
+	for _, msgs := range pending {
+		for _, d := range msgs {
+			if !d.done && (next.IsZero() || d.due.Before(next)) {
+				next = d.due
+			}
+		}
+	}
+	return next
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// deliverAt returns when m becomes due, zero if it is not delayed
+func deliverAt(m kafka.Message) time.Time {
+	for _, h := range m.Headers {
+		if h.Key == DeliverAtKey {
+			return time.Unix(0, gconv.Int64(string(h.Value)))
+		}
+	}
+	return time.Time{}
+}
+
+// forwarded returns m addressed to the topic it was delayed for, without the delay headers
+func forwarded(m kafka.Message) kafka.Message {
+	out := kafka.Message{Key: m.Key, Value: m.Value}
+	for _, h := range m.Headers {
+		switch h.Key {
+		case DeliverAtKey:
+		case TopicKey:
+			out.Topic = string(h.Value)
+		default:
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	if out.Topic == "" {
+		out.Topic = strings.TrimSuffix(m.Topic, DelaySuffix)
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// Health 检查与broker的连接, Lag为各消费者组成员读取分区和重试topic的堆积之和
+func (k *Kafka) Health(ctx context.Context) health.Status {
+	if k.drain.Closed() {
+		return health.Down(k.String(), queueLib.ErrShuttingDown)
+	}
+	if err := k.client.Ping(ctx); err != nil {
+		return health.Down(k.String(), err)
+	}
+	s := health.Up(k.String())
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	s.Consumers = len(k.consumers)
+	if s.Consumers > 0 {
+		s.Lag = 0
+		for _, c := range k.consumers {
+			s.Lag += c.reader.Stats().Lag
+		}
+		for _, c := range k.retries {
+			s.Lag += c.reader.Stats().Lag
+		}
+	}
+	return s
+}
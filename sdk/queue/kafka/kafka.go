@@ -0,0 +1,452 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// IdKey holds the message id, kafka messages only have offsets
+	IdKey = "__id"
+	// DeliverAtKey holds the unix nano time a delayed message becomes due
+	DeliverAtKey = "__deliver_at"
+	// TopicKey holds the topic a delayed message is forwarded to once due
+	TopicKey = "__topic"
+	// DelaySuffix is appended to a topic to name the topic its delayed messages wait in
+	DelaySuffix = ".delay"
+	// RetryInfix joins a topic and a consumer group to name the topic the failed messages of the group are retried on
+	RetryInfix = ".retry."
+	// DefaultRetryDelay is how long a consumer waits before writing a failed message again after a write error
+	DefaultRetryDelay = time.Second
+	// DefaultRetryBackoff and DefaultMaxRetryBackoff bound the delay before a failed message is consumed again
+	DefaultRetryBackoff    = time.Second
+	DefaultMaxRetryBackoff = 5 * time.Minute
+	// DefaultMaxDelayed is how many delayed messages a topic forwarder holds before it stops fetching
+	DefaultMaxDelayed = 1000
+)
+
+// NewKafka kafka模式, 消费者组在处理成功后手动提交offset, 路由键作为分区键;
+// dialer配置SASL/TLS, nil使用kafka.DefaultDialer
+func NewKafka(brokers []string, dialer *kafka.Dialer) (*Kafka, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka brokers are empty")
+	}
+	return newKafka(newClient(brokers, dialer)), nil
+}
+
+func newKafka(c client) *Kafka {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Kafka{
+		client:          c,
+		fetchCtx:        ctx,
+		cancel:          cancel,
+		forwarders:      map[string]*consumer{},
+		RetryDelay:      DefaultRetryDelay,
+		RetryBackoff:    DefaultRetryBackoff,
+		MaxRetryBackoff: DefaultMaxRetryBackoff,
+		MaxDelayed:      DefaultMaxDelayed,
+		Codec:           messageLib.DefaultCodec,
+	}
+}
+
+// Kafka at-least-once queue over kafka consumer groups
+type Kafka struct {
+	queueLib.MiddlewareChain
+	client    client
+	consumers []*consumer
+	// retries read the retry topic of each consumer group member
+	retries []*consumer
+	// forwarders move the due messages of the delay topic of each consumed topic
+	forwarders map[string]*consumer
+	mux        sync.Mutex
+	started    bool
+	running    sync.WaitGroup
+	drain      queueLib.Drain
+	stop       sync.Once
+	fetchCtx   context.Context
+	cancel     context.CancelFunc
+	RetryDelay time.Duration
+	// RetryBackoff doubles after each failure up to MaxRetryBackoff, a failed message waits that long in the delay topic
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	MaxDelayed      int
+	Codec           messageLib.Codec
+}
+
+// consumer is a member of a consumer group, f or batch handles its messages
+type consumer struct {
+	ctx     context.Context
+	reader  reader
+	f       queueLib.ConsumerFunc
+	batch   queueLib.BatchConsumerFunc
+	options queueLib.ConsumeOptions
+	// topic and groupID are the consumed topic and the group, also for the reader of the retry topic
+	topic   string
+	groupID string
+}
+
+// RetryTopic 消费者组groupID重试topic中失败消息的topic, 只有该组消费, 其他组不会重复处理
+func RetryTopic(topic, groupID string) string {
+	return topic + RetryInfix + groupID
+}
+
+func (*Kafka) String() string {
+	return "kafka"
+}
+
+// Publish 消息写入options.Topic或路由键同名的topic, 按路由键分区
+func (k *Kafka) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, k.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(k.String(), message, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	m, err := k.encode(message, options)
+	if err != nil {
+		return err
+	}
+	return k.client.WriteMessages(ctx, m)
+}
+
+// encode builds the kafka message of message, keyed by its routing key
+func (k *Kafka) encode(message messageLib.IMessage, options queueLib.PublishOptions) (kafka.Message, error) {
+	codec, err := options.GetCodec(k.Codec)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	body, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	id := message.GetId()
+	if id == "" {
+		id = uuid.New().String()
+	}
+	headers := []kafka.Header{
+		{Key: IdKey, Value: []byte(id)},
+		{Key: messageLib.ContentTypeKey, Value: []byte(codec.ContentType())},
+	}
+	if count := message.GetErrorCount(); count > 0 {
+		headers = append(headers, kafka.Header{Key: messageLib.ErrorCountKey, Value: []byte(strconv.FormatUint(count, 10))})
+	}
+	for key, value := range message.GetHeaders() {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	topic := options.Topic
+	if topic == "" {
+		topic = message.GetRoutingKey()
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		// a delayed message waits in the delay topic, the consumed topic is not held up by it
+		deliverAt := strconv.FormatInt(time.Now().Add(delay).UnixNano(), 10)
+		headers = append(headers,
+			kafka.Header{Key: DeliverAtKey, Value: []byte(deliverAt)},
+			kafka.Header{Key: TopicKey, Value: []byte(topic)},
+		)
+		topic += DelaySuffix
+	}
+	return kafka.Message{
+		Topic:   topic,
+		Key:     []byte(message.GetRoutingKey()),
+		Value:   body,
+		Headers: headers,
+	}, nil
+}
+
+// decode restores the message written by encode
+func (k *Kafka) decode(m kafka.Message) (*message.Message, error) {
+	msg := new(message.Message)
+	var contentType string
+	headers := map[string]string{}
+	for _, h := range m.Headers {
+		switch h.Key {
+		case IdKey:
+			msg.SetId(string(h.Value))
+		case messageLib.ContentTypeKey:
+			contentType = string(h.Value)
+		case messageLib.ErrorCountKey:
+			msg.SetErrorCount(gconv.Uint64(string(h.Value)))
+		case DeliverAtKey, TopicKey:
+		default:
+			headers[h.Key] = string(h.Value)
+		}
+	}
+	values, err := messageLib.GetCodecOrDefault(contentType, k.Codec).Unmarshal(m.Value)
+	if err != nil {
+		return nil, err
+	}
+	msg.SetValues(values)
+	msg.SetHeaders(headers)
+	msg.SetRoutingKey(string(m.Key))
+	if msg.GetRoutingKey() == "" {
+		msg.SetRoutingKey(m.Topic)
+	}
+	return msg, nil
+}
+
+// Consumer 监听topic, Concurrency个消费者组成员分担分区, 组名默认为topic名
+func (k *Kafka) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = k.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(k.String(), name, f)
+	f = queueLib.TraceConsumerFunc(k.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = k.drain.Track(f)
+	members := options.Concurrency
+	if members < 1 {
+		members = 1
+	}
+	for i := 0; i < members; i++ {
+		k.register(&consumer{ctx: ctx, f: f, options: options}, name)
+	}
+}
+
+// register creates the group reader of c and the reader of the retry topic of the group,
+// they are started at once if the queue is running
+func (k *Kafka) register(c *consumer, topic string) {
+	c.topic, c.groupID = topic, c.options.GroupID
+	if c.groupID == "" {
+		c.groupID = topic
+	}
+	c.reader = k.client.NewReader(topic, c.groupID)
+	retry := *c
+	retryTopic := RetryTopic(topic, c.groupID)
+	retry.reader = k.client.NewReader(retryTopic, retryTopic)
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.consumers = append(k.consumers, c)
+	k.retries = append(k.retries, &retry)
+	if k.started {
+		k.start(c)
+		k.start(&retry)
+	}
+	k.forward(c.ctx, topic)
+	k.forward(c.ctx, retryTopic)
+}
+
+// forward creates the forwarder of the delay topic of topic if it does not exist; k.mux must be held
+func (k *Kafka) forward(ctx context.Context, topic string) {
+	if _, ok := k.forwarders[topic]; ok {
+		return
+	}
+	// one group for the delay topic, the messages are forwarded once for all the groups of topic
+	f := &consumer{ctx: ctx, reader: k.client.NewReader(topic+DelaySuffix, topic+DelaySuffix)}
+	k.forwarders[topic] = f
+	if k.started {
+		k.start(f)
+	}
+}
+
+func (k *Kafka) start(c *consumer) {
+	k.running.Add(1)
+	go func() {
+		defer k.running.Done()
+		switch {
+		case c.f == nil && c.batch == nil:
+			k.forwardDelayed(c)
+		case c.batch != nil:
+			k.consumeBatches(c)
+		default:
+			k.consume(c)
+		}
+	}()
+}
+
+// consume handles the messages one by one, the offset is committed once the message has been settled
+func (k *Kafka) consume(c *consumer) {
+	for {
+		m, ok := k.fetch(k.fetchCtx, c)
+		if !ok {
+			return
+		}
+		msg, err := k.decode(m)
+		if err != nil {
+			if !k.reject(c, m, err) {
+				return
+			}
+		} else if !k.settle(c, m, msg, c.f(c.ctx, msg)) {
+			return
+		}
+		k.commit(c, m)
+	}
+}
+
+// fetch returns the next message of c, false once ctx is done or the reader is closed
+func (k *Kafka) fetch(ctx context.Context, c *consumer) (kafka.Message, bool) {
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err == nil {
+			return m, true
+		}
+		if ctx.Err() != nil || errors.Is(err, io.EOF) {
+			return m, false
+		}
+		glog.Warning(c.ctx, "kafka fetch error:", err)
+		select {
+		case <-time.After(k.RetryDelay):
+		case <-ctx.Done():
+			return m, false
+		}
+	}
+}
+
+func (k *Kafka) commit(c *consumer, msgs ...kafka.Message) {
+	if err := c.reader.CommitMessages(context.Background(), msgs...); err != nil {
+		glog.Warning(c.ctx, "kafka commit error:", err)
+	}
+}
+
+// settle writes a failed message to the retry topic of the group with its error count after the backoff,
+// or to the dead-letter topic once its attempts are exhausted; false if the offset must stay uncommitted because of shutdown
+func (k *Kafka) settle(c *consumer, m kafka.Message, msg messageLib.IMessage, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, queueLib.ErrShuttingDown) {
+		// fetched again by the group after a restart or rebalance
+		return false
+	}
+	msg.SetErrorIncr()
+	options := queueLib.PublishOptions{ContentType: contentType(m)}
+	if c.options.DeadLetter.Exhausted(msg, err) {
+		c.options.DeadLetter.SetDeadLetter(c.topic, msg, err)
+	} else {
+		options.Topic = RetryTopic(c.topic, c.groupID)
+		options.Delay = k.backoff(msg.GetErrorCount())
+	}
+	out, err := k.encode(msg, options)
+	if err != nil {
+		glog.Warning(c.ctx, "kafka encode failed msg error:", err)
+		return true
+	}
+	return k.write(c, out)
+}
+
+// reject moves a message that cannot be decoded to the dead-letter topic as it is, with the error in a header
+func (k *Kafka) reject(c *consumer, m kafka.Message, err error) bool {
+	glog.Warning(c.ctx, "kafka decode msg error:", err)
+	headers := append(append([]kafka.Header(nil), m.Headers...), kafka.Header{Key: messageLib.ErrorKey, Value: []byte(err.Error())})
+	return k.write(c, kafka.Message{
+		Topic:   c.options.DeadLetter.GetQueue(c.topic),
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	})
+}
+
+// write retries writing msgs every RetryDelay until it succeeds, false on shutdown
+func (k *Kafka) write(c *consumer, msgs ...kafka.Message) bool {
+	for {
+		err := k.client.WriteMessages(c.ctx, msgs...)
+		if err == nil {
+			return true
+		}
+		glog.Warning(c.ctx, "kafka write failed msg error:", err)
+		select {
+		case <-time.After(k.RetryDelay):
+		case <-k.fetchCtx.Done():
+			return false
+		}
+	}
+}
+
+// backoff returns how long a message waits after count failures, doubled from RetryBackoff up to MaxRetryBackoff
+func (k *Kafka) backoff(count uint64) time.Duration {
+	delay := k.RetryBackoff
+	for i := uint64(1); i < count && delay < k.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > k.MaxRetryBackoff {
+		delay = k.MaxRetryBackoff
+	}
+	return delay
+}
+
+func contentType(m kafka.Message) string {
+	for _, h := range m.Headers {
+		if h.Key == messageLib.ContentTypeKey {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Run 启动所有消费者, 阻塞直到ctx结束或Shutdown
+func (k *Kafka) Run(ctx context.Context) {
+	k.mux.Lock()
+	if !k.started {
+		k.started = true
+		for _, c := range k.consumers {
+			k.start(c)
+		}
+		for _, c := range k.retries {
+			k.start(c)
+		}
+		for _, f := range k.forwarders {
+			k.start(f)
+		}
+	}
+	k.mux.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-k.fetchCtx.Done():
+	}
+}
+
+// Shutdown 停止拉取消息并等待处理中的消息直到ctx结束, 未提交offset的消息由消费者组重新投递
+func (k *Kafka) Shutdown(ctx context.Context) {
+	k.stop.Do(func() {
+		k.cancel()
+		if err := k.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "kafka shutdown before handlers finished:", err)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			k.running.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+		k.mux.Lock()
+		defer k.mux.Unlock()
+		for _, c := range k.consumers {
+			if err := c.reader.Close(); err != nil {
+				glog.Warning(ctx, "kafka reader close error:", err)
+			}
+		}
+		for _, c := range k.retries {
+			if err := c.reader.Close(); err != nil {
+				glog.Warning(ctx, "kafka reader close error:", err)
+			}
+		}
+		for _, f := range k.forwarders {
+			if err := f.reader.Close(); err != nil {
+				glog.Warning(ctx, "kafka reader close error:", err)
+			}
+		}
+		if err := k.client.Close(); err != nil {
+			glog.Warning(ctx, "kafka writer close error:", err)
+		}
+	})
+}
@@ -0,0 +1,358 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeBroker is an in-process broker with one partition per topic,
+// the readers of a group share the group cursor
+type fakeBroker struct {
+	mux       sync.Mutex
+	topics    map[string][]kafka.Message
+	cursors   map[string]int64
+	committed map[string]int64
+	written   chan struct{}
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		topics:    map[string][]kafka.Message{},
+		cursors:   map[string]int64{},
+		committed: map[string]int64{},
+		written:   make(chan struct{}),
+	}
+}
+
+func (b *fakeBroker) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, m := range msgs {
+		m.Offset = int64(len(b.topics[m.Topic]))
+		b.topics[m.Topic] = append(b.topics[m.Topic], m)
+	}
+	close(b.written)
+	b.written = make(chan struct{})
+	return nil
+}
+
+func (b *fakeBroker) NewReader(topic, groupID string) reader {
+	return &fakeReader{broker: b, topic: topic, key: groupID + "/" + topic, closed: make(chan struct{})}
+}
+
+func (b *fakeBroker) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *fakeBroker) Close() error {
+	return nil
+}
+
+func (b *fakeBroker) getCommitted(group, topic string) int64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.committed[group+"/"+topic]
+}
+
+func (b *fakeBroker) getMessages(topic string) []kafka.Message {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]kafka.Message(nil), b.topics[topic]...)
+}
+
+type fakeReader struct {
+	broker    *fakeBroker
+	topic     string
+	key       string
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.broker.mux.Lock()
+		cursor := r.broker.cursors[r.key]
+		if msgs := r.broker.topics[r.topic]; cursor < int64(len(msgs)) {
+			r.broker.cursors[r.key] = cursor + 1
+			r.broker.mux.Unlock()
+			return msgs[cursor], nil
+		}
+		written := r.broker.written
+		r.broker.mux.Unlock()
+		select {
+		case <-written:
+		case <-r.closed:
+			return kafka.Message{}, io.EOF
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.broker.mux.Lock()
+	defer r.broker.mux.Unlock()
+	for _, m := range msgs {
+		if m.Offset+1 > r.broker.committed[r.key] {
+			r.broker.committed[r.key] = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Stats() kafka.ReaderStats {
+	r.broker.mux.Lock()
+	defer r.broker.mux.Unlock()
+	return kafka.ReaderStats{Lag: int64(len(r.broker.topics[r.topic])) - r.broker.committed[r.key]}
+}
+
+func (r *fakeReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKafka_Consumer(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	received := make(chan messageLib.IMessage, 1)
+	release := make(chan struct{})
+	k.Consumer(ctx, "events", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg
+		<-release
+		return nil
+	}, queueLib.WithKafkaGroupID("workers"))
+	go k.Run(ctx)
+	msg := &message.Message{
+		RoutingKey: "user.created",
+		Values:     map[string]interface{}{"key": "value"},
+	}
+	msg.SetHeader("tenant", "a")
+	if err := k.Publish(ctx, msg, queueLib.WithKafkaPublishOptionsTopic("events")); err != nil {
+		t.Fatal(err)
+	}
+	if key := string(b.getMessages("events")[0].Key); key != "user.created" {
+		t.Errorf("partition key = %s, want user.created", key)
+	}
+	select {
+	case got := <-received:
+		if got.GetRoutingKey() != "user.created" || got.GetValues()["key"] != "value" || got.GetHeader("tenant") != "a" {
+			t.Errorf("received %+v", got)
+		}
+		if got.GetId() == "" {
+			t.Error("received message without id")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+	if n := b.getCommitted("workers", "events"); n != 0 {
+		t.Errorf("offset committed before the handler returned: %d", n)
+	}
+	close(release)
+	waitFor(t, func() bool {
+		return b.getCommitted("workers", "events") == 1
+	})
+	if s := k.Health(ctx); !s.Connected || s.Consumers != 1 || s.Lag != 0 {
+		t.Errorf("Health() = %+v", s)
+	}
+}
+
+func TestKafka_DeadLetter(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	k.RetryBackoff = time.Millisecond
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	k.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		return errors.New("failed")
+	}, queueLib.WithDeadLetter("test.failed", 2))
+	dead := make(chan messageLib.IMessage, 1)
+	k.Consumer(ctx, "test.failed", func(ctx context.Context, msg messageLib.IMessage) error {
+		dead <- msg
+		return nil
+	})
+	go k.Run(ctx)
+	if err := k.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-dead:
+		if msg.GetValues()[messageLib.ErrorKey] != "failed" || msg.GetValues()["key"] != "value" {
+			t.Errorf("dead letter values = %v", msg.GetValues())
+		}
+		if msg.GetErrorCount() != 2 {
+			t.Errorf("GetErrorCount() = %d, want 2", msg.GetErrorCount())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not dead-lettered")
+	}
+	retryTopic := RetryTopic("test", "test")
+	if n := len(b.getMessages("test")); n != 1 {
+		t.Errorf("messages written to test = %d, want 1", n)
+	}
+	if n := len(b.getMessages(retryTopic + DelaySuffix)); n != 1 {
+		t.Errorf("retries written to the delay topic = %d, want 1", n)
+	}
+	waitFor(t, func() bool {
+		return b.getCommitted("test", "test") == 1 && b.getCommitted(retryTopic, retryTopic) == 1
+	})
+}
+
+func TestKafka_RetryGroup(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	k.RetryBackoff = time.Millisecond
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	var mux sync.Mutex
+	handled := map[string]int{}
+	consume := func(group string, fail bool) {
+		k.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+			mux.Lock()
+			defer mux.Unlock()
+			handled[group]++
+			if fail && handled[group] == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		}, queueLib.WithKafkaGroupID(group))
+	}
+	consume("billing", true)
+	consume("audit", false)
+	go k.Run(ctx)
+	if err := k.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		retryTopic := RetryTopic("test", "billing")
+		return b.getCommitted(retryTopic, retryTopic) == 1 && b.getCommitted("audit", "test") == 1
+	})
+	// give a retry leaked to the other group the time to be handled
+	time.Sleep(50 * time.Millisecond)
+	mux.Lock()
+	defer mux.Unlock()
+	if handled["billing"] != 2 || handled["audit"] != 1 {
+		t.Errorf("handled = %v, want the retry in billing only", handled)
+	}
+	if n := len(b.getMessages("test")); n != 1 {
+		t.Errorf("messages written to test = %d, want 1", n)
+	}
+}
+
+func TestKafka_Batch(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	msgs := make([]messageLib.IMessage, 0, 5)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": i}})
+	}
+	if err := k.PublishBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan int, 1)
+	k.BatchConsumer(ctx, "test", func(ctx context.Context, msgs []messageLib.IMessage) []error {
+		received <- len(msgs)
+		return nil
+	}, queueLib.WithConsumeOptionsBatch(5, time.Second))
+	go k.Run(ctx)
+	select {
+	case n := <-received:
+		if n != 5 {
+			t.Errorf("batch size = %d, want 5", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("batch was not consumed")
+	}
+	waitFor(t, func() bool {
+		return b.getCommitted("test", "test") == 5
+	})
+}
+
+func TestKafka_Delay(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	received := make(chan string, 2)
+	k.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg.GetValues()["key"].(string)
+		return nil
+	})
+	go k.Run(ctx)
+	start := time.Now()
+	err := k.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "later"}},
+		queueLib.WithPublishOptionsDelay(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = k.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "soon"}},
+		queueLib.WithPublishOptionsDelay(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "now"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"now", "soon", "later"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("received %s, want %s", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s was not consumed", want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("delayed message consumed after %s", elapsed)
+	}
+	waitFor(t, func() bool {
+		return b.getCommitted("test"+DelaySuffix, "test"+DelaySuffix) == 2
+	})
+}
+
+func TestKafka_DecodeError(t *testing.T) {
+	b := newFakeBroker()
+	k := newKafka(b)
+	ctx := context.Background()
+	defer k.Shutdown(ctx)
+	k.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		t.Error("undecodable message was handled")
+		return nil
+	})
+	go k.Run(ctx)
+	if err := b.WriteMessages(ctx, kafka.Message{Topic: "test", Value: []byte("{")}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return b.getCommitted("test", "test") == 1
+	})
+	dead := b.getMessages("test" + queueLib.DeadLetterSuffix)
+	if len(dead) != 1 || string(dead[0].Value) != "{" {
+		t.Fatalf("dead letters = %v", dead)
+	}
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// DeadLetters 死信队列中缓冲的消息数
+func (m *Memory) DeadLetters(ctx context.Context) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	for name := range m.dead.Names() {
+		sizes[name] = int64(len(m.getQueue(name)))
+	}
+	return sizes, nil
+}
+
+// RequeueDeadLetters 将死信队列中最多limit条消息重新发布到原队列
+func (m *Memory) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	origin, err := m.dead.Origin(name)
+	if err != nil {
+		return 0, err
+	}
+	q := m.getQueue(name)
+	n := 0
+	for limit <= 0 || n < limit {
+		msg, ok := receive(q)
+		if !ok {
+			break
+		}
+		queueLib.Requeue(msg, origin)
+		if err = m.Publish(ctx, msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// PurgeDeadLetters 清空死信队列
+func (m *Memory) PurgeDeadLetters(ctx context.Context, name string) (int, error) {
+	if _, err := m.dead.Origin(name); err != nil {
+		return 0, err
+	}
+	q := m.getQueue(name)
+	n := 0
+	for {
+		if _, ok := receive(q); !ok {
+			return n, nil
+		}
+		n++
+	}
+}
+
+// receive takes a waiting message of q without blocking
+func receive(q queue) (messageLib.IMessage, bool) {
+	select {
+	case msg := <-q:
+		return msg, true
+	default:
+		return nil, false
+	}
+}
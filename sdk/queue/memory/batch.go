@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"sync/atomic"
+	"time"
+)
+
+// PublishBatch 批量入队
+func (m *Memory) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
+	return queueLib.PublishEach(ctx, m, msgs, optionFuncs...)
+}
+
+// BatchConsumer 批量消费, 收到首条消息后等待BatchTimeout或凑满BatchSize条再交给f,
+// 失败的消息按各自的错误再次交给f或进入死信队列, 队列中间件按单条消息执行不作用于批量消费
+func (m *Memory) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	size, timeout := options.GetBatch()
+	f = queueLib.MetricsBatchConsumerFunc(m.String(), name, f)
+	f = queueLib.DropPermanentBatch(name, f, options)
+	f = m.drain.TrackBatch(f)
+	m.dead.Add(name, options)
+	atomic.AddInt64(&m.consumers, 1)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if options.MaxPriority > 0 {
+		m.priorities.Store(name, options.MaxPriority)
+	}
+	q := m.levels(name, options.MaxPriority)
+	go func(in *levels, gf queueLib.BatchConsumerFunc) {
+		for {
+			msgs, ok := m.collect(in, size, timeout)
+			if !ok {
+				return
+			}
+			running := true
+			for len(msgs) > 0 {
+				errs := gf(ctx, msgs)
+				var failed []messageLib.IMessage
+				for i, msg := range msgs {
+					retry, ok := m.settle(ctx, name, in, msg, queueLib.BatchResult(errs, i), options)
+					running = running && ok
+					if retry {
+						failed = append(failed, msg)
+					}
+				}
+				msgs = failed
+			}
+			if !running {
+				return
+			}
+		}
+	}(q, f)
+}
+
+// collect waits for the first message, then receives until the batch is full or timeout has elapsed
+func (m *Memory) collect(in *levels, size int, timeout time.Duration) ([]messageLib.IMessage, bool) {
+	first, ok := in.next(m.done)
+	if !ok {
+		return nil, false
+	}
+	msgs := []messageLib.IMessage{first}
+	window := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		close(window)
+	})
+	defer timer.Stop()
+	for len(msgs) < size {
+		msg, ok := in.next(window)
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, true
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"container/heap"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"sync"
+	"time"
+)
+
+type delayed struct {
+	at  time.Time
+	msg messageLib.IMessage
+	q   queue
+}
+
+// delayHeap orders the delayed messages by delivery time
+type delayHeap []*delayed
+
+func (h delayHeap) Len() int           { return len(h) }
+func (h delayHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h delayHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap) Push(x interface{}) {
+	*h = append(*h, x.(*delayed))
+}
+
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// scheduler delivers delayed messages with a single timer on top of a heap
+type scheduler struct {
+	mutex sync.Mutex
+	items delayHeap
+	wake  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	halt  sync.Once
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// add schedules msg to be sent to q at the given time
+func (s *scheduler) add(at time.Time, msg messageLib.IMessage, q queue) {
+	s.once.Do(func() {
+		go s.run()
+	})
+	s.mutex.Lock()
+	heap.Push(&s.items, &delayed{at: at, msg: msg, q: q})
+	s.mutex.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due pops every message whose delivery time has come and returns the wait until the next one
+func (s *scheduler) due(now time.Time) ([]*delayed, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var items []*delayed
+	for s.items.Len() > 0 && !s.items[0].at.After(now) {
+		items = append(items, heap.Pop(&s.items).(*delayed))
+	}
+	if s.items.Len() == 0 {
+		return items, -1
+	}
+	return items, s.items[0].at.Sub(now)
+}
+
+func (s *scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		items, wait := s.due(time.Now())
+		for _, item := range items {
+			go func(gm messageLib.IMessage, gq queue) {
+				gq <- gm
+			}(item.msg, item.q)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait >= 0 {
+			timer.Reset(wait)
+		} else {
+			timer.Reset(time.Hour)
+		}
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stop ends the scheduling, the messages still waiting are dropped
+func (s *scheduler) stop() {
+	s.halt.Do(func() {
+		close(s.done)
+	})
+}
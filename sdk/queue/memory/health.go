@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"sync/atomic"
+)
+
+// Health Lag为缓冲中等待消费的消息数
+func (m *Memory) Health(ctx context.Context) health.Status {
+	select {
+	case <-m.done:
+		return health.Down(m.String(), queueLib.ErrShuttingDown)
+	default:
+	}
+	s := health.Up(m.String())
+	s.Consumers = int(atomic.LoadInt64(&m.consumers))
+	s.Lag = 0
+	m.queue.Range(func(key, value interface{}) bool {
+		if q, ok := value.(queue); ok {
+			s.Lag += int64(len(q))
+		}
+		return true
+	})
+	return s
+}
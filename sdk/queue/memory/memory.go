@@ -2,11 +2,15 @@ package memory
 
 import (
 	"context"
+	"errors"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
 	"github.com/google/uuid"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type queue chan messageLib.IMessage
@@ -15,15 +19,27 @@ type queue chan messageLib.IMessage
 func NewMemory(poolNum uint) *Memory {
 	return &Memory{
 		queue:   new(sync.Map),
+		delay:   newScheduler(),
+		done:    make(chan struct{}),
 		PoolNum: poolNum,
 	}
 }
 
 type Memory struct {
-	queue   *sync.Map
-	wait    sync.WaitGroup
-	mutex   sync.RWMutex
-	PoolNum uint
+	queueLib.MiddlewareChain
+	queue      *sync.Map
+	priorities sync.Map
+	delay      *scheduler
+	drain      queueLib.Drain
+	dead       queueLib.DeadLetterQueues
+	consumers  int64
+	done       chan struct{}
+	stop       sync.Once
+	mutex      sync.RWMutex
+	pending    queueLib.Pending
+	replyOnce  sync.Once
+	replyQueue string
+	PoolNum    uint
 }
 
 func (*Memory) String() string {
@@ -37,72 +53,143 @@ func (m *Memory) makeQueue() queue {
 	return make(queue, m.PoolNum)
 }
 
+// getQueue returns the queue of name, it will be created if not exist
+func (m *Memory) getQueue(name string) queue {
+	if v, ok := m.queue.Load(name); ok {
+		if q, ok := v.(queue); ok {
+			return q
+		}
+	}
+	v, _ := m.queue.LoadOrStore(name, m.makeQueue())
+	return v.(queue)
+}
+
 // Publish 消息入生产者
-func (m *Memory) Publish(ctx context.Context, msg messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
+func (m *Memory) Publish(ctx context.Context, msg messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, m.String(), msg)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(m.String(), msg, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 	memoryMessage := new(message.Message)
 	memoryMessage.SetId(msg.GetId())
 	memoryMessage.SetRoutingKey(msg.GetRoutingKey())
-	memoryMessage.SetValues(msg.GetValues())
-	v, ok := m.queue.Load(msg.GetRoutingKey())
-
-	// TODO: 错误超出5次将放弃
-	if !ok && memoryMessage.GetErrorCount() < 5 {
-		v = m.makeQueue()
-		m.queue.Store(msg.GetRoutingKey(), v)
-		memoryMessage.SetErrorIncr()
+	// the consumer gets its own maps, changing them doesn't change the published message
+	values := make(map[string]interface{}, len(msg.GetValues()))
+	for k, v := range msg.GetValues() {
+		values[k] = v
 	}
-
-	var q queue
-	switch v.(type) {
-	case queue:
-		q = v.(queue)
+	headers := make(map[string]string, len(msg.GetHeaders()))
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	memoryMessage.SetValues(values)
+	memoryMessage.SetErrorCount(msg.GetErrorCount())
+	memoryMessage.SetHeaders(headers)
+	priority := options.GetPriority(m.maxPriority(msg.GetRoutingKey()))
+	queueLib.SetPriority(memoryMessage, priority)
+	queueLib.SetOrderingKey(memoryMessage, options.OrderingKey)
+	q := m.getQueue(levelName(msg.GetRoutingKey(), priority))
+	if memoryMessage.GetId() == "" {
+		memoryMessage.SetId(uuid.New().String())
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		m.delay.add(time.Now().Add(delay), memoryMessage, q)
+		return nil
+	}
+	// 队列未满时同步入队, 保持发布顺序
+	select {
+	case q <- memoryMessage:
 	default:
-		q = m.makeQueue()
-		m.queue.Store(msg.GetRoutingKey(), q)
+		go func(gm messageLib.IMessage, gq queue) {
+			gq <- gm
+		}(memoryMessage, q)
 	}
-	go func(gm messageLib.IMessage, gq queue) {
-		gm.SetId(uuid.New().String())
-		gq <- gm
-	}(memoryMessage, q)
 	return nil
 }
 
-// Consumer 监听消费者
+// Consumer 监听消费者, options.Concurrency个协程并发处理, 相同OrderingKey的消息由同一协程依次处理
 func (m *Memory) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = m.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(m.String(), name, f)
+	f = queueLib.TraceConsumerFunc(m.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = m.drain.Track(f)
+	m.dead.Add(name, options)
+	atomic.AddInt64(&m.consumers, 1)
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	v, ok := m.queue.Load(name)
-	if !ok {
-		v = m.makeQueue()
-		m.queue.Store(name, v)
-	}
-	var q queue
-	switch v.(type) {
-	case queue:
-		q = v.(queue)
+	if options.MaxPriority > 0 {
+		m.priorities.Store(name, options.MaxPriority)
+	}
+	q := m.levels(name, options.MaxPriority)
+	w := newWorkers(options)
+	for _, own := range w.keyed {
+		go m.work(ctx, name, q, w, own, f, options)
+	}
+	go m.dispatch(q, w)
+}
+
+// settle dead-letters a failed message once its retries are exhausted, retry if it has to be handled again,
+// running false if the consumer has to stop on shutdown
+func (m *Memory) settle(ctx context.Context, name string, in *levels, msg messageLib.IMessage, err error, options queueLib.ConsumeOptions) (retry, running bool) {
+	if errors.Is(err, queueLib.ErrShuttingDown) {
+		m.putBack(ctx, in.get(queueLib.GetPriority(msg)), msg)
+		return false, false
+	}
+	if err == nil {
+		return false, true
+	}
+	msg.SetErrorIncr()
+	if !options.DeadLetter.Exhausted(msg, err) {
+		return true, true
+	}
+	options.DeadLetter.SetDeadLetter(name, msg, err)
+	if err = m.Publish(ctx, msg); err != nil {
+		glog.Warning(ctx, "memory dead letter publish error:", err)
+	}
+	return false, true
+}
+
+// putBack returns a message received during shutdown to its queue
+func (m *Memory) putBack(ctx context.Context, q queue, msg messageLib.IMessage) {
+	select {
+	case q <- msg:
 	default:
-		q = m.makeQueue()
-		m.queue.Store(name, q)
-	}
-	go func(out queue, gf queueLib.ConsumerFunc) {
-		var err error
-		for iMessage := range q {
-			err = gf(ctx, iMessage)
-			if err != nil {
-				out <- iMessage
-				err = nil
-			}
-		}
-	}(q, f)
+		glog.Warning(ctx, "memory queue is full on shutdown, msg lost:", msg.GetId())
+	}
 }
 
 func (m *Memory) Run(ctx context.Context) {
-	m.wait.Add(1)
-	m.wait.Wait()
+	select {
+	case <-ctx.Done():
+	case <-m.done:
+	}
 }
 
+// Shutdown 停止消费, 等待处理中的消息直到ctx结束, 未消费的消息会丢失
 func (m *Memory) Shutdown(ctx context.Context) {
-	m.wait.Done()
+	m.stop.Do(func() {
+		close(m.done)
+		m.delay.stop()
+		if err := m.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "memory queue shutdown before handlers finished:", err)
+		}
+		m.queue.Range(func(key, value interface{}) bool {
+			if q, ok := value.(queue); ok && len(q) > 0 {
+				glog.Warningf(ctx, "memory queue %v dropped %d msg on shutdown", key, len(q))
+			}
+			return true
+		})
+	})
 }
@@ -2,12 +2,16 @@ package memory
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/core/v2/task"
 	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/util/gconv"
 	"log"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -107,3 +111,486 @@ func TestMemory_Consumer(t *testing.T) {
 		})
 	}
 }
+
+func TestMemory_ConsumerDeadLetter(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	attempts := 0
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		attempts++
+		return fmt.Errorf("attempt %d failed", attempts)
+	}, queueLib.WithDeadLetter("test.failed", 3))
+	dead := make(chan messageLib.IMessage, 1)
+	m.Consumer(ctx, "test.failed", func(ctx context.Context, msg messageLib.IMessage) error {
+		dead <- msg
+		return nil
+	})
+	if err := m.Publish(ctx, &message.Message{
+		RoutingKey: "test",
+		Values: map[string]interface{}{
+			"key": "value",
+		}}); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case msg := <-dead:
+		if attempts != 3 {
+			t.Errorf("attempts = %d, want 3", attempts)
+		}
+		if msg.GetErrorCount() != 3 {
+			t.Errorf("GetErrorCount() = %d, want 3", msg.GetErrorCount())
+		}
+		if got := msg.GetValues()[messageLib.ErrorKey]; got != "attempt 3 failed" {
+			t.Errorf("last error = %v, want %v", got, "attempt 3 failed")
+		}
+		if got := msg.GetValues()["key"]; got != "value" {
+			t.Errorf("value = %v, want %v", got, "value")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("message was not dead-lettered")
+	}
+}
+
+func TestMemory_Request(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	m.Consumer(ctx, "rpc", func(ctx context.Context, msg messageLib.IMessage) error {
+		if msg.GetValues()["key"] == "fail" {
+			return m.Reply(ctx, msg, nil, fmt.Errorf("failed"))
+		}
+		return m.Reply(ctx, msg, msg.GetValues()["key"], nil)
+	})
+	tests := []struct {
+		name    string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"reply", "value", "value", false},
+		{"error", "fail", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+			defer cancel()
+			reply, err := m.Request(reqCtx, &message.Message{
+				RoutingKey: "rpc",
+				Values: map[string]interface{}{
+					"key": tt.value,
+				}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Request() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := reply.GetValues()[queueLib.ReplyDataKey]; got != tt.want {
+				t.Errorf("Request() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("nil values", func(t *testing.T) {
+		reqCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		reply, err := m.Request(reqCtx, &message.Message{RoutingKey: "rpc"})
+		if err != nil || reply.GetValues()[queueLib.ReplyDataKey] != nil {
+			t.Errorf("Request() = %v, %v", reply, err)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		reqCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		if _, err := m.Request(reqCtx, &message.Message{RoutingKey: "nobody"}); err != context.DeadlineExceeded {
+			t.Errorf("Request() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestMemory_ShutdownDrain(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	started, finished := make(chan struct{}), make(chan struct{})
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		close(finished)
+		return nil
+	})
+	go m.Run(ctx)
+	if err := m.Publish(ctx, &message.Message{RoutingKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	shutdownCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	m.Shutdown(shutdownCtx)
+	select {
+	case <-finished:
+	default:
+		t.Error("Shutdown() returned before the running handler finished")
+	}
+	m.Shutdown(shutdownCtx)
+}
+
+func TestMemory_Priority(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	gate := make(chan struct{})
+	received := make(chan interface{}, 21)
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg.GetValues()["key"]
+		<-gate
+		return nil
+	}, queueLib.WithConsumeOptionsMaxPriority(queueLib.MaxPriority))
+	publish := func(key string, priority uint8) {
+		err := m.Publish(ctx, &message.Message{
+			RoutingKey: "test",
+			Values:     map[string]interface{}{"key": key},
+		}, queueLib.WithPublishOptionsPriority(priority))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("warmup", 0)
+	<-received
+	for i := 0; i < 10; i++ {
+		publish("low", 0)
+		publish("high", queueLib.MaxPriority)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(m.getQueue("test"))+len(m.getQueue(levelName("test", queueLib.MaxPriority))) < 20 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(gate)
+	for i := 0; i < 20; i++ {
+		want := "high"
+		if i >= queueLib.PriorityWeight(queueLib.MaxPriority) {
+			want = "low"
+		}
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("message %d = %v, want %v", i, got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message was not consumed")
+		}
+	}
+}
+
+func TestMemory_BatchConsumer(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	batches := make(chan []string, 10)
+	failed := false
+	m.BatchConsumer(ctx, "test", func(ctx context.Context, msgs []messageLib.IMessage) []error {
+		keys := make([]string, len(msgs))
+		errs := make([]error, len(msgs))
+		for i, msg := range msgs {
+			keys[i] = msg.GetValues()["key"].(string)
+			if keys[i] == "fail" && !failed {
+				failed = true
+				errs[i] = fmt.Errorf("failed")
+			}
+		}
+		batches <- keys
+		return errs
+	}, queueLib.WithConsumeOptionsBatch(3, 100*time.Millisecond))
+	msgs := make([]messageLib.IMessage, 0, 5)
+	for _, key := range []string{"a", "b", "fail", "c", "d"} {
+		msgs = append(msgs, &message.Message{
+			RoutingKey: "test",
+			Values:     map[string]interface{}{"key": key},
+		})
+	}
+	if err := m.PublishBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	received := map[string]int{}
+	for total := 0; total < 6; {
+		select {
+		case keys := <-batches:
+			if len(keys) > 3 {
+				t.Errorf("batch size = %d, want <= 3", len(keys))
+			}
+			for _, key := range keys {
+				received[key]++
+			}
+			total += len(keys)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("received %v, want every message and the failed one twice", received)
+		}
+	}
+	if received["fail"] != 2 {
+		t.Errorf("failed message received %d times, want 2", received["fail"])
+	}
+}
+
+func TestMemory_Concurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		maxInFlight int
+		want        int
+	}{
+		{name: "concurrency", concurrency: 4, want: 4},
+		{name: "maxInFlight", concurrency: 4, maxInFlight: 2, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory(100)
+			ctx := context.Background()
+			defer m.Shutdown(ctx)
+			var running, peak int
+			var mux sync.Mutex
+			received := map[string][]int{}
+			done := make(chan struct{}, 40)
+			m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+				mux.Lock()
+				running++
+				if running > peak {
+					peak = running
+				}
+				mux.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mux.Lock()
+				running--
+				key := queueLib.GetOrderingKey(msg)
+				received[key] = append(received[key], gconv.Int(msg.GetValues()["seq"]))
+				mux.Unlock()
+				done <- struct{}{}
+				return nil
+			}, queueLib.WithConsumeOptionsConcurrency(tt.concurrency), queueLib.WithConsumeOptionsMaxInFlight(tt.maxInFlight))
+			for i := 0; i < 10; i++ {
+				for _, key := range []string{"a", "b", "c", "d"} {
+					err := m.Publish(ctx, &message.Message{
+						RoutingKey: "test",
+						Values:     map[string]interface{}{"seq": i},
+					}, queueLib.WithPublishOptionsOrderingKey(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			for i := 0; i < 40; i++ {
+				select {
+				case <-done:
+				case <-time.After(3 * time.Second):
+					t.Fatal("message was not consumed")
+				}
+			}
+			if peak > tt.want {
+				t.Errorf("peak concurrency = %d, want at most %d", peak, tt.want)
+			}
+			if peak < 2 {
+				t.Errorf("peak concurrency = %d, messages were not handled concurrently", peak)
+			}
+			for key, seqs := range received {
+				for i, seq := range seqs {
+					if seq != i {
+						t.Fatalf("key %s handled out of order: %v", key, seqs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMemory_PublishCopy(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	done := make(chan struct{})
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		msg.SetHeader("consumer", "changed")
+		msg.GetValues()["consumer"] = "changed"
+		close(done)
+		return nil
+	})
+	headers := map[string]string{"key": "value"}
+	values := map[string]interface{}{"key": "value"}
+	err := m.Publish(ctx, &message.Message{RoutingKey: "test", Headers: headers, Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+	if len(headers) != 1 || len(values) != 1 {
+		t.Errorf("published message changed: headers %v, values %v", headers, values)
+	}
+}
+
+func TestMemory_RetryOrder(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	var mux sync.Mutex
+	var received []int
+	failed := false
+	done := make(chan struct{})
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		seq := gconv.Int(msg.GetValues()["seq"])
+		mux.Lock()
+		defer mux.Unlock()
+		received = append(received, seq)
+		if seq == 1 && !failed {
+			failed = true
+			return errors.New("retry")
+		}
+		if seq == 4 {
+			close(done)
+		}
+		return nil
+	}, queueLib.WithConsumeOptionsConcurrency(2), queueLib.WithDeadLetter("", 3))
+	for i := 0; i < 5; i++ {
+		err := m.Publish(ctx, &message.Message{
+			RoutingKey: "test",
+			Values:     map[string]interface{}{"seq": i},
+		}, queueLib.WithPublishOptionsOrderingKey("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if want := []int{0, 1, 1, 2, 3, 4}; fmt.Sprint(received) != fmt.Sprint(want) {
+		t.Errorf("received %v, want %v", received, want)
+	}
+}
+
+func TestMemory_TypedTask(t *testing.T) {
+	type order struct {
+		Id   int    `json:"id" v:"min:1"`
+		Name string `json:"name" v:"required"`
+	}
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	handled := make(chan order, 2)
+	handler := task.Typed[order]("orders", func(ctx context.Context, payload order) (interface{}, error) {
+		handled <- payload
+		return nil, nil
+	})
+	m.Consumer(ctx, "orders", task.WrapHandler(handler), queueLib.WithDeadLetter("", 3))
+	dead := make(chan messageLib.IMessage, 1)
+	m.Consumer(ctx, "orders"+queueLib.DeadLetterSuffix, func(ctx context.Context, msg messageLib.IMessage) error {
+		dead <- msg
+		return nil
+	})
+	for _, values := range []map[string]interface{}{
+		{"id": 1, "name": "values"},
+		{"body": `{"id":2,"name":"body"}`, messageLib.PrefixKey: "host"},
+		{"id": 3},
+	} {
+		if err := m.Publish(ctx, &message.Message{RoutingKey: "orders", Values: values}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []order{{Id: 1, Name: "values"}, {Id: 2, Name: "body"}} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("payload = %+v, want %+v", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message was not handled")
+		}
+	}
+	select {
+	case msg := <-dead:
+		if msg.GetErrorCount() != 1 {
+			t.Errorf("GetErrorCount() = %d, want 1, invalid payloads must not be retried", msg.GetErrorCount())
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("invalid message was not dead-lettered")
+	}
+}
+
+func TestMemory_DropPermanent(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	var calls int32
+	m.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		atomic.AddInt32(&calls, 1)
+		return queueLib.Permanent(errors.New("invalid"))
+	})
+	if err := m.Publish(ctx, &message.Message{RoutingKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handled %d times, a permanent failure without dead-letter queue must be dropped", n)
+	}
+}
+
+func TestMemory_TaskRetry(t *testing.T) {
+	m := NewMemory(100)
+	ctx := context.Background()
+	defer m.Shutdown(ctx)
+	unavailable := errors.New("unavailable")
+	policy := &task.RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: 50 * time.Millisecond,
+		Retryable: func(err error) bool {
+			return errors.Is(err, unavailable)
+		},
+	}
+	attempts := make(chan time.Time, 10)
+	m.Consumer(ctx, "retry", func(ctx context.Context, msg messageLib.IMessage) error {
+		attempts <- time.Now()
+		if msg.GetValues()["key"] == "invalid" {
+			return errors.New("invalid")
+		}
+		return unavailable
+	}, queueLib.WithConsumeOptionsMiddlewares(task.Retry(m, policy, "")), queueLib.WithDeadLetter("", 1))
+	dead := make(chan messageLib.IMessage, 2)
+	m.Consumer(ctx, "retry"+queueLib.DeadLetterSuffix, func(ctx context.Context, msg messageLib.IMessage) error {
+		dead <- msg
+		return nil
+	})
+	if err := m.Publish(ctx, &message.Message{RoutingKey: "retry", Values: map[string]interface{}{"key": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case at := <-attempts:
+			if want := policy.Delay(i); i > 0 && at.Sub(last) < want {
+				t.Errorf("attempt %d after %s, want at least %s", i+1, at.Sub(last), want)
+			}
+			last = at
+		case <-time.After(3 * time.Second):
+			t.Fatalf("attempt %d was not made", i+1)
+		}
+	}
+	select {
+	case msg := <-dead:
+		if got := task.GetAttempt(msg); got != 3 {
+			t.Errorf("GetAttempt() = %d, want 3", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("exhausted message was not dead-lettered")
+	}
+	if err := m.Publish(ctx, &message.Message{RoutingKey: "retry", Values: map[string]interface{}{"key": "invalid"}}); err != nil {
+		t.Fatal(err)
+	}
+	<-attempts
+	select {
+	case msg := <-dead:
+		if got := task.GetAttempt(msg); got != 1 {
+			t.Errorf("GetAttempt() = %d, want 1, errors not retryable must not be retried", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message failing with an error not retryable was not dead-lettered")
+	}
+}
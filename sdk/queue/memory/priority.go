@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"reflect"
+)
+
+// levelName returns the name of the queue holding the messages of name with the given priority
+func levelName(name string, priority uint8) string {
+	if priority == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s:priority:%d", name, priority)
+}
+
+// maxPriority returns the max priority declared by the consumers of name
+func (m *Memory) maxPriority(name string) uint8 {
+	if v, ok := m.priorities.Load(name); ok {
+		return v.(uint8)
+	}
+	return 0
+}
+
+// levels returns the queues of name from priority 0 to max
+func (m *Memory) levels(name string, max uint8) *levels {
+	l := &levels{
+		queues:  make([]queue, max+1),
+		credits: make([]int, max+1),
+	}
+	for p := range l.queues {
+		l.queues[p] = m.getQueue(levelName(name, uint8(p)))
+	}
+	return l
+}
+
+// levels drains the priority queues of a consumer, in every round a priority
+// delivers up to queueLib.PriorityWeight messages, the highest priority first
+type levels struct {
+	queues  []queue
+	credits []int
+}
+
+// get returns the queue of the priority
+func (l *levels) get(priority uint8) queue {
+	if int(priority) >= len(l.queues) {
+		priority = uint8(len(l.queues) - 1)
+	}
+	return l.queues[priority]
+}
+
+// next receives the next message, false once done is closed
+func (l *levels) next(done <-chan struct{}) (messageLib.IMessage, bool) {
+	if len(l.queues) == 1 {
+		select {
+		case <-done:
+			return nil, false
+		case msg := <-l.queues[0]:
+			return msg, true
+		}
+	}
+	if msg, ok := l.poll(); ok {
+		return msg, true
+	}
+	// the round is over, every priority gets its weight again
+	for p := range l.credits {
+		l.credits[p] = queueLib.PriorityWeight(uint8(p))
+	}
+	if msg, ok := l.poll(); ok {
+		return msg, true
+	}
+	return l.wait(done)
+}
+
+// poll receives without blocking from the highest priority having credits left
+func (l *levels) poll() (messageLib.IMessage, bool) {
+	for p := len(l.queues) - 1; p >= 0; p-- {
+		if l.credits[p] == 0 {
+			continue
+		}
+		select {
+		case msg := <-l.queues[p]:
+			l.credits[p]--
+			return msg, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+// wait blocks until any priority delivers a message
+func (l *levels) wait(done <-chan struct{}) (messageLib.IMessage, bool) {
+	cases := make([]reflect.SelectCase, 0, len(l.queues)+1)
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	for _, q := range l.queues {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q)})
+	}
+	chosen, v, _ := reflect.Select(cases)
+	if chosen == 0 {
+		return nil, false
+	}
+	if l.credits[chosen-1] > 0 {
+		l.credits[chosen-1]--
+	}
+	return v.Interface().(messageLib.IMessage), true
+}
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/google/uuid"
+)
+
+// Request 发布请求并等待回复
+func (m *Memory) Request(ctx context.Context, msg messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (messageLib.IMessage, error) {
+	m.replyOnce.Do(func() {
+		m.replyQueue = queueLib.ReplyQueuePrefix + uuid.New().String()
+		m.Consumer(gctx.New(), m.replyQueue, func(ctx context.Context, reply messageLib.IMessage) error {
+			m.pending.Resolve(reply)
+			return nil
+		})
+	})
+	correlationId := uuid.New().String()
+	headers := make(map[string]string, len(msg.GetHeaders())+2)
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	headers[queueLib.ReplyToKey] = m.replyQueue
+	headers[queueLib.CorrelationIdKey] = correlationId
+	request := &message.Message{
+		Id:         msg.GetId(),
+		RoutingKey: msg.GetRoutingKey(),
+		Values:     msg.GetValues(),
+		Headers:    headers,
+	}
+	ch := m.pending.Add(correlationId)
+	err := m.Publish(ctx, request, optionFuncs...)
+	if err != nil {
+		m.pending.Remove(correlationId)
+		return nil, err
+	}
+	return m.pending.Wait(ctx, correlationId, ch)
+}
+
+// Reply 回复请求方
+func (m *Memory) Reply(ctx context.Context, request messageLib.IMessage, data interface{}, err error) error {
+	return m.Publish(ctx, &message.Message{
+		RoutingKey: queueLib.GetReplyTo(request),
+		Values:     queueLib.GetReplyValues(data, err),
+		Headers:    map[string]string{queueLib.CorrelationIdKey: queueLib.GetCorrelationId(request)},
+	})
+}
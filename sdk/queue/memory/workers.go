@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"hash/fnv"
+)
+
+// workers handle the messages of a consumer with options.Concurrency goroutines, the messages sharing
+// an ordering key go to the same goroutine and stay in order, the others go to whichever is free.
+// at most options.GetMaxInFlight() messages are taken from the queue and not settled yet
+type workers struct {
+	keyed      []queue
+	shared     queue
+	slots      chan struct{}
+	dispatched chan struct{}
+}
+
+func newWorkers(options queueLib.ConsumeOptions) *workers {
+	n := options.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	inFlight := options.GetMaxInFlight()
+	w := &workers{
+		keyed:      make([]queue, n),
+		shared:     make(queue, inFlight),
+		slots:      make(chan struct{}, inFlight),
+		dispatched: make(chan struct{}),
+	}
+	for i := range w.keyed {
+		w.keyed[i] = make(queue, inFlight)
+	}
+	return w
+}
+
+// route hands msg to a worker, it never blocks as every queue holds all the in-flight messages
+func (w *workers) route(msg messageLib.IMessage) {
+	key := queueLib.GetOrderingKey(msg)
+	if key == "" {
+		w.shared <- msg
+		return
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	w.keyed[h.Sum32()%uint32(len(w.keyed))] <- msg
+}
+
+// dispatch takes messages from in while there are free slots until the queue is shut down
+func (m *Memory) dispatch(in *levels, w *workers) {
+	defer close(w.dispatched)
+	for {
+		select {
+		case <-m.done:
+			return
+		case w.slots <- struct{}{}:
+		}
+		msg, ok := in.next(m.done)
+		if !ok {
+			return
+		}
+		w.route(msg)
+	}
+}
+
+// work handles the messages routed to own and the shared ones,
+// on shutdown the messages not handled yet are put back once the dispatch stopped
+func (m *Memory) work(ctx context.Context, name string, in *levels, w *workers, own queue, f queueLib.ConsumerFunc, options queueLib.ConsumeOptions) {
+	for {
+		var msg messageLib.IMessage
+		select {
+		case <-m.done:
+			<-w.dispatched
+			m.putBackAll(ctx, in, own, w.shared)
+			return
+		case msg = <-own:
+		case msg = <-w.shared:
+		}
+		running := m.handle(ctx, name, in, msg, f, options)
+		<-w.slots
+		if !running {
+			<-w.dispatched
+			m.putBackAll(ctx, in, own, w.shared)
+			return
+		}
+	}
+}
+
+// handle runs f until msg is settled, a failed message is retried by the same worker
+// so the messages of its ordering key stay in order, false if the consumer has to stop on shutdown
+func (m *Memory) handle(ctx context.Context, name string, in *levels, msg messageLib.IMessage, f queueLib.ConsumerFunc, options queueLib.ConsumeOptions) bool {
+	for {
+		retry, running := m.settle(ctx, name, in, msg, f(ctx, msg), options)
+		if !retry {
+			return running
+		}
+	}
+}
+
+// putBackAll returns the messages waiting for a worker to their queues
+func (m *Memory) putBackAll(ctx context.Context, in *levels, queues ...queue) {
+	for _, q := range queues {
+		for {
+			select {
+			case msg := <-q:
+				m.putBack(ctx, in.get(queueLib.GetPriority(msg)), msg)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
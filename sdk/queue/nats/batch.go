@@ -0,0 +1,92 @@
+package nats
+
+import (
+	"context"
+	"errors"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 异步发送所有消息后统一等待stream确认
+func (n *NATS) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(n.String(), msgs[i], err)
+		}
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	futures := make([]nats.PubAckFuture, 0, len(msgs))
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, n.String(), message)
+		spans = append(spans, span)
+		msg, err := n.encode(message, options)
+		if err != nil {
+			return err
+		}
+		future, err := n.js.PublishMsgAsync(msg, nats.MsgId(msgId(msg)))
+		if err != nil {
+			return err
+		}
+		futures = append(futures, future)
+	}
+	for _, future := range futures {
+		select {
+		case <-future.Ok():
+		case err = <-future.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// BatchConsumer 批量消费, 每次拉取BatchSize条或BatchTimeout内到达的消息, 按各自的结果确认,
+// 队列中间件按单条消息执行不作用于批量消费
+func (n *NATS) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = queueLib.MetricsBatchConsumerFunc(n.String(), name, f)
+	f = queueLib.DropPermanentBatch(name, f, options)
+	f = n.drain.TrackBatch(f)
+	if err := n.register(&consumer{ctx: ctx, name: name, batch: f, options: options}); err != nil {
+		glog.Errorf(ctx, "nats batch consumer %s subscribe error:%v", name, err)
+	}
+}
+
+func (n *NATS) handleBatch(c *consumer, msgs []*nats.Msg) {
+	raws := make([]*nats.Msg, 0, len(msgs))
+	decoded := make([]messageLib.IMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		m, err := n.decode(msg)
+		if err != nil {
+			glog.Warning(c.ctx, "nats decode msg error:", err)
+			n.term(c, msg)
+			continue
+		}
+		raws = append(raws, msg)
+		decoded = append(decoded, m)
+	}
+	if len(decoded) == 0 {
+		return
+	}
+	errs := c.batch(c.ctx, decoded)
+	if errors.Is(queueLib.BatchResult(errs, 0), queueLib.ErrShuttingDown) {
+		return
+	}
+	for i, m := range decoded {
+		n.settle(c, raws[i], m, queueLib.BatchResult(errs, i))
+	}
+}
@@ -0,0 +1,35 @@
+package nats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// Health 检查与nats的连接, Lag为各durable消费者未投递和未确认的消息数之和
+func (n *NATS) Health(ctx context.Context) health.Status {
+	if n.drain.Closed() {
+		return health.Down(n.String(), queueLib.ErrShuttingDown)
+	}
+	if !n.conn.IsConnected() {
+		return health.Down(n.String(), errors.New("nats is "+n.conn.Status().String()))
+	}
+	s := health.Up(n.String())
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	s.Consumers = len(n.consumers)
+	if s.Consumers == 0 {
+		return s
+	}
+	s.Lag = 0
+	for _, c := range n.consumers {
+		info, err := n.js.ConsumerInfo(n.stream, c.durable)
+		if err != nil {
+			return health.Down(n.String(), err)
+		}
+		s.Lag += int64(info.NumPending) + int64(info.NumAckPending)
+	}
+	return s
+}
@@ -0,0 +1,438 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// IdKey holds the message id, it is also part of the JetStream deduplication id
+	IdKey = "__id"
+	// DeliverAtKey holds the unix nano time a delayed message becomes due
+	DeliverAtKey = "__deliver_at"
+	// DefaultSubjectPrefix is prepended to the routing keys, the stream captures DefaultSubjectPrefix + ">"
+	DefaultSubjectPrefix = "queue."
+	// DefaultAckWait is how long a delivery waits for its ack before it is redelivered
+	DefaultAckWait = 30 * time.Second
+	// DefaultPollInterval bounds a pull request so the consumers notice Shutdown
+	DefaultPollInterval = time.Second
+)
+
+// NewNATS JetStream模式, stream不存在时按cfg创建并捕获SubjectPrefix下的所有subject,
+// 路由键映射为subject, 消费时*匹配一级, #匹配剩余所有级
+func NewNATS(url string, cfg *nats.StreamConfig, subjectPrefix string, options ...nats.Option) (*NATS, error) {
+	nc, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if subjectPrefix == "" {
+		subjectPrefix = DefaultSubjectPrefix
+	}
+	if cfg == nil {
+		cfg = &nats.StreamConfig{}
+	}
+	if cfg.Name == "" {
+		cfg.Name = "QUEUE"
+	}
+	if len(cfg.Subjects) == 0 {
+		cfg.Subjects = []string{subjectPrefix + ">"}
+	}
+	if _, err = js.StreamInfo(cfg.Name); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(cfg)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &NATS{
+		conn:          nc,
+		js:            js,
+		stream:        cfg.Name,
+		subjectPrefix: subjectPrefix,
+		done:          make(chan struct{}),
+		AckWait:       DefaultAckWait,
+		PollInterval:  DefaultPollInterval,
+		Codec:         messageLib.DefaultCodec,
+	}, nil
+}
+
+// NATS at-least-once queue over JetStream durable pull consumers
+type NATS struct {
+	queueLib.MiddlewareChain
+	conn          *nats.Conn
+	js            nats.JetStreamContext
+	stream        string
+	subjectPrefix string
+	consumers     []*consumer
+	mux           sync.Mutex
+	workers       sync.WaitGroup
+	drain         queueLib.Drain
+	stop          sync.Once
+	done          chan struct{}
+	// AckWait and MaxDeliver are the defaults of the consumers not setting them
+	AckWait      time.Duration
+	MaxDeliver   int
+	PollInterval time.Duration
+	Codec        messageLib.Codec
+}
+
+// consumer is a durable pull consumer, f or batch handles its messages
+type consumer struct {
+	ctx     context.Context
+	name    string
+	durable string
+	subs    []*nats.Subscription
+	f       queueLib.ConsumerFunc
+	batch   queueLib.BatchConsumerFunc
+	options queueLib.ConsumeOptions
+}
+
+func (*NATS) String() string {
+	return "nats"
+}
+
+// subject maps a routing key or pattern to its subject, # becomes the JetStream > wildcard
+func (n *NATS) subject(routingKey string) string {
+	return n.subjectPrefix + strings.ReplaceAll(routingKey, "#", ">")
+}
+
+// durableName returns the consumer name of a routing pattern, durable names can not contain . * or >
+func durableName(name string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", "#", "_").Replace(name)
+}
+
+// Publish 消息写入JetStream, 收到stream确认后返回
+func (n *NATS) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, n.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(n.String(), message, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	msg, err := n.encode(message, options)
+	if err != nil {
+		return err
+	}
+	pubOpts := []nats.PubOpt{nats.MsgId(msgId(msg))}
+	// without a deadline the JetStream default timeout applies
+	if _, ok := ctx.Deadline(); ok {
+		pubOpts = append(pubOpts, nats.Context(ctx))
+	}
+	_, err = n.js.PublishMsg(msg, pubOpts...)
+	return err
+}
+
+// encode builds the nats message of message
+func (n *NATS) encode(message messageLib.IMessage, options queueLib.PublishOptions) (*nats.Msg, error) {
+	codec, err := options.GetCodec(n.Codec)
+	if err != nil {
+		return nil, err
+	}
+	body, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(n.subject(message.GetRoutingKey()))
+	msg.Data = body
+	for key, value := range message.GetHeaders() {
+		msg.Header.Set(key, value)
+	}
+	id := message.GetId()
+	if id == "" {
+		id = uuid.New().String()
+	}
+	msg.Header.Set(IdKey, id)
+	msg.Header.Set(messageLib.ContentTypeKey, codec.ContentType())
+	if count := message.GetErrorCount(); count > 0 {
+		msg.Header.Set(messageLib.ErrorCountKey, strconv.FormatUint(count, 10))
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		msg.Header.Set(DeliverAtKey, strconv.FormatInt(time.Now().Add(delay).UnixNano(), 10))
+	}
+	return msg, nil
+}
+
+// msgId is the JetStream deduplication id, per subject as a dead letter keeps the id of the failed message
+func msgId(msg *nats.Msg) string {
+	return msg.Subject + ":" + msg.Header.Get(IdKey)
+}
+
+// decode restores the message written by encode, the error count includes the redeliveries
+func (n *NATS) decode(msg *nats.Msg) (*message.Message, error) {
+	m := new(message.Message)
+	headers := map[string]string{}
+	for key := range msg.Header {
+		switch key {
+		case IdKey:
+			m.SetId(msg.Header.Get(key))
+		case messageLib.ContentTypeKey, messageLib.ErrorCountKey, DeliverAtKey:
+		default:
+			headers[key] = msg.Header.Get(key)
+		}
+	}
+	values, err := messageLib.GetCodecOrDefault(msg.Header.Get(messageLib.ContentTypeKey), n.Codec).Unmarshal(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	m.SetValues(values)
+	m.SetHeaders(headers)
+	m.SetRoutingKey(strings.TrimPrefix(msg.Subject, n.subjectPrefix))
+	errorCount := gconv.Uint64(msg.Header.Get(messageLib.ErrorCountKey))
+	if meta, err := msg.Metadata(); err == nil && meta.NumDelivered > 1 {
+		errorCount += meta.NumDelivered - 1 - delayNaks(msg, meta)
+	}
+	m.SetErrorCount(errorCount)
+	return m, nil
+}
+
+// delayNaks returns the deliveries of msg held back by due, a message stored before it was due
+// has been delivered early once and redelivered when due
+func delayNaks(msg *nats.Msg, meta *nats.MsgMetadata) uint64 {
+	deliverAt := msg.Header.Get(DeliverAtKey)
+	if deliverAt != "" && meta.Timestamp.Before(time.Unix(0, gconv.Int64(deliverAt))) {
+		return 1
+	}
+	return 0
+}
+
+// Consumer 注册durable拉取消费者, name为路由键或通配模式, Concurrency个协程共享同一个消费者
+func (n *NATS) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = n.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(n.String(), name, f)
+	f = queueLib.TraceConsumerFunc(n.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = n.drain.Track(f)
+	if err := n.register(&consumer{ctx: ctx, name: name, f: f, options: options}); err != nil {
+		glog.Errorf(ctx, "nats consumer %s subscribe error:%v", name, err)
+	}
+}
+
+// register subscribes the workers of c to its durable consumer and starts them
+func (n *NATS) register(c *consumer) error {
+	c.durable = c.options.ConsumerName
+	if c.durable == "" {
+		c.durable = durableName(c.name)
+	}
+	ackWait := c.options.AckWait
+	if ackWait <= 0 {
+		ackWait = n.AckWait
+	}
+	maxDeliver := c.options.MaxDeliver
+	if maxDeliver == 0 {
+		maxDeliver = n.MaxDeliver
+	}
+	if c.options.DeadLetter.Enabled() && c.options.DeadLetter.Queue == "" && strings.ContainsAny(c.name, "*#>") {
+		return fmt.Errorf("nats: consumer %s needs a dead-letter queue, a pattern is not a subject", c.name)
+	}
+	// the dead-letter queue needs every attempt to be delivered
+	if c.options.DeadLetter.Enabled() && maxDeliver > 0 && uint64(maxDeliver) < c.options.DeadLetter.MaxAttempts {
+		maxDeliver = int(c.options.DeadLetter.MaxAttempts)
+	}
+	if maxDeliver > 0 {
+		// the delivery held back by due until a delayed message is due
+		maxDeliver++
+	}
+	subOpts := []nats.SubOpt{
+		nats.BindStream(n.stream),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+		nats.AckWait(ackWait),
+	}
+	if maxDeliver != 0 {
+		subOpts = append(subOpts, nats.MaxDeliver(maxDeliver))
+	}
+	workers := c.options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		sub, err := n.js.PullSubscribe(n.subject(c.name), c.durable, subOpts...)
+		if err != nil {
+			return err
+		}
+		c.subs = append(c.subs, sub)
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.consumers = append(n.consumers, c)
+	for _, sub := range c.subs {
+		n.workers.Add(1)
+		go n.work(c, sub)
+	}
+	return nil
+}
+
+// work pulls the messages of sub until Shutdown
+func (n *NATS) work(c *consumer, sub *nats.Subscription) {
+	defer n.workers.Done()
+	size, timeout := 1, n.PollInterval
+	if c.batch != nil {
+		size, timeout = c.options.GetBatch()
+	}
+	for {
+		select {
+		case <-n.done:
+			return
+		default:
+		}
+		msgs, err := n.fetch(sub, size, timeout)
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) && !errors.Is(err, nats.ErrTimeout) {
+				glog.Warning(c.ctx, "nats fetch error:", err)
+				time.Sleep(n.PollInterval)
+			}
+			continue
+		}
+		msgs = n.due(c, msgs)
+		if c.batch != nil {
+			n.handleBatch(c, msgs)
+			continue
+		}
+		for _, msg := range msgs {
+			n.handle(c, msg)
+		}
+	}
+}
+
+// fetch pulls up to size messages, it returns what arrived within timeout or once Shutdown started
+func (n *NATS) fetch(sub *nats.Subscription, size int, timeout time.Duration) ([]*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-n.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return sub.Fetch(size, nats.Context(ctx))
+}
+
+// due holds back the delayed messages, they are redelivered once due
+func (n *NATS) due(c *consumer, msgs []*nats.Msg) []*nats.Msg {
+	ready := msgs[:0]
+	for _, msg := range msgs {
+		deliverAt := msg.Header.Get(DeliverAtKey)
+		if deliverAt == "" {
+			ready = append(ready, msg)
+			continue
+		}
+		wait := time.Until(time.Unix(0, gconv.Int64(deliverAt)))
+		if wait <= 0 {
+			ready = append(ready, msg)
+			continue
+		}
+		if err := msg.NakWithDelay(wait); err != nil {
+			glog.Warning(c.ctx, "nats delay msg error:", err)
+		}
+	}
+	return ready
+}
+
+func (n *NATS) handle(c *consumer, msg *nats.Msg) {
+	m, err := n.decode(msg)
+	if err != nil {
+		glog.Warning(c.ctx, "nats decode msg error:", err)
+		n.term(c, msg)
+		return
+	}
+	n.settle(c, msg, m, c.f(c.ctx, m))
+}
+
+// settle acks a handled message, a failed one is redelivered or moved to the dead-letter subject
+func (n *NATS) settle(c *consumer, msg *nats.Msg, m messageLib.IMessage, err error) {
+	if errors.Is(err, queueLib.ErrShuttingDown) {
+		// left unacked, redelivered after the ack wait
+		return
+	}
+	if err == nil {
+		n.ack(c, msg)
+		return
+	}
+	m.SetErrorIncr()
+	if !c.options.DeadLetter.Exhausted(m, err) {
+		if nakErr := msg.Nak(); nakErr != nil {
+			glog.Warning(c.ctx, "nats nak msg error:", nakErr)
+		}
+		return
+	}
+	c.options.DeadLetter.SetDeadLetter(c.name, m, err)
+	err = n.Publish(c.ctx, m, func(options *queueLib.PublishOptions) {
+		options.ContentType = msg.Header.Get(messageLib.ContentTypeKey)
+	})
+	if err != nil {
+		glog.Warning(c.ctx, "nats dead letter publish error:", err)
+		if nakErr := msg.Nak(); nakErr != nil {
+			glog.Warning(c.ctx, "nats nak msg error:", nakErr)
+		}
+		return
+	}
+	n.ack(c, msg)
+}
+
+func (n *NATS) ack(c *consumer, msg *nats.Msg) {
+	if err := msg.Ack(); err != nil {
+		glog.Warning(c.ctx, "nats ack msg error:", err)
+	}
+}
+
+// term stops the redelivery of a message that can not be decoded
+func (n *NATS) term(c *consumer, msg *nats.Msg) {
+	if err := msg.Term(); err != nil {
+		glog.Warning(c.ctx, "nats term msg error:", err)
+	}
+}
+
+// Run 消费者在注册时启动, 阻塞直到ctx结束或Shutdown
+func (n *NATS) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-n.done:
+	}
+}
+
+// Shutdown 停止拉取消息并等待处理中的消息直到ctx结束, 未确认的消息在AckWait后重新投递
+func (n *NATS) Shutdown(ctx context.Context) {
+	n.stop.Do(func() {
+		close(n.done)
+		if err := n.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "nats shutdown before handlers finished:", err)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			n.workers.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+		// the subscriptions are not unsubscribed, it would delete the durable consumers
+		n.conn.Close()
+	})
+}
@@ -0,0 +1,195 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// newTestNATS connects to an in-process JetStream server
+func newTestNATS(t *testing.T) *NATS {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	n, err := NewNATS(s.ClientURL(), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.PollInterval = 100 * time.Millisecond
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n.Shutdown(ctx)
+	})
+	return n
+}
+
+func TestNATS_Consumer(t *testing.T) {
+	n := newTestNATS(t)
+	ctx := context.Background()
+	received := make(chan messageLib.IMessage, 1)
+	n.Consumer(ctx, "orders.*", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg
+		return nil
+	})
+	go n.Run(ctx)
+	msg := &message.Message{
+		RoutingKey: "orders.created",
+		Values:     map[string]interface{}{"key": "value"},
+	}
+	msg.SetHeader("tenant", "a")
+	if err := n.Publish(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got.GetRoutingKey() != "orders.created" || got.GetValues()["key"] != "value" || got.GetHeader("tenant") != "a" {
+			t.Errorf("received %+v", got)
+		}
+		if got.GetId() == "" {
+			t.Error("received message without id")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+	if s := n.Health(ctx); !s.Connected || s.Consumers != 1 {
+		t.Errorf("Health() = %+v", s)
+	}
+}
+
+func TestNATS_Redelivery(t *testing.T) {
+	n := newTestNATS(t)
+	ctx := context.Background()
+	counts := make(chan uint64, 2)
+	n.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		counts <- msg.GetErrorCount()
+		if msg.GetErrorCount() == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	go n.Run(ctx)
+	if err := n.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(0); want < 2; want++ {
+		select {
+		case got := <-counts:
+			if got != want {
+				t.Errorf("GetErrorCount() = %d, want %d", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message was not redelivered")
+		}
+	}
+}
+
+func TestNATS_DeadLetter(t *testing.T) {
+	n := newTestNATS(t)
+	ctx := context.Background()
+	n.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		return errors.New("failed")
+	}, queueLib.WithDeadLetter("test.failed", 2), queueLib.WithNatsMaxDeliver(1))
+	dead := make(chan messageLib.IMessage, 1)
+	n.Consumer(ctx, "test.failed", func(ctx context.Context, msg messageLib.IMessage) error {
+		dead <- msg
+		return nil
+	})
+	go n.Run(ctx)
+	if err := n.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-dead:
+		if msg.GetValues()[messageLib.ErrorKey] != "failed" || msg.GetValues()["key"] != "value" {
+			t.Errorf("dead letter values = %v", msg.GetValues())
+		}
+		if msg.GetErrorCount() != 2 {
+			t.Errorf("GetErrorCount() = %d, want 2", msg.GetErrorCount())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message was not dead-lettered")
+	}
+}
+
+func TestNATS_Batch(t *testing.T) {
+	n := newTestNATS(t)
+	ctx := context.Background()
+	msgs := make([]messageLib.IMessage, 0, 5)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": i}})
+	}
+	if err := n.PublishBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan int, 1)
+	n.BatchConsumer(ctx, "test", func(ctx context.Context, msgs []messageLib.IMessage) []error {
+		received <- len(msgs)
+		return nil
+	}, queueLib.WithConsumeOptionsBatch(5, time.Second))
+	go n.Run(ctx)
+	select {
+	case got := <-received:
+		if got != 5 {
+			t.Errorf("batch size = %d, want 5", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("batch was not consumed")
+	}
+}
+
+func TestNATS_Delay(t *testing.T) {
+	n := newTestNATS(t)
+	ctx := context.Background()
+	received := make(chan messageLib.IMessage, 1)
+	n.Consumer(ctx, "test", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg
+		return nil
+	}, queueLib.WithNatsMaxDeliver(1))
+	go n.Run(ctx)
+	start := time.Now()
+	err := n.Publish(ctx, &message.Message{RoutingKey: "test", Values: map[string]interface{}{"key": "value"}},
+		queueLib.WithPublishOptionsDelay(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("delivered after %s, want 300ms", elapsed)
+		}
+		// the delivery held back is no failure
+		if msg.GetErrorCount() != 0 {
+			t.Errorf("GetErrorCount() = %d, want 0", msg.GetErrorCount())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("delayed message was not delivered")
+	}
+}
+
+func TestNATS_DeadLetterPattern(t *testing.T) {
+	n := newTestNATS(t)
+	options := queueLib.GetDefaultConsumeOptions()
+	queueLib.WithDeadLetter("", 2)(&options)
+	c := &consumer{ctx: context.Background(), name: "orders.*", options: options}
+	if err := n.register(c); err == nil {
+		t.Error("register() a pattern without dead-letter queue error = nil")
+	}
+}
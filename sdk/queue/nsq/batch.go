@@ -0,0 +1,57 @@
+package nsq
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 批量发送, 同一topic的消息合并为一次MPUB; 设置延时时逐条发送
+func (e *NSQ) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	if options.GetDelay() > 0 {
+		return queueLib.PublishEach(ctx, e, msgs, optionFuncs...)
+	}
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(e.String(), msgs[i], err)
+		}
+	}()
+	codec, err := options.GetCodec(e.Codec)
+	if err != nil {
+		return err
+	}
+	var topics []string
+	bodies := map[string][][]byte{}
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, e.String(), message)
+		spans = append(spans, span)
+		rb, err := encode(codec, message)
+		if err != nil {
+			return err
+		}
+		topic := message.GetRoutingKey()
+		if _, ok := bodies[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		bodies[topic] = append(bodies[topic], rb)
+	}
+	for _, topic := range topics {
+		if err = e.producer.MultiPublish(topic, bodies[topic]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchConsumer 批量消费, 由单条消费合并批次
+func (e *NSQ) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	queueLib.ConsumeBatches(ctx, e, name, f, optionFuncs...)
+}
@@ -0,0 +1,65 @@
+package nsq
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+)
+
+// envelopeMagic starts an enveloped body, nsq has no message headers so they are written in front of the payload:
+// magic(1) | len(envelope)(4, big endian) | envelope json | payload
+const envelopeMagic byte = 0
+
+var errInvalidEnvelope = errors.New("nsq: invalid message envelope")
+
+type envelope struct {
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// encode 使用codec编码, 并在消息体前写入content type和消息头
+func encode(codec messageLib.Codec, message messageLib.IMessage) ([]byte, error) {
+	payload, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return nil, err
+	}
+	head, err := json.Marshal(&envelope{
+		ContentType: codec.ContentType(),
+		Headers:     message.GetHeaders(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 5, 5+len(head)+len(payload))
+	body[0] = envelopeMagic
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(head)))
+	body = append(body, head...)
+	return append(body, payload...), nil
+}
+
+// decode 按信封中的content type解码, 没有信封的旧消息按def解码
+func decode(def messageLib.Codec, body []byte) (map[string]interface{}, map[string]string, error) {
+	if len(body) == 0 || body[0] != envelopeMagic {
+		values, err := messageLib.GetCodecOrDefault("", def).Unmarshal(body)
+		return values, nil, err
+	}
+	if len(body) < 5 {
+		return nil, nil, errInvalidEnvelope
+	}
+	end := 5 + int(binary.BigEndian.Uint32(body[1:5]))
+	if len(body) < end {
+		return nil, nil, errInvalidEnvelope
+	}
+	var e envelope
+	err := json.Unmarshal(body[5:end], &e)
+	if err != nil {
+		return nil, nil, err
+	}
+	codec, ok := messageLib.GetCodec(e.ContentType)
+	if !ok {
+		return nil, nil, errInvalidEnvelope
+	}
+	values, err := codec.Unmarshal(body[end:])
+	return values, e.Headers, err
+}
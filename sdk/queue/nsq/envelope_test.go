@@ -0,0 +1,32 @@
+package nsq
+
+import (
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"reflect"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	msg := &message.Message{
+		Values:  map[string]interface{}{"key": "value"},
+		Headers: map[string]string{messageLib.HeaderTenant: "t1"},
+	}
+	codec, _ := messageLib.GetCodec("msgpack+gzip")
+	body, err := encode(codec, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, headers, err := decode(messageLib.DefaultCodec, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, msg.Values) || !reflect.DeepEqual(headers, msg.Headers) {
+		t.Errorf("decode() = %v, %v", values, headers)
+	}
+	// messages published before the envelope are plain json
+	values, headers, err = decode(messageLib.DefaultCodec, []byte(`{"key":"value"}`))
+	if err != nil || headers != nil || !reflect.DeepEqual(values, msg.Values) {
+		t.Errorf("decode() legacy = %v, %v, %v", values, headers, err)
+	}
+}
@@ -0,0 +1,28 @@
+package nsq
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// Health 检查与nsqd的连接, Consumers为所有消费者的nsqd连接数, 堆积需要查询nsqd的/stats
+func (e *NSQ) Health(ctx context.Context) health.Status {
+	if e.drain.Closed() {
+		return health.Down(e.String(), queueLib.ErrShuttingDown)
+	}
+	if err := e.producer.Ping(); err != nil {
+		return health.Down(e.String(), err)
+	}
+	s := health.Up(e.String())
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	for _, consumer := range e.consumers {
+		s.Consumers += consumer.Stats().Connections
+	}
+	if len(e.consumers) > 0 && s.Consumers == 0 {
+		s = health.Down(e.String(), errors.New("nsq consumer is not connected"))
+	}
+	return s
+}
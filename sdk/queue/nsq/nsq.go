@@ -2,18 +2,31 @@ package nsq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/sdk/v2/message"
-	json "github.com/json-iterator/go"
+	"github.com/gogf/gf/v2/os/glog"
+	"sync"
+	"time"
 )
 
-// NewNSQ nsq模式 只能监听一个channel
+// MaxDeferDelay is the default --max-req-timeout of nsqd, a longer deferred publish is rejected
+const MaxDeferDelay = time.Hour
+
+// NewNSQ nsq模式, 每个topic/channel一个消费者;
+// 设置LookupdAddresses时消费者通过nsqlookupd发现nsqd, 否则直连addresses
 func NewNSQ(addresses []string, cfg *nsq.Config, channelPrefix string) (*NSQ, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("nsq addresses are empty")
+	}
 	n := &NSQ{
 		addresses:     addresses,
 		cfg:           cfg,
 		channelPrefix: channelPrefix,
+		consumers:     map[string]*nsq.Consumer{},
+		Codec:         messageLib.DefaultCodec,
 	}
 	var err error
 	n.producer, err = n.newProducer()
@@ -21,18 +34,30 @@ func NewNSQ(addresses []string, cfg *nsq.Config, channelPrefix string) (*NSQ, er
 }
 
 type NSQ struct {
+	queueLib.MiddlewareChain
 	addresses     []string
 	cfg           *nsq.Config
 	producer      *nsq.Producer
-	consumer      *nsq.Consumer
+	consumers     map[string]*nsq.Consumer
+	mux           sync.Mutex
+	drain         queueLib.Drain
+	stop          sync.Once
 	channelPrefix string
+	// LookupdAddresses of the nsqlookupd http endpoints the consumers discover the nsqd of their topic from
+	LookupdAddresses []string
+	Codec            messageLib.Codec
 }
 
 // String 字符串类型
-func (NSQ) String() string {
+func (*NSQ) String() string {
 	return "nsq"
 }
 
+// MaxDelay 延时发布的最长时间, 即nsqd默认的--max-req-timeout
+func (*NSQ) MaxDelay() time.Duration {
+	return MaxDeferDelay
+}
+
 // switchAddress ⚠️生产环境至少配置三个节点
 func (e *NSQ) switchAddress() {
 	if len(e.addresses) > 1 {
@@ -49,64 +74,155 @@ func (e *NSQ) newProducer() (*nsq.Producer, error) {
 	return nsq.NewProducer(e.addresses[0], e.cfg)
 }
 
-func (e *NSQ) newConsumer(topic string, h nsq.Handler) (err error) {
+// newConsumer connects a consumer of topic/channel, handlers can not be added once it is connected
+// so a topic/channel can only be consumed once
+func (e *NSQ) newConsumer(topic, channel string, h nsq.Handler, concurrency int) error {
 	if e.cfg == nil {
 		e.cfg = nsq.NewConfig()
 	}
-	if e.consumer == nil {
-		e.consumer, err = nsq.NewConsumer(topic, e.channelPrefix+topic, e.cfg)
-		if err != nil {
-			return err
+	key := topic + "/" + channel
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if _, ok := e.consumers[key]; ok {
+		return fmt.Errorf("nsq consumer of %s is already registered", key)
+	}
+	consumer, err := nsq.NewConsumer(topic, channel, e.cfg)
+	if err != nil {
+		return err
+	}
+	if concurrency > 1 {
+		// every handler goroutine needs a message in flight
+		if e.cfg.MaxInFlight < concurrency {
+			consumer.ChangeMaxInFlight(concurrency)
 		}
+		consumer.AddConcurrentHandlers(h, concurrency)
+	} else {
+		consumer.AddHandler(h)
 	}
-	e.consumer.AddHandler(h)
-	err = e.consumer.ConnectToNSQDs(e.addresses)
-
-	return err
+	if len(e.LookupdAddresses) > 0 {
+		err = consumer.ConnectToNSQLookupds(e.LookupdAddresses)
+	} else {
+		err = consumer.ConnectToNSQDs(e.addresses)
+	}
+	if err != nil {
+		consumer.Stop()
+		return err
+	}
+	e.consumers[key] = consumer
+	return nil
 }
 
 // Publish 消息入生产者
-func (e *NSQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
-	rb, err := json.Marshal(message.GetValues())
+func (e *NSQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, e.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(e.String(), message, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	codec, err := options.GetCodec(e.Codec)
+	if err != nil {
+		return err
+	}
+	rb, err := encode(codec, message)
 	if err != nil {
 		return err
 	}
+	if delay := options.GetDelay(); delay > 0 {
+		return e.producer.DeferredPublish(message.GetRoutingKey(), delay, rb)
+	}
 	return e.producer.Publish(message.GetRoutingKey(), rb)
 }
 
-// Consumer 监听消费者
+// Consumer 监听topic, channel默认为channelPrefix+topic, 注册失败时记录错误
 func (e *NSQ) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
-	h := &nsqConsumerHandler{f}
-	err := e.newConsumer(name, h)
-	if err != nil {
-		//目前不支持动态注册
-		panic(err)
+	if err := e.Subscribe(ctx, name, f, optionFuncs...); err != nil {
+		glog.Errorf(ctx, "nsq consumer %s subscribe error:%v", name, err)
 	}
 }
 
+// Subscribe 与Consumer相同, 返回注册或连接nsqd的错误
+func (e *NSQ) Subscribe(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) error {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	channel := options.Channel
+	if channel == "" {
+		channel = e.channelPrefix + name
+	}
+	f = e.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(e.String(), name, f)
+	f = queueLib.TraceConsumerFunc(e.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = e.drain.Track(f)
+	h := &nsqConsumerHandler{ctx: ctx, f: f, queue: e, topic: name, deadLetter: options.DeadLetter}
+	return e.newConsumer(name, channel, h, options.Concurrency)
+}
+
 func (e *NSQ) Run(ctx context.Context) {
 }
 
+// Shutdown 停止接收新消息并等待处理中的消息直到ctx结束, 之后断开连接
 func (e *NSQ) Shutdown(ctx context.Context) {
-	if e.producer != nil {
-		e.producer.Stop()
-	}
-	if e.consumer != nil {
-		e.consumer.Stop()
-	}
+	e.stop.Do(func() {
+		if err := e.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "nsq shutdown before handlers finished:", err)
+		}
+		e.mux.Lock()
+		defer e.mux.Unlock()
+		for _, consumer := range e.consumers {
+			consumer.Stop()
+		}
+		for _, consumer := range e.consumers {
+			select {
+			case <-consumer.StopChan:
+			case <-ctx.Done():
+			}
+		}
+		if e.producer != nil {
+			e.producer.Stop()
+		}
+	})
 }
 
 type nsqConsumerHandler struct {
-	f queueLib.ConsumerFunc
+	ctx        context.Context
+	f          queueLib.ConsumerFunc
+	queue      *NSQ
+	topic      string
+	deadLetter *queueLib.DeadLetterOptions
 }
 
 func (e nsqConsumerHandler) HandleMessage(msg *nsq.Message) error {
 	m := new(message.Message)
-	data := make(map[string]interface{})
-	err := json.Unmarshal(msg.Body, &data)
+	data, headers, err := decode(e.queue.Codec, msg.Body)
 	if err != nil {
 		return err
 	}
 	m.SetValues(data)
-	return e.f(context.Background(), m)
+	m.SetHeaders(headers)
+	m.SetRoutingKey(e.topic)
+	m.SetId(string(msg.ID[:]))
+	m.SetErrorCount(uint64(msg.Attempts - 1))
+	ctx := e.ctx
+	err = e.f(ctx, m)
+	if errors.Is(err, queueLib.ErrShuttingDown) {
+		// hand the message back to nsqd without backing off the consumer
+		msg.RequeueWithoutBackoff(0)
+		return nil
+	}
+	if err == nil || !e.deadLetter.Enabled() {
+		return err
+	}
+	m.SetErrorIncr()
+	if !e.deadLetter.Exhausted(m, err) {
+		return err
+	}
+	// finish the message once it has been published to the dead-letter topic
+	e.deadLetter.SetDeadLetter(e.topic, m, err)
+	return e.queue.Publish(ctx, m)
 }
@@ -0,0 +1,32 @@
+package nsq
+
+import (
+	"context"
+	"testing"
+
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+)
+
+func TestNSQ_Subscribe(t *testing.T) {
+	// nothing listens on the address, connecting the consumer fails
+	e, err := NewNSQ([]string{"127.0.0.1:1"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(context.Background())
+	ctx := context.Background()
+	f := func(ctx context.Context, msg messageLib.IMessage) error {
+		return nil
+	}
+	if err = e.Subscribe(ctx, "invalid topic", f); err == nil {
+		t.Error("Subscribe() with an invalid topic returned no error")
+	}
+	if err = e.Subscribe(ctx, "test", f); err == nil {
+		t.Error("Subscribe() without nsqd returned no error")
+	}
+	// logged instead of panicking
+	e.Consumer(ctx, "test", f)
+	if n := len(e.consumers); n != 0 {
+		t.Errorf("registered consumers = %d, want 0", n)
+	}
+}
@@ -0,0 +1,283 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/os/gtime"
+	"sync"
+	"time"
+)
+
+const (
+	SrvName      = "OutboxRelay"
+	DefaultTable = "queue_outbox"
+)
+
+const (
+	StatusPending = iota // waiting to be published
+	StatusSent           // published to the queue
+	StatusFailed         // gave up after MaxAttempts
+)
+
+// Schema 发件箱表结构(mysql), 其他数据库按字段自行建表
+const Schema = `CREATE TABLE IF NOT EXISTS %s (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  queue VARCHAR(64) NOT NULL DEFAULT '',
+  message_id VARCHAR(64) NOT NULL DEFAULT '',
+  routing_key VARCHAR(255) NOT NULL DEFAULT '',
+  payload TEXT,
+  headers TEXT,
+  status TINYINT NOT NULL DEFAULT 0,
+  attempts INT NOT NULL DEFAULT 0,
+  last_error VARCHAR(1024) NOT NULL DEFAULT '',
+  next_at DATETIME NULL,
+  sent_at DATETIME NULL,
+  created_at DATETIME NULL,
+  PRIMARY KEY (id),
+  KEY idx_status_next_at (status, next_at)
+)`
+
+// Entity 发件箱记录
+type Entity struct {
+	Id         int64       `orm:"id"`
+	Queue      string      `orm:"queue"`
+	MessageId  string      `orm:"message_id"`
+	RoutingKey string      `orm:"routing_key"`
+	Payload    string      `orm:"payload"`
+	Headers    string      `orm:"headers"`
+	Status     int         `orm:"status"`
+	Attempts   int         `orm:"attempts"`
+	LastError  string      `orm:"last_error"`
+	NextAt     *gtime.Time `orm:"next_at"`
+	SentAt     *gtime.Time `orm:"sent_at"`
+	CreatedAt  *gtime.Time `orm:"created_at"`
+}
+
+var insOutbox = tOutbox{
+	Table:          DefaultTable,
+	Interval:       time.Second,
+	BatchSize:      100,
+	MaxAttempts:    10,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+}
+
+type tOutbox struct {
+	// DB 发件箱所在数据库, 为空时使用 g.DB()
+	DB             gdb.DB
+	Table          string
+	Interval       time.Duration
+	BatchSize      int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	mux            sync.Mutex
+	cancel         context.CancelFunc
+	stopped        chan struct{}
+}
+
+var _ task.IService = (*tOutbox)(nil)
+
+func Service() *tOutbox {
+	return &insOutbox
+}
+
+func (t *tOutbox) String() string {
+	return SrvName
+}
+
+func (t *tOutbox) db() gdb.DB {
+	if t.DB == nil {
+		t.DB = g.DB()
+	}
+	return t.DB
+}
+
+// Save 在调用方事务中写入发件箱, 事务提交后由中继发布到已注册的队列queueName
+func (t *tOutbox) Save(ctx context.Context, tx gdb.TX, queueName string, msg messageLib.IMessage) error {
+	payload, err := json.Marshal(msg.GetValues())
+	if err != nil {
+		return err
+	}
+	headers, err := json.Marshal(msg.GetHeaders())
+	if err != nil {
+		return err
+	}
+	now := gtime.Now()
+	_, err = tx.Model(t.Table).Ctx(ctx).Data(g.Map{
+		"queue":       queueName,
+		"message_id":  msg.GetId(),
+		"routing_key": msg.GetRoutingKey(),
+		"payload":     string(payload),
+		"headers":     string(headers),
+		"status":      StatusPending,
+		"attempts":    0,
+		"next_at":     now,
+		"created_at":  now,
+	}).Insert()
+	return err
+}
+
+func (t *tOutbox) Start(ctx context.Context) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.cancel != nil {
+		return
+	}
+	glog.Info(ctx, "Outbox relay start ...")
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.stopped = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := t.Relay(ctx); err != nil {
+					glog.Warning(ctx, "Outbox relay error:", err)
+				}
+			}
+		}
+	}(t.stopped)
+}
+
+// Stop 停止中继并等待进行中的Relay完成, ctx结束时不再等待
+func (t *tOutbox) Stop(ctx context.Context) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.cancel == nil {
+		return
+	}
+	glog.Info(ctx, "Outbox relay stop ...")
+	t.cancel()
+	t.cancel = nil
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// Relay 发布一批到期的发件箱记录, 返回发布成功的数量
+func (t *tOutbox) Relay(ctx context.Context) (int, error) {
+	var rows []*Entity
+	err := t.db().Model(t.Table).Ctx(ctx).
+		Where("status", StatusPending).
+		WhereLTE("next_at", gtime.Now()).
+		OrderAsc("id").
+		Limit(t.BatchSize).
+		Scan(&rows)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, row := range rows {
+		claimed, err := t.claim(ctx, row)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+		err = t.publish(ctx, row)
+		if err != nil {
+			glog.Warning(ctx, fmt.Sprintf("Outbox publish %d error: %v", row.Id, err))
+			err = t.fail(ctx, row, err)
+		} else {
+			sent++
+			err = t.done(ctx, row)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// claim 乐观锁占用记录并预先推迟下次发布时间, 多个中继实例不会重复发布同一条记录
+func (t *tOutbox) claim(ctx context.Context, row *Entity) (bool, error) {
+	row.Attempts++
+	result, err := t.db().Model(t.Table).Ctx(ctx).
+		Data(g.Map{
+			"attempts": row.Attempts,
+			"next_at":  gtime.Now().Add(t.backoff(row.Attempts)),
+		}).
+		Where("id", row.Id).
+		Where("status", StatusPending).
+		Where("attempts", row.Attempts-1).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (t *tOutbox) publish(ctx context.Context, row *Entity) error {
+	q := sdk.Runtime.QueueRegistry().Get(row.Queue)
+	if q == nil {
+		return gerror.Newf("queue %s is not registered", row.Queue)
+	}
+	values := make(map[string]interface{})
+	err := json.Unmarshal([]byte(row.Payload), &values)
+	if err != nil {
+		return err
+	}
+	var headers map[string]string
+	if row.Headers != "" {
+		if err = json.Unmarshal([]byte(row.Headers), &headers); err != nil {
+			return err
+		}
+	}
+	return q.Publish(ctx, &message.Message{
+		Id:         row.MessageId,
+		RoutingKey: row.RoutingKey,
+		Values:     values,
+		Headers:    headers,
+	})
+}
+
+func (t *tOutbox) done(ctx context.Context, row *Entity) error {
+	_, err := t.db().Model(t.Table).Ctx(ctx).
+		Data(g.Map{
+			"status":  StatusSent,
+			"sent_at": gtime.Now(),
+		}).
+		Where("id", row.Id).
+		Update()
+	return err
+}
+
+func (t *tOutbox) fail(ctx context.Context, row *Entity, cause error) error {
+	data := g.Map{
+		"last_error": cause.Error(),
+	}
+	if t.MaxAttempts > 0 && row.Attempts >= t.MaxAttempts {
+		data["status"] = StatusFailed
+	}
+	_, err := t.db().Model(t.Table).Ctx(ctx).Data(data).Where("id", row.Id).Update()
+	return err
+}
+
+// backoff 第attempts次发布失败后的等待时间, 指数增长且不超过MaxBackoff
+func (t *tOutbox) backoff(attempts int) time.Duration {
+	delay := t.InitialBackoff
+	for i := 1; i < attempts && delay < t.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxBackoff {
+		delay = t.MaxBackoff
+	}
+	return delay
+}
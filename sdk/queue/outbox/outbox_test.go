@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/168yy/plus-core/sdk/v2/queue/memory"
+	_ "github.com/gogf/gf/contrib/drivers/sqlite/v2"
+	"github.com/gogf/gf/v2/database/gdb"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const sqliteSchema = `CREATE TABLE %s (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  queue VARCHAR(64) NOT NULL DEFAULT '',
+  message_id VARCHAR(64) NOT NULL DEFAULT '',
+  routing_key VARCHAR(255) NOT NULL DEFAULT '',
+  payload TEXT,
+  headers TEXT,
+  status INTEGER NOT NULL DEFAULT 0,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error VARCHAR(1024) NOT NULL DEFAULT '',
+  next_at DATETIME NULL,
+  sent_at DATETIME NULL,
+  created_at DATETIME NULL
+)`
+
+func newOutbox(t *testing.T) *tOutbox {
+	db, err := gdb.New(gdb.ConfigNode{
+		Type: "sqlite",
+		Link: "sqlite::@file(" + filepath.Join(t.TempDir(), "outbox.db") + ")",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(context.Background(), fmt.Sprintf(sqliteSchema, DefaultTable)); err != nil {
+		t.Fatal(err)
+	}
+	return &tOutbox{
+		DB:             db,
+		Table:          DefaultTable,
+		Interval:       10 * time.Millisecond,
+		BatchSize:      10,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	ctx := context.Background()
+	o := newOutbox(t)
+	q := memory.NewMemory(100)
+	_ = sdk.Runtime.QueueRegistry().Register("outbox_test", q)
+	defer sdk.Runtime.QueueRegistry().Unregister("outbox_test")
+
+	received := make(chan messageLib.IMessage, 1)
+	q.Consumer(ctx, "orders", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg
+		return nil
+	})
+
+	// 事务回滚时不应发布
+	_ = o.DB.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		err := o.Save(ctx, tx, "outbox_test", &message.Message{RoutingKey: "orders", Values: map[string]interface{}{"id": 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return errors.New("rollback")
+	})
+	err := o.DB.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		return o.Save(ctx, tx, "outbox_test", &message.Message{
+			Id:         "m2",
+			RoutingKey: "orders",
+			Values:     map[string]interface{}{"id": 2},
+			Headers:    map[string]string{messageLib.HeaderTenant: "t1"},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := o.Relay(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("Relay() sent = %d, err = %v, want 1", sent, err)
+	}
+	select {
+	case msg := <-received:
+		if fmt.Sprint(msg.GetValues()["id"]) != "2" || msg.GetHeader(messageLib.HeaderTenant) != "t1" {
+			t.Errorf("received %v %v", msg.GetValues(), msg.GetHeaders())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not relayed")
+	}
+
+	sent, err = o.Relay(ctx)
+	if err != nil || sent != 0 {
+		t.Errorf("second Relay() sent = %d, err = %v, want 0", sent, err)
+	}
+	var row Entity
+	if err = o.DB.Model(DefaultTable).Where("message_id", "m2").Scan(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row.Status != StatusSent || row.Attempts != 1 {
+		t.Errorf("row status = %d, attempts = %d", row.Status, row.Attempts)
+	}
+}
+
+func TestOutbox_RelayFailed(t *testing.T) {
+	ctx := context.Background()
+	o := newOutbox(t)
+	err := o.DB.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		return o.Save(ctx, tx, "outbox_missing", &message.Message{Id: "m1", RoutingKey: "orders"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = o.Relay(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	var row Entity
+	if err = o.DB.Model(DefaultTable).Where("message_id", "m1").Scan(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row.Status != StatusFailed || row.Attempts != 2 || row.LastError == "" {
+		t.Errorf("row status = %d, attempts = %d, error = %q", row.Status, row.Attempts, row.LastError)
+	}
+}
+
+func TestOutbox_Stop(t *testing.T) {
+	ctx := context.Background()
+	o := newOutbox(t)
+	q := memory.NewMemory(100)
+	_ = sdk.Runtime.QueueRegistry().Register("outbox_stop", q)
+	defer sdk.Runtime.QueueRegistry().Unregister("outbox_stop")
+	received := make(chan messageLib.IMessage, 1)
+	q.Consumer(ctx, "orders", func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- msg
+		return nil
+	})
+
+	o.Start(ctx)
+	err := o.DB.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		return o.Save(ctx, tx, "outbox_stop", &message.Message{Id: "m1", RoutingKey: "orders"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message not relayed")
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	o.Stop(stopCtx)
+	if stopCtx.Err() != nil {
+		t.Fatal("Stop() did not return before the deadline")
+	}
+	select {
+	case <-o.stopped:
+	default:
+		t.Fatal("relay still running after Stop()")
+	}
+}
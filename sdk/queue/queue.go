@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"github.com/168yy/plus-core/core/v2/health"
 	"github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 )
@@ -28,6 +29,18 @@ func (e *Queue) Consumer(ctx context.Context, name string, f queueLib.ConsumerFu
 	e.queue.Consumer(ctx, name, f, optionFuncs...)
 }
 
+// Use 注册队列级中间件
+func (e *Queue) Use(middlewares ...queueLib.Middleware) {
+	if m, ok := e.queue.(queueLib.IMiddleware); ok {
+		m.Use(middlewares...)
+	}
+}
+
+// Health 检查队列状态
+func (e *Queue) Health(ctx context.Context) health.Status {
+	return health.Check(ctx, e.String(), e.queue)
+}
+
 // Publish 数据生产者
 func (e *Queue) Publish(ctx context.Context, msg message.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
 	return e.queue.Publish(ctx, msg, optionFuncs...)
@@ -44,3 +57,37 @@ func (e *Queue) Shutdown(ctx context.Context) {
 		e.queue.Shutdown(ctx)
 	}
 }
+
+// PublishBatch 批量生产, 队列不支持时逐条发送
+func (e *Queue) PublishBatch(ctx context.Context, msgs []message.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
+	return queueLib.PublishBatch(ctx, e.queue, msgs, optionFuncs...)
+}
+
+// BatchConsumer 注册批量消费者, 队列不支持时由单条消费合并批次
+func (e *Queue) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	queueLib.BatchConsumer(ctx, e.queue, name, f, optionFuncs...)
+}
+
+// DeadLetters 死信队列的消息数, 队列不支持时返回queueLib.ErrNotSupported
+func (e *Queue) DeadLetters(ctx context.Context) (map[string]int64, error) {
+	if d, ok := e.queue.(queueLib.IDeadLetterAdmin); ok {
+		return d.DeadLetters(ctx)
+	}
+	return nil, queueLib.ErrNotSupported
+}
+
+// RequeueDeadLetters 将死信重新发布到原队列, 队列不支持时返回queueLib.ErrNotSupported
+func (e *Queue) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	if d, ok := e.queue.(queueLib.IDeadLetterAdmin); ok {
+		return d.RequeueDeadLetters(ctx, name, limit)
+	}
+	return 0, queueLib.ErrNotSupported
+}
+
+// PurgeDeadLetters 清空死信队列, 队列不支持时返回queueLib.ErrNotSupported
+func (e *Queue) PurgeDeadLetters(ctx context.Context, name string) (int, error) {
+	if d, ok := e.queue.(queueLib.IDeadLetterAdmin); ok {
+		return d.PurgeDeadLetters(ctx, name)
+	}
+	return 0, queueLib.ErrNotSupported
+}
@@ -5,8 +5,19 @@ import (
 	"fmt"
 	"github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/core/v2/task"
+	sdkMessage "github.com/168yy/plus-core/sdk/v2/message"
 	"github.com/168yy/plus-core/sdk/v2/queue/memory"
+	"github.com/168yy/plus-core/sdk/v2/queue/nsq"
+	"github.com/168yy/plus-core/sdk/v2/queue/rabbitmq"
 	redis2 "github.com/168yy/plus-core/sdk/v2/queue/redis"
+	"github.com/168yy/plus-core/sdk/v2/queue/rocketmq"
+	"github.com/gogf/gf/v2/os/glog"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -103,3 +114,272 @@ func TestQueue_Consumer(t *testing.T) {
 		})
 	}
 }
+
+// testBackend describes how the shared suites consume and publish on a backend,
+// brokers are only tested when their address is given by the environment
+type testBackend struct {
+	name    string
+	env     string
+	queue   func(ctx context.Context, addr string) (queueLib.IQueue, error)
+	consume []func(*queueLib.ConsumeOptions)
+	publish []func(*queueLib.PublishOptions)
+}
+
+// testBackends returns every backend, messages are published to routingKey on exchange when the broker needs one
+func testBackends(exchange, routingKey string) []testBackend {
+	return []testBackend{
+		{
+			name: "memory",
+			queue: func(ctx context.Context, addr string) (queueLib.IQueue, error) {
+				return memory.NewMemory(100), nil
+			},
+		},
+		{
+			name: "redis",
+			env:  "QUEUE_TEST_REDIS_ADDR",
+			queue: func(ctx context.Context, addr string) (queueLib.IQueue, error) {
+				client := redis.NewClient(&redis.Options{Addr: addr})
+				return redis2.NewRedis(&redisqueue.ProducerOptions{
+					StreamMaxLength:      100,
+					ApproximateMaxLength: true,
+					RedisClient:          client,
+				}, &redisqueue.ConsumerOptions{
+					VisibilityTimeout: 60 * time.Second,
+					BlockingTimeout:   1 * time.Second,
+					ReclaimInterval:   1 * time.Second,
+					BufferSize:        100,
+					Concurrency:       1,
+					RedisClient:       client,
+				})
+			},
+		},
+		{
+			name: "rabbitmq",
+			env:  "QUEUE_TEST_RABBITMQ_DSN",
+			queue: func(ctx context.Context, addr string) (queueLib.IQueue, error) {
+				return rabbitmq.NewRabbitMQ(ctx, addr, 1, nil, glog.New())
+			},
+			consume: []func(*queueLib.ConsumeOptions){
+				queueLib.WithRabbitMqConsumeOptionsBindingExchangeName(exchange),
+				queueLib.WithRabbitMqConsumeOptionsBindingRoutingKeys([]string{routingKey}),
+			},
+			publish: []func(*queueLib.PublishOptions){
+				queueLib.WithRabbitMqPublishOptionsExchange(exchange),
+			},
+		},
+		{
+			name: "rocketmq",
+			env:  "QUEUE_TEST_ROCKETMQ_ADDR",
+			queue: func(ctx context.Context, addr string) (queueLib.IQueue, error) {
+				return rocketmq.NewRocketMQ(ctx, []string{addr}, nil, glog.New())
+			},
+			consume: []func(*queueLib.ConsumeOptions){
+				queueLib.WithRocketMqGroupName(routingKey),
+			},
+		},
+		{
+			name: "nsq",
+			env:  "QUEUE_TEST_NSQD_ADDR",
+			queue: func(ctx context.Context, addr string) (queueLib.IQueue, error) {
+				return nsq.NewNSQ([]string{addr}, nil, "")
+			},
+		},
+	}
+}
+
+// runBackends runs suite on every available backend
+func runBackends(t *testing.T, exchange, routingKey string, suite func(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions))) {
+	for _, backend := range testBackends(exchange, routingKey) {
+		t.Run(backend.name, func(t *testing.T) {
+			addr := ""
+			if backend.env != "" {
+				if addr = os.Getenv(backend.env); addr == "" {
+					t.Skipf("%s is not set", backend.env)
+				}
+			}
+			ctx := context.Background()
+			q, err := backend.queue(ctx, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Shutdown(ctx)
+			suite(t, q, backend.consume, backend.publish)
+		})
+	}
+}
+
+func TestQueue_PublishDelay(t *testing.T) {
+	runBackends(t, "plus.test.delay", "plus_test_delay", testPublishDelay)
+}
+
+// testPublishDelay proves that a delayed message is delivered no earlier than requested
+func testPublishDelay(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions)) {
+	const (
+		routingKey = "plus_test_delay"
+		delay      = 2 * time.Second
+	)
+	ctx := context.Background()
+	received := make(chan time.Time, 1)
+	q.Consumer(ctx, routingKey, func(ctx context.Context, msg message.IMessage) error {
+		select {
+		case received <- time.Now():
+		default:
+		}
+		return nil
+	}, consume...)
+	go q.Run(ctx)
+
+	publishedAt := time.Now()
+	err := q.Publish(ctx, &sdkMessage.Message{
+		RoutingKey: routingKey,
+		Values: map[string]interface{}{
+			"key": "value",
+		},
+	}, append(publish, queueLib.WithPublishOptionsDelay(delay))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case at := <-received:
+		if elapsed := at.Sub(publishedAt); elapsed < delay {
+			t.Errorf("delivered after %v, want no earlier than %v", elapsed, delay)
+		}
+	case <-time.After(delay + 10*time.Second):
+		t.Error("delayed message was not delivered")
+	}
+}
+
+func TestQueue_Trace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	runBackends(t, "plus.test.trace", "plus_test_trace", func(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions)) {
+		exporter.Reset()
+		testTrace(t, q, consume, publish)
+		spans := exporter.GetSpans()
+		byName := make(map[string]tracetest.SpanStub, len(spans))
+		for _, span := range spans {
+			byName[span.Name] = span
+		}
+		parent, send := byName["publish"], byName["plus_test_trace send"]
+		process, task := byName["plus_test_trace process"], byName["task plus_test_trace"]
+		if !process.SpanContext.IsValid() || !task.SpanContext.IsValid() {
+			t.Fatalf("missing spans: %v", byName)
+		}
+		if send.SpanKind != trace.SpanKindProducer || process.SpanKind != trace.SpanKindConsumer {
+			t.Errorf("span kinds = %v, %v", send.SpanKind, process.SpanKind)
+		}
+		traceId := parent.SpanContext.TraceID()
+		for _, span := range []tracetest.SpanStub{send, process, task} {
+			if span.SpanContext.TraceID() != traceId {
+				t.Errorf("span %s trace = %s, want %s", span.Name, span.SpanContext.TraceID(), traceId)
+			}
+		}
+		if process.Parent.SpanID() != send.SpanContext.SpanID() || task.Parent.SpanID() != process.SpanContext.SpanID() {
+			t.Errorf("unexpected span parents: %v", byName)
+		}
+	})
+}
+
+type traceTask struct {
+	done chan struct{}
+}
+
+func (traceTask) RoutingKey() string {
+	return "plus_test_trace"
+}
+
+func (h traceTask) Handle(ctx context.Context, msg message.IMessage) (interface{}, error) {
+	close(h.done)
+	return nil, nil
+}
+
+// testTrace publishes inside a span and consumes with task.WrapHandler
+func testTrace(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions)) {
+	h := traceTask{done: make(chan struct{})}
+	ctx := context.Background()
+	q.Consumer(ctx, h.RoutingKey(), task.WrapHandler(h), consume...)
+	go q.Run(ctx)
+
+	ctx, span := otel.Tracer("test").Start(ctx, "publish")
+	err := q.Publish(ctx, &sdkMessage.Message{
+		RoutingKey: h.RoutingKey(),
+		Values: map[string]interface{}{
+			"key": "value",
+		},
+	}, publish...)
+	span.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-h.done:
+		// the consumer span ends once the handler returned
+		time.Sleep(100 * time.Millisecond)
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+}
+
+func TestQueue_Priority(t *testing.T) {
+	runBackends(t, "plus.test.priority", "plus_test_priority", func(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions)) {
+		if name := q.String(); name != "memory" && name != "redis" {
+			// rabbitmq orders by priority only the messages not prefetched yet
+			t.Skipf("%s does not drain priorities by weight", name)
+		}
+		testPriority(t, q, consume, publish)
+	})
+}
+
+// testPriority blocks the consumer while messages of both priorities are queued,
+// the high priority ones must come first once it is released
+func testPriority(t *testing.T, q queueLib.IQueue, consume []func(*queueLib.ConsumeOptions), publish []func(*queueLib.PublishOptions)) {
+	const routingKey = "plus_test_priority"
+	ctx := context.Background()
+	gate := make(chan struct{})
+	received := make(chan interface{}, 21)
+	q.Consumer(ctx, routingKey, func(ctx context.Context, msg message.IMessage) error {
+		received <- msg.GetValues()["key"]
+		<-gate
+		return nil
+	}, append(consume, queueLib.WithConsumeOptionsMaxPriority(queueLib.MaxPriority))...)
+	go q.Run(ctx)
+	send := func(key string, priority uint8) {
+		err := q.Publish(ctx, &sdkMessage.Message{
+			RoutingKey: routingKey,
+			Values:     map[string]interface{}{"key": key},
+		}, append(publish, queueLib.WithPublishOptionsPriority(priority))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// consumers may declare their priorities once running
+	time.Sleep(500 * time.Millisecond)
+	send("warmup", 0)
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not consumed")
+	}
+	for i := 0; i < 10; i++ {
+		send("low", 0)
+		send("high", queueLib.MaxPriority)
+	}
+	time.Sleep(500 * time.Millisecond)
+	close(gate)
+	for i := 0; i < 20; i++ {
+		want := "high"
+		if i >= queueLib.PriorityWeight(queueLib.MaxPriority) {
+			want = "low"
+		}
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("message %d = %v, want %v", i, got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("message was not consumed")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// confirmPrefix is the producers key prefix of the publishers in confirm mode
+const confirmPrefix = "confirm:"
+
+// getConfirmProducer returns the publisher in confirm mode of the exchange, it will be created if not exist
+func (r *RabbitMQ) getConfirmProducer(ctx context.Context, exchange string) (*rabbitmq.Publisher, error) {
+	r.producerMux.Lock()
+	defer r.producerMux.Unlock()
+	if p, ok := r.producers[confirmPrefix+exchange]; ok {
+		return p, nil
+	}
+	p, err := r.newProducer(ctx, rabbitmq.WithPublisherOptionsConfirm)
+	if err != nil {
+		glog.Warning(ctx, "rabbitmq newProducer error:", err)
+		return nil, err
+	}
+	r.producers[confirmPrefix+exchange] = p
+	return p, nil
+}
+
+// PublishBatch 批量发送, 消息在确认模式的通道上连续发送后统一等待broker确认;
+// 每条消息使用自己的id, 设置延时时逐条发送
+func (r *RabbitMQ) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	options := &queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(options)
+	}
+	if options.GetDelay() > 0 {
+		return queueLib.PublishEach(ctx, r, msgs, optionFuncs...)
+	}
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(r.String(), msgs[i], err)
+		}
+	}()
+	codec, err := options.GetCodec(r.Codec)
+	if err != nil {
+		return err
+	}
+	p, err := r.getConfirmProducer(ctx, options.Exchange)
+	if err != nil {
+		return err
+	}
+	var confirms rabbitmq.PublisherConfirmation
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, r.String(), message)
+		spans = append(spans, span)
+		rb, err := codec.Marshal(message.GetValues())
+		if err != nil {
+			return err
+		}
+		options.MessageID = message.GetId()
+		if options.MessageID == "" {
+			options.MessageID = uuid.New().String()
+		}
+		confirm, err := p.PublishWithDeferredConfirmWithContext(
+			ctx,
+			rb,
+			[]string{message.GetRoutingKey()},
+			publishOptions(options.Exchange, message, options)...,
+		)
+		if err != nil {
+			return err
+		}
+		confirms = append(confirms, confirm...)
+	}
+	for i, confirm := range confirms {
+		if confirm == nil {
+			continue
+		}
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			return fmt.Errorf("rabbitmq batch message %d was nacked by the broker", i)
+		}
+	}
+	return nil
+}
+
+// BatchConsumer 批量消费, 由单条消费合并批次, QOSPrefetch不小于批次大小
+func (r *RabbitMQ) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	queueLib.ConsumeBatches(ctx, r, name, f, optionFuncs...)
+}
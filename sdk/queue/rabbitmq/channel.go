@@ -0,0 +1,111 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+// amqpConn returns the connection shared by the declarations, confirmed publishes and health checks,
+// rabbitmq-go does not expose its own. It is dialed again once closed
+func (r *RabbitMQ) amqpConn() (*amqp.Connection, error) {
+	r.amqpMux.Lock()
+	defer r.amqpMux.Unlock()
+	if r.amqpConnection == nil || r.amqpConnection.IsClosed() {
+		conn, err := amqp.DialConfig(r.Url, amqp.Config(r.Config))
+		if err != nil {
+			return nil, err
+		}
+		r.amqpConnection = conn
+	}
+	return r.amqpConnection, nil
+}
+
+// withChannel runs f on a new channel of the shared connection,
+// a channel closed by a failed declaration or inspection does not affect the others
+func (r *RabbitMQ) withChannel(f func(ch *amqp.Channel) error) error {
+	conn, err := r.amqpConn()
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return f(ch)
+}
+
+// declareQueue declares the durable queue once, or again once the last declaration is older than refresh if refresh > 0.
+// Declaring a queue again resets its x-expires
+func (r *RabbitMQ) declareQueue(name string, args amqp.Table, refresh time.Duration) error {
+	r.declareMux.Lock()
+	defer r.declareMux.Unlock()
+	if at, ok := r.declared[name]; ok && (refresh <= 0 || time.Since(at) < refresh) {
+		return nil
+	}
+	err := r.withChannel(func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(name, true, false, false, false, args)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.declared[name] = time.Now()
+	return nil
+}
+
+// publishToQueue publishes msg through the default exchange to the queue only,
+// it returns nil once the broker confirms the message was routed to the queue
+func (r *RabbitMQ) publishToQueue(ctx context.Context, queue string, msg amqp.Publishing) error {
+	r.confirmMux.Lock()
+	defer r.confirmMux.Unlock()
+	if r.confirmCh == nil || r.confirmCh.IsClosed() {
+		conn, err := r.amqpConn()
+		if err != nil {
+			return err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return err
+		}
+		if err = ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			return err
+		}
+		r.confirmCh, r.returns = ch, ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	confirm, err := r.confirmCh.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// a late confirmation or return must not be taken for the next message's
+		_ = r.confirmCh.Close()
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("rabbitmq: message to %s nacked by the broker", queue)
+	}
+	// the broker sends the return of an unroutable message before its confirmation
+	select {
+	case ret, ok := <-r.returns:
+		if ok {
+			return fmt.Errorf("rabbitmq: message to %s returned: %s", queue, ret.ReplyText)
+		}
+	default:
+	}
+	return nil
+}
+
+// closeChannels closes the shared connection
+func (r *RabbitMQ) closeChannels() error {
+	r.amqpMux.Lock()
+	defer r.amqpMux.Unlock()
+	if r.amqpConnection == nil || r.amqpConnection.IsClosed() {
+		return nil
+	}
+	return r.amqpConnection.Close()
+}
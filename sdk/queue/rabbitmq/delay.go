@@ -0,0 +1,48 @@
+package rabbitmq
+
+import (
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+const (
+	// DelayQueuePrefix is the name prefix of the queues holding delayed messages
+	DelayQueuePrefix = "delay."
+)
+
+// delaySignificant bounds the significant part of a delay in seconds to two digits, a delay is rounded up
+// by less than 10% and each power of ten has at most 90 delay queues
+const delaySignificant = 100
+
+// delayBucket rounds delay up to two significant digits in seconds, at least one second,
+// so that a message is never delivered earlier than requested and the number of delay queues stays bounded.
+// e.g. 61m is delivered after 61m40s, 1h after 1h
+func delayBucket(delay time.Duration) time.Duration {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return time.Second
+	}
+	step := int64(1)
+	for seconds/step >= delaySignificant {
+		step *= 10
+	}
+	return time.Duration((seconds+step-1)/step*step) * time.Second
+}
+
+// declareDelayQueue declares the queue holding the delayed messages of exchange and routingKey.
+// An unused delay queue expires after twice its ttl, it is declared again before a message could outlive it
+func (r *RabbitMQ) declareDelayQueue(exchange, routingKey string, delay time.Duration) (string, error) {
+	ttl := delayBucket(delay)
+	name := fmt.Sprintf("%s%s.%s.%dms", DelayQueuePrefix, exchange, routingKey, ttl.Milliseconds())
+	err := r.declareQueue(name, amqp.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-expires":                 (2*ttl + time.Minute).Milliseconds(),
+		"x-dead-letter-exchange":    exchange,
+		"x-dead-letter-routing-key": routingKey,
+	}, ttl)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,33 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayBucket(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  time.Duration
+	}{
+		{delay: 0, want: time.Second},
+		{delay: 300 * time.Millisecond, want: time.Second},
+		{delay: 1500 * time.Millisecond, want: 2 * time.Second},
+		{delay: 99 * time.Second, want: 99 * time.Second},
+		{delay: 100 * time.Second, want: 100 * time.Second},
+		{delay: 101 * time.Second, want: 110 * time.Second},
+		{delay: time.Hour, want: time.Hour},
+		{delay: time.Hour + time.Second, want: time.Hour + 100*time.Second},
+		{delay: 61 * time.Minute, want: 61*time.Minute + 40*time.Second},
+		{delay: 25 * time.Hour, want: 25 * time.Hour},
+	}
+	for _, tt := range tests {
+		got := delayBucket(tt.delay)
+		if got != tt.want {
+			t.Errorf("delayBucket(%s) = %s, want %s", tt.delay, got, tt.want)
+		}
+		if got < tt.delay || got-tt.delay > tt.delay/10+time.Second {
+			t.Errorf("delayBucket(%s) = %s, rounded by more than 10%%", tt.delay, got)
+		}
+	}
+}
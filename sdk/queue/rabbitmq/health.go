@@ -0,0 +1,48 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Health 通过共享的连接检查broker, 并查询已消费队列的消费者数和堆积消息数,
+// rabbitmq-go的连接管理不暴露连接状态. 尚未声明的队列不影响状态
+func (r *RabbitMQ) Health(ctx context.Context) health.Status {
+	if r.drain.Closed() {
+		return health.Down(r.String(), queueLib.ErrShuttingDown)
+	}
+	if _, err := r.amqpConn(); err != nil {
+		return health.Down(r.String(), err)
+	}
+	r.mux.RLock()
+	names := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		names = append(names, name)
+	}
+	r.mux.RUnlock()
+	s := health.Up(r.String())
+	s.Lag = 0
+	for _, name := range names {
+		// a failed inspection closes its channel
+		err := r.withChannel(func(ch *amqp.Channel) error {
+			q, err := ch.QueueInspect(name)
+			if err != nil {
+				return err
+			}
+			s.Consumers += q.Consumers
+			s.Lag += int64(q.Messages)
+			return nil
+		})
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			continue
+		}
+		if err != nil {
+			return health.Down(r.String(), err)
+		}
+	}
+	return s
+}
@@ -2,12 +2,14 @@ package rabbitmq
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/sdk/v2/message"
-	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"sync"
 	"time"
 )
@@ -26,7 +28,11 @@ func NewRabbitMQ(
 		ReconnectInterval: reconnectInterval,
 		producers:         map[string]*rabbitmq.Publisher{},
 		consumers:         map[string]*rabbitmq.Consumer{},
+		specs:             map[string]consumerSpec{},
+		queues:            map[string]struct{}{},
+		declared:          map[string]time.Time{},
 		Logger:            logger,
+		Codec:             messageLib.DefaultCodec,
 	}
 	if cfg != nil {
 		r.Config = *cfg
@@ -36,17 +42,43 @@ func NewRabbitMQ(
 
 // RabbitMQ cache implement
 type RabbitMQ struct {
+	queueLib.MiddlewareChain
 	Url               string
 	ReconnectInterval int
 	Handler           []rabbitmq.Handler
 	Config            rabbitmq.Config
 	mux               sync.RWMutex
+	producerMux       sync.Mutex
+	replyMux          sync.Mutex
+	replyQueue        string
+	pending           queueLib.Pending
+	drain             queueLib.Drain
+	stop              sync.Once
 	consumers         map[string]*rabbitmq.Consumer
+	specs             map[string]consumerSpec
+	paused            bool
+	stopping          bool
+	queues            map[string]struct{}
 	ConsumerOptions   *rabbitmq.ConsumerOptions
 	producers         map[string]*rabbitmq.Publisher
 	PublisherOptions  *rabbitmq.PublisherOptions
 	Logger            rabbitmq.Logger
+	Codec             messageLib.Codec
 	conn              *rabbitmq.Conn
+	amqpMux           sync.Mutex
+	amqpConnection    *amqp.Connection
+	declareMux        sync.Mutex
+	declared          map[string]time.Time
+	confirmMux        sync.Mutex
+	confirmCh         *amqp.Channel
+	returns           chan amqp.Return
+}
+
+// consumerSpec creates a consumer again on Resume
+type consumerSpec struct {
+	queueName string
+	handler   rabbitmq.Handler
+	options   queueLib.ConsumeOptions
 }
 
 func (r *RabbitMQ) String() string {
@@ -77,10 +109,7 @@ func (r *RabbitMQ) newConsumer(ctx context.Context, queueName string, handler ra
 	if err != nil {
 		return nil, err
 	}
-	return rabbitmq.NewConsumer(ctx,
-		conn,
-		handler,
-		queueName,
+	optionFuncs := []func(*rabbitmq.ConsumerOptions){
 		rabbitmq.WithConsumerOptionsExchangeDeclare,
 		rabbitmq.WithConsumerOptionsLogger(r.Logger),
 		rabbitmq.WithConsumerOptionsRoutingKeys(options.BindingRoutingKeys),
@@ -92,10 +121,17 @@ func (r *RabbitMQ) newConsumer(ctx context.Context, queueName string, handler ra
 		rabbitmq.WithConsumerOptionsQOSPrefetch(options.QOSPrefetch),
 		rabbitmq.WithConsumerOptionsExchangeDurable,
 		rabbitmq.WithConsumerOptionsQueueDurable,
-	)
+	}
+	if options.MaxPriority > 0 {
+		// an existing queue can not change its arguments, it must be deleted before priorities are enabled
+		optionFuncs = append(optionFuncs, rabbitmq.WithConsumerOptionsQueueArgs(rabbitmq.Table{
+			"x-max-priority": int(options.MaxPriority),
+		}))
+	}
+	return rabbitmq.NewConsumer(ctx, conn, handler, queueName, optionFuncs...)
 }
 
-func (r *RabbitMQ) newProducer(ctx context.Context) (*rabbitmq.Publisher, error) {
+func (r *RabbitMQ) newProducer(ctx context.Context, optionFuncs ...func(*rabbitmq.PublisherOptions)) (*rabbitmq.Publisher, error) {
 	var err error
 	var conn *rabbitmq.Conn
 	conn, err = r.newConn(ctx)
@@ -105,49 +141,113 @@ func (r *RabbitMQ) newProducer(ctx context.Context) (*rabbitmq.Publisher, error)
 
 	return rabbitmq.NewPublisher(ctx,
 		conn,
-		rabbitmq.WithPublisherOptionsLogger(r.Logger),
+		append([]func(*rabbitmq.PublisherOptions){rabbitmq.WithPublisherOptionsLogger(r.Logger)}, optionFuncs...)...,
 	)
 }
 
-// Publish 消息入生产者
-func (r *RabbitMQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
-	// exchange exchangeType routingKey
-	rb, err := json.Marshal(message.GetValues())
+// getProducer returns the publisher of the exchange, it will be created if not exist
+func (r *RabbitMQ) getProducer(ctx context.Context, exchange string) (*rabbitmq.Publisher, error) {
+	r.producerMux.Lock()
+	defer r.producerMux.Unlock()
+	if p, ok := r.producers[exchange]; ok {
+		return p, nil
+	}
+	p, err := r.newProducer(ctx)
 	if err != nil {
-		return err
+		glog.Warning(ctx, "rabbitmq newProducer error:", err)
+		return nil, err
 	}
+	r.producers[exchange] = p
+	return p, nil
+}
+
+// Publish 消息入生产者
+func (r *RabbitMQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, r.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(r.String(), message, err)
+	}()
+	// exchange exchangeType routingKey
 	options := &queueLib.PublishOptions{
-		ContentType: "application/json",
-		MessageID:   gctx.CtxId(ctx),
+		MessageID: message.GetId(),
 	}
 	for _, optionFunc := range optionFuncs {
 		optionFunc(options)
 	}
-	var p *rabbitmq.Publisher
-	var ok bool
-	if p, ok = r.producers[options.Exchange]; !ok {
-		p, err = r.newProducer(ctx)
+	if options.MessageID == "" {
+		options.MessageID = uuid.New().String()
+	}
+	// the request headers travel as amqp properties
+	if options.ReplyTo == "" {
+		options.ReplyTo = queueLib.GetReplyTo(message)
+	}
+	if options.CorrelationID == "" {
+		options.CorrelationID = queueLib.GetCorrelationId(message)
+	}
+	codec, err := options.GetCodec(r.Codec)
+	if err != nil {
+		return err
+	}
+	rb, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return err
+	}
+	exchange, routingKey := options.Exchange, message.GetRoutingKey()
+	if options.Queue != "" {
+		// the default exchange routes by the queue name, publishOptions keeps the routing key in a header
+		exchange, routingKey = "", options.Queue
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		// park the message in a delay queue, it is dead-lettered back to the exchange once its ttl expires,
+		// the ttl is delay rounded up to two significant digits in seconds
+		routingKey, err = r.declareDelayQueue(options.Exchange, routingKey, delay)
 		if err != nil {
-			glog.Warning(ctx, "rabbitmq newProducer error:", err)
 			return err
 		}
-		r.producers[options.Exchange] = p
+		exchange = ""
+	}
+	p, err := r.getProducer(ctx, exchange)
+	if err != nil {
+		return err
 	}
 
 	err = p.PublishWithContext(
 		ctx,
 		rb,
-		[]string{message.GetRoutingKey()},
-		rabbitmq.WithPublishOptionsExchange(options.Exchange),
+		[]string{routingKey},
+		publishOptions(exchange, message, options)...,
+	)
+	return err
+}
+
+// publishOptions returns the rabbitmq options publishing message to exchange
+func publishOptions(exchange string, message messageLib.IMessage, options *queueLib.PublishOptions) []func(*rabbitmq.PublishOptions) {
+	headers := rabbitmq.Table{}
+	for k, v := range message.GetHeaders() {
+		switch k {
+		case messageLib.HeaderRoutingKey, queueLib.ReplyToKey, queueLib.CorrelationIdKey:
+			// the routing key of the publish and the amqp properties apply
+			continue
+		}
+		headers[k] = v
+	}
+	if options.Queue != "" {
+		headers[messageLib.HeaderRoutingKey] = message.GetRoutingKey()
+	}
+	return []func(*rabbitmq.PublishOptions){
+		rabbitmq.WithPublishOptionsExchange(exchange),
+		rabbitmq.WithPublishOptionsHeaders(headers),
 		rabbitmq.WithPublishOptionsContentType(options.ContentType),
 		rabbitmq.WithPublishOptionsMessageID(options.MessageID),
 		rabbitmq.WithPublishOptionsAppID(options.AppID),
 		rabbitmq.WithPublishOptionsUserID(options.UserID),
 		rabbitmq.WithPublishOptionsReplyTo(options.ReplyTo),
+		rabbitmq.WithPublishOptionsCorrelationID(options.CorrelationID),
+		rabbitmq.WithPublishOptionsPriority(options.GetPriority(queueLib.MaxPriority)),
 		rabbitmq.WithPublishOptionsMandatory,
 		rabbitmq.WithPublishOptionsPersistentDelivery,
-	)
-	return err
+	}
 }
 
 // Consumer 监听消费者
@@ -156,23 +256,48 @@ func (r *RabbitMQ) Consumer(ctx context.Context, queueName string, f queueLib.Co
 	for _, optionFunc := range optionFuncs {
 		optionFunc(&options)
 	}
-	var c *rabbitmq.Consumer
-	var err error
-	var ok bool
+	f = r.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(r.String(), queueName, f)
+	f = queueLib.TraceConsumerFunc(r.String(), queueName, f)
+	f = queueLib.DropPermanent(queueName, f, options)
+	f = r.drain.Track(f)
 	r.mux.Lock()
 	defer r.mux.Unlock()
-	if c, ok = r.consumers[options.BindingExchange.Name]; !ok {
+	if _, ok := r.specs[options.BindingExchange.Name]; !ok {
 		header := func(d rabbitmq.Delivery) rabbitmq.Action {
+			values, err := messageLib.GetCodecOrDefault(d.ContentType, r.Codec).Unmarshal(d.Body)
+			if err != nil {
+				return r.reject(ctx, queueName, d, err, options)
+			}
 			m := new(message.Message)
-			m.SetValues(map[string]interface{}{
-				"body": string(d.Body),
-			})
+			m.SetValues(values)
 			m.SetRoutingKey(d.RoutingKey)
+			if routingKey, ok := d.Headers[messageLib.HeaderRoutingKey]; ok {
+				// republished to the queue by retry
+				m.SetRoutingKey(gconv.String(routingKey))
+			}
 			m.SetId(d.MessageId)
+			for k, v := range d.Headers {
+				m.SetHeader(k, gconv.String(v))
+			}
+			if d.ReplyTo != "" {
+				m.SetHeader(queueLib.ReplyToKey, d.ReplyTo)
+				m.SetHeader(queueLib.CorrelationIdKey, d.CorrelationId)
+			}
+			queueLib.SetPriority(m, d.Priority)
 			if d.Redelivered {
 				m.SetErrorCount(d.DeliveryTag)
 			}
+			if count, ok := d.Headers[messageLib.ErrorCountKey]; ok {
+				m.SetErrorCount(gconv.Uint64(count))
+			}
 			err = f(ctx, m)
+			if errors.Is(err, queueLib.ErrShuttingDown) {
+				return rabbitmq.NackRequeue
+			}
+			if err != nil && options.DeadLetter.Enabled() {
+				return r.retry(ctx, queueName, d, m, err, options)
+			}
 			if err != nil {
 				glog.Warning(ctx, "RabbitMQ Requeue msg:", m)
 				return rabbitmq.NackRequeue
@@ -180,31 +305,163 @@ func (r *RabbitMQ) Consumer(ctx context.Context, queueName string, f queueLib.Co
 			// rabbitmq.Ack, rabbitmq.NackDiscard, rabbitmq.NackRequeue
 			return rabbitmq.Ack
 		}
-		c, err = r.newConsumer(ctx, queueName, header, options)
+		spec := consumerSpec{queueName: queueName, handler: header, options: options}
+		if !r.paused {
+			c, err := r.newConsumer(ctx, queueName, header, options)
+			if err != nil {
+				glog.Error(ctx, "rabbitmq newConsumer error:", err)
+				return
+			}
+			r.consumers[options.BindingExchange.Name] = c
+		}
+		r.specs[options.BindingExchange.Name] = spec
+		r.queues[queueName] = struct{}{}
+	}
+}
+
+// Pause 关闭所有消费者, 未确认的消息由broker重新投递, 处理中的消息确认失败后同样重新投递
+func (r *RabbitMQ) Pause(ctx context.Context) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.paused = true
+	for key := range r.specs {
+		// the reply consumer keeps receiving the replies of the pending requests
+		if c, ok := r.consumers[key]; ok {
+			c.Close(ctx)
+			delete(r.consumers, key)
+		}
+	}
+	return nil
+}
+
+// Resume 按原选项重新创建Pause关闭的消费者
+func (r *RabbitMQ) Resume(ctx context.Context) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.stopping {
+		return queueLib.ErrShuttingDown
+	}
+	for key, spec := range r.specs {
+		if _, ok := r.consumers[key]; ok {
+			continue
+		}
+		c, err := r.newConsumer(ctx, spec.queueName, spec.handler, spec.options)
 		if err != nil {
-			glog.Error(ctx, "rabbitmq newConsumer error:", err)
-			return
+			return err
 		}
-		r.consumers[options.BindingExchange.Name] = c
+		r.consumers[key] = c
 	}
+	r.paused = false
+	return nil
+}
 
+// retry republishes a failed delivery to queueName only with its error count in the headers,
+// once the attempts are exhausted it is published to the dead-letter queue instead.
+// The delivery is acked after the broker confirmed the republished message, requeued otherwise
+func (r *RabbitMQ) retry(ctx context.Context, queueName string, d rabbitmq.Delivery, m messageLib.IMessage, err error, options queueLib.ConsumeOptions) rabbitmq.Action {
+	m.SetErrorIncr()
+	target := queueName
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[messageLib.HeaderRoutingKey] = m.GetRoutingKey()
+	headers[messageLib.ErrorCountKey] = int64(m.GetErrorCount())
+	if options.DeadLetter.Exhausted(m, err) {
+		target = options.DeadLetter.GetQueue(queueName)
+		headers[messageLib.ErrorKey] = err.Error()
+		if dErr := r.declareQueue(target, nil, 0); dErr != nil {
+			glog.Warning(ctx, "RabbitMQ declare dead letter queue error:", dErr)
+			return rabbitmq.NackRequeue
+		}
+		glog.Warning(ctx, "RabbitMQ dead letter msg:", m)
+	}
+	if err = r.republish(ctx, target, d, headers); err != nil {
+		glog.Warning(ctx, "RabbitMQ retry publish error:", err)
+		return rabbitmq.NackRequeue
+	}
+	return rabbitmq.Ack
+}
+
+// reject publishes the raw body of a delivery that can't be decoded to the dead-letter queue
+// with the error in its headers, it is discarded if the dead-letter queue is disabled
+func (r *RabbitMQ) reject(ctx context.Context, queueName string, d rabbitmq.Delivery, err error, options queueLib.ConsumeOptions) rabbitmq.Action {
+	glog.Warning(ctx, "RabbitMQ decode msg error:", err)
+	if !options.DeadLetter.Enabled() {
+		return rabbitmq.NackDiscard
+	}
+	target := options.DeadLetter.GetQueue(queueName)
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[messageLib.HeaderRoutingKey]; !ok {
+		headers[messageLib.HeaderRoutingKey] = d.RoutingKey
+	}
+	headers[messageLib.ErrorKey] = err.Error()
+	if err = r.declareQueue(target, nil, 0); err != nil {
+		glog.Warning(ctx, "RabbitMQ declare dead letter queue error:", err)
+		return rabbitmq.NackRequeue
+	}
+	if err = r.republish(ctx, target, d, headers); err != nil {
+		glog.Warning(ctx, "RabbitMQ dead letter publish error:", err)
+		return rabbitmq.NackRequeue
+	}
+	return rabbitmq.Ack
+}
+
+// republish publishes the body and properties of d with headers to queue
+func (r *RabbitMQ) republish(ctx context.Context, queue string, d rabbitmq.Delivery, headers amqp.Table) error {
+	return r.publishToQueue(ctx, queue, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		Priority:      d.Priority,
+		CorrelationId: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		MessageId:     d.MessageId,
+		Body:          d.Body,
+	})
 }
 
 func (r *RabbitMQ) Run(ctx context.Context) {
 	return
 }
 
+// Shutdown 先关闭消费者停止投递, 预取未处理的消息由broker重新投递, 再等待处理中的消息直到ctx结束, 之后关闭连接.
+// 回复消费者在等待期间继续接收未完成请求的回复
 func (r *RabbitMQ) Shutdown(ctx context.Context) {
-	for _, pd := range r.producers {
-		pd.Close(ctx)
-	}
-	for _, pushConsumer := range r.consumers {
-		pushConsumer.Close(ctx)
-	}
-	if r.conn != nil {
-		err := r.conn.Close(ctx)
-		if err != nil {
+	r.stop.Do(func() {
+		r.mux.Lock()
+		r.stopping, r.paused = true, true
+		for key := range r.specs {
+			if c, ok := r.consumers[key]; ok {
+				c.Close(ctx)
+				delete(r.consumers, key)
+			}
+		}
+		r.mux.Unlock()
+		if err := r.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "rabbitmq shutdown before handlers finished:", err)
+		}
+		r.mux.Lock()
+		for _, pushConsumer := range r.consumers {
+			pushConsumer.Close(ctx)
+		}
+		r.mux.Unlock()
+		r.producerMux.Lock()
+		for _, pd := range r.producers {
+			pd.Close(ctx)
+		}
+		r.producerMux.Unlock()
+		if r.conn != nil {
+			err := r.conn.Close(ctx)
+			if err != nil {
+				glog.Warning(ctx, "rabbitmq conn close error:", err.Error())
+			}
+		}
+		if err := r.closeChannels(); err != nil {
 			glog.Warning(ctx, "rabbitmq conn close error:", err.Error())
 		}
-	}
+	})
 }
@@ -0,0 +1,90 @@
+package rabbitmq
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/google/uuid"
+)
+
+// getReplyQueue returns the exclusive reply queue of this connection, it will be declared and consumed if not exist
+func (r *RabbitMQ) getReplyQueue() (string, error) {
+	r.replyMux.Lock()
+	defer r.replyMux.Unlock()
+	if r.replyQueue != "" {
+		return r.replyQueue, nil
+	}
+	ctx := gctx.New()
+	conn, err := r.newConn(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := queueLib.ReplyQueuePrefix + uuid.New().String()
+	handler := func(d rabbitmq.Delivery) rabbitmq.Action {
+		values, err := messageLib.GetCodecOrDefault(d.ContentType, r.Codec).Unmarshal(d.Body)
+		if err != nil {
+			glog.Warning(ctx, "RabbitMQ reply decode error:", err)
+			return rabbitmq.NackDiscard
+		}
+		m := new(message.Message)
+		m.SetId(d.MessageId)
+		m.SetRoutingKey(d.RoutingKey)
+		m.SetValues(values)
+		m.SetHeader(queueLib.CorrelationIdKey, d.CorrelationId)
+		if !r.pending.Resolve(m) {
+			glog.Warning(ctx, "RabbitMQ reply without request:", d.CorrelationId)
+		}
+		return rabbitmq.Ack
+	}
+	c, err := rabbitmq.NewConsumer(ctx,
+		conn,
+		handler,
+		name,
+		rabbitmq.WithConsumerOptionsLogger(r.Logger),
+		rabbitmq.WithConsumerOptionsQueueExclusive,
+		rabbitmq.WithConsumerOptionsQueueAutoDelete,
+		rabbitmq.WithConsumerOptionsConsumerAutoAck(true),
+	)
+	if err != nil {
+		return "", err
+	}
+	r.mux.Lock()
+	r.consumers[name] = c
+	r.mux.Unlock()
+	r.replyQueue = name
+	return name, nil
+}
+
+// Request 发布请求并等待回复
+func (r *RabbitMQ) Request(ctx context.Context, msg messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (messageLib.IMessage, error) {
+	replyTo, err := r.getReplyQueue()
+	if err != nil {
+		return nil, err
+	}
+	correlationId := uuid.New().String()
+	ch := r.pending.Add(correlationId)
+	err = r.Publish(ctx, msg, append(optionFuncs,
+		queueLib.WithRabbitMqPublishOptionsReplyTo(replyTo),
+		queueLib.WithPublishOptionsCorrelationID(correlationId),
+	)...)
+	if err != nil {
+		r.pending.Remove(correlationId)
+		return nil, err
+	}
+	return r.pending.Wait(ctx, correlationId, ch)
+}
+
+// Reply 回复请求方
+func (r *RabbitMQ) Reply(ctx context.Context, request messageLib.IMessage, data interface{}, err error) error {
+	correlationId := queueLib.GetCorrelationId(request)
+	return r.Publish(ctx, &message.Message{
+		RoutingKey: queueLib.GetReplyTo(request),
+		Values:     queueLib.GetReplyValues(data, err),
+	},
+		queueLib.WithRabbitMqPublishOptionsExchange(""),
+		queueLib.WithPublishOptionsCorrelationID(correlationId),
+	)
+}
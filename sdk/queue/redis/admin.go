@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/util/gconv"
+	"time"
+)
+
+// Streams 列出匹配pattern的stream, pattern为空时列出所有stream
+func (r *Redis) Streams(ctx context.Context, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	var streams []string
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			kind, err := r.client.Type(key).Result()
+			if err != nil {
+				return nil, err
+			}
+			if kind == "stream" {
+				streams = append(streams, key)
+			}
+		}
+		if next == 0 {
+			return streams, nil
+		}
+		cursor = next
+	}
+}
+
+// GroupInfo is a consumer group of a stream as reported by XINFO GROUPS
+type GroupInfo struct {
+	Name            string
+	Consumers       int64
+	Pending         int64
+	LastDeliveredID string
+	// Lag of the group, reported by redis 7
+	Lag int64
+}
+
+// Groups 列出stream的消费组及其消费者数, 未确认消息数和最后投递的id
+func (r *Redis) Groups(ctx context.Context, stream string) ([]GroupInfo, error) {
+	// XInfoGroups of go-redis v7 only parses the replies of redis 5 and 6
+	res, err := r.client.Do("XINFO", "GROUPS", stream).Result()
+	if err != nil {
+		return nil, err
+	}
+	replies, _ := res.([]interface{})
+	groups := make([]GroupInfo, 0, len(replies))
+	for _, reply := range replies {
+		fields, _ := reply.([]interface{})
+		var group GroupInfo
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := fields[i+1]
+			switch gconv.String(fields[i]) {
+			case "name":
+				group.Name = gconv.String(value)
+			case "consumers":
+				group.Consumers = gconv.Int64(value)
+			case "pending":
+				group.Pending = gconv.Int64(value)
+			case "last-delivered-id":
+				group.LastDeliveredID = gconv.String(value)
+			case "lag":
+				group.Lag = gconv.Int64(value)
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// Pending 列出消费组中已投递未确认的消息, group为空时使用队列的消费组
+func (r *Redis) Pending(ctx context.Context, stream, group string, count int64) ([]redis.XPendingExt, error) {
+	pending, err := r.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  r.group(group),
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return pending, err
+}
+
+// Claim 将消费组中空闲超过minIdle的最多count条消息转给consumer, consumer为空时使用队列的消费者名
+func (r *Redis) Claim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]redis.XMessage, error) {
+	pending, err := r.Pending(ctx, stream, group, count)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, m := range pending {
+		if m.Idle >= minIdle {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if consumer == "" {
+		consumer = r.options.Name
+	}
+	return r.client.XClaim(&redis.XClaimArgs{
+		Stream:   stream,
+		Group:    r.group(group),
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+}
+
+// Trim 按MAXLEN裁剪stream, approx使用~近似裁剪, 返回删除的消息数
+func (r *Redis) Trim(ctx context.Context, stream string, maxLen int64, approx bool) (int64, error) {
+	if approx {
+		return r.client.XTrimApprox(stream, maxLen).Result()
+	}
+	return r.client.XTrim(stream, maxLen).Result()
+}
+
+// TrimMinID 删除stream中id小于minID的消息, 需要redis 6.2
+func (r *Redis) TrimMinID(ctx context.Context, stream, minID string, approx bool) (int64, error) {
+	args := []interface{}{"XTRIM", stream, "MINID"}
+	if approx {
+		args = append(args, "~")
+	}
+	args = append(args, minID)
+	return r.client.Do(args...).Int64()
+}
+
+// Replay 将消费组的最后投递id设为id, 之后的消息重新投递给消费组; id为0时从头重放
+func (r *Redis) Replay(ctx context.Context, stream, group, id string) error {
+	return r.client.XGroupSetID(stream, r.group(group), id).Err()
+}
+
+// DeadLetters 死信stream的长度
+func (r *Redis) DeadLetters(ctx context.Context) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	for name := range r.dead.Names() {
+		n, err := r.client.XLen(name).Result()
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = n
+	}
+	return sizes, nil
+}
+
+// RequeueDeadLetters 将死信stream中最早的最多limit条消息重新发布到原stream后删除
+func (r *Redis) RequeueDeadLetters(ctx context.Context, name string, limit int) (int, error) {
+	origin, err := r.dead.Origin(name)
+	if err != nil {
+		return 0, err
+	}
+	var msgs []redis.XMessage
+	if limit > 0 {
+		msgs, err = r.client.XRangeN(name, "-", "+", int64(limit)).Result()
+	} else {
+		msgs, err = r.client.XRange(name, "-", "+").Result()
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i, xm := range msgs {
+		contentType := gconv.String(xm.Values[messageLib.ContentTypeKey])
+		m := new(message.Message)
+		m.SetHeaders(headers(xm.Values))
+		values, err := r.decode(xm.Values)
+		if err != nil {
+			return i, err
+		}
+		m.SetValues(values)
+		queueLib.Requeue(m, origin)
+		err = r.Publish(ctx, m, func(options *queueLib.PublishOptions) {
+			options.ContentType = contentType
+		})
+		if err != nil {
+			return i, err
+		}
+		if err = r.client.XDel(name, xm.ID).Err(); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// PurgeDeadLetters 清空死信stream, 保留其消费组
+func (r *Redis) PurgeDeadLetters(ctx context.Context, name string) (int, error) {
+	if _, err := r.dead.Origin(name); err != nil {
+		return 0, err
+	}
+	n, err := r.client.XTrim(name, 0).Result()
+	return int(n), err
+}
+
+// group returns group or the group of the queue consumers
+func (r *Redis) group(group string) string {
+	if group == "" {
+		return r.options.GroupName
+	}
+	return group
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 批量入队, 所有XADD在一个pipeline中发送; 设置延时时逐条入队
+func (r *Redis) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	if options.GetDelay() > 0 {
+		return queueLib.PublishEach(ctx, r, msgs, optionFuncs...)
+	}
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(r.String(), msgs[i], err)
+		}
+	}()
+	codec, err := options.GetCodec(r.Codec)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.Pipeline()
+	defer pipe.Close()
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, r.String(), message)
+		spans = append(spans, span)
+		body, err := codec.Marshal(message.GetValues())
+		if err != nil {
+			return err
+		}
+		stream, err := r.publishStream(message, options)
+		if err != nil {
+			return err
+		}
+		args := &redis.XAddArgs{
+			ID:     message.GetId(),
+			Stream: stream,
+			Values: fields(codec.ContentType(), body, message.GetHeaders()),
+		}
+		if r.producerOptions.ApproximateMaxLength {
+			args.MaxLenApprox = r.producerOptions.StreamMaxLength
+		} else {
+			args.MaxLen = r.producerOptions.StreamMaxLength
+		}
+		pipe.XAdd(args)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// BatchConsumer 批量消费, 由单条消费合并批次
+func (r *Redis) BatchConsumer(ctx context.Context, name string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	queueLib.ConsumeBatches(ctx, r, name, f, optionFuncs...)
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"github.com/gogf/gf/v2/util/gconv"
+	"strings"
+)
+
+const (
+	// BodyKey is the stream field holding the encoded message values
+	BodyKey = "body"
+	// HeaderPrefix prefixes the stream fields holding message headers
+	HeaderPrefix = "header:"
+)
+
+// fields returns the stream fields of an encoded message
+func fields(contentType string, body []byte, headers map[string]string) map[string]interface{} {
+	values := map[string]interface{}{
+		messageLib.ContentTypeKey: contentType,
+		BodyKey:                   string(body),
+	}
+	for k, v := range headers {
+		values[HeaderPrefix+k] = v
+	}
+	return values
+}
+
+// headers 取出消息头字段
+func headers(values map[string]interface{}) map[string]string {
+	var h map[string]string
+	for k, v := range values {
+		if !strings.HasPrefix(k, HeaderPrefix) {
+			continue
+		}
+		if h == nil {
+			h = make(map[string]string)
+		}
+		h[strings.TrimPrefix(k, HeaderPrefix)] = gconv.String(v)
+		delete(values, k)
+	}
+	return h
+}
+
+// decode 按content type字段解码, 没有该字段的旧消息直接使用stream fields
+func (r *Redis) decode(values map[string]interface{}) (map[string]interface{}, error) {
+	contentType, ok := values[messageLib.ContentTypeKey]
+	if !ok {
+		return values, nil
+	}
+	codec := messageLib.GetCodecOrDefault(gconv.String(contentType), r.Codec)
+	return codec.Unmarshal(gconv.Bytes(values[BodyKey]))
+}
@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/google/uuid"
+	json "github.com/json-iterator/go"
+	"strconv"
+	"time"
+)
+
+const (
+	// DelayedKey is the sorted set holding the delayed messages scored by their delivery time in milliseconds
+	DelayedKey = "redisqueue:delayed"
+	// DelayedPollInterval is how often the due delayed messages are moved into their streams
+	DelayedPollInterval = 500 * time.Millisecond
+	// DelayedBatchSize is the max number of due delayed messages moved per poll
+	DelayedBatchSize = 100
+)
+
+type delayedMessage struct {
+	Uid         string                 `json:"uid"`
+	Stream      string                 `json:"stream"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+	ContentType string                 `json:"contentType,omitempty"`
+	Body        []byte                 `json:"body,omitempty"`
+	Headers     map[string]string      `json:"headers,omitempty"`
+}
+
+// enqueueDelayed stores the encoded message in the sorted set until deliverAt
+func (r *Redis) enqueueDelayed(stream, contentType string, body []byte, headers map[string]string, deliverAt time.Time) error {
+	member, err := json.MarshalToString(&delayedMessage{
+		Uid:         uuid.New().String(),
+		Stream:      stream,
+		ContentType: contentType,
+		Body:        body,
+		Headers:     headers,
+	})
+	if err != nil {
+		return err
+	}
+	r.startScheduler()
+	return r.client.ZAdd(DelayedKey, &redis.Z{
+		Score:  float64(deliverAt.UnixMilli()),
+		Member: member,
+	}).Err()
+}
+
+func (r *Redis) startScheduler() {
+	r.scheduler.Do(func() {
+		go r.schedule()
+	})
+}
+
+// schedule feeds the streams with the due delayed messages until Shutdown
+func (r *Redis) schedule() {
+	ticker := time.NewTicker(DelayedPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.enqueueDue()
+		}
+	}
+}
+
+// enqueueDue moves the due delayed messages into their streams,
+// ZREM is used as claim so that a message is only moved once across instances,
+// a message that can not be enqueued is added back with its score and moved by a later poll
+func (r *Redis) enqueueDue() {
+	ctx := gctx.New()
+	due, err := r.client.ZRangeByScoreWithScores(DelayedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: DelayedBatchSize,
+	}).Result()
+	if err != nil {
+		glog.Warning(ctx, "redis delayed messages range error:", err)
+		return
+	}
+	for _, z := range due {
+		member := gconv.String(z.Member)
+		removed, err := r.client.ZRem(DelayedKey, member).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+		var msg delayedMessage
+		err = json.UnmarshalFromString(member, &msg)
+		if err != nil {
+			glog.Warning(ctx, "redis delayed message decode error:", err)
+			continue
+		}
+		values := msg.Values
+		if msg.Body != nil {
+			values = fields(msg.ContentType, msg.Body, msg.Headers)
+		}
+		err = r.producer.Enqueue(&redisqueue.Message{
+			Stream: msg.Stream,
+			Values: values,
+		})
+		if err == nil {
+			continue
+		}
+		glog.Warning(ctx, "redis delayed message enqueue error:", err)
+		if err = r.client.ZAdd(DelayedKey, &z).Err(); err != nil {
+			glog.Error(ctx, "redis delayed message lost, add back error:", err, member)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// Health Lag为消费组中已投递未确认的消息数
+func (r *Redis) Health(ctx context.Context) health.Status {
+	select {
+	case <-r.done:
+		return health.Down(r.String(), queueLib.ErrShuttingDown)
+	default:
+	}
+	if err := r.client.Ping().Err(); err != nil {
+		return health.Down(r.String(), err)
+	}
+	r.mux.Lock()
+	streams := append([]string(nil), r.streams...)
+	consumers := r.plain + len(r.own) + len(r.priorities)
+	r.mux.Unlock()
+	s := health.Up(r.String())
+	s.Consumers = consumers
+	s.Lag = 0
+	for _, stream := range streams {
+		groups, err := r.Groups(ctx, stream)
+		if err != nil {
+			// the stream does not exist until the first message is published
+			continue
+		}
+		for _, group := range groups {
+			if group.Name == r.options.GroupName {
+				s.Lag += group.Pending
+			}
+		}
+	}
+	return s
+}
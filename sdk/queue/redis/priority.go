@@ -0,0 +1,244 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/gogf/gf/v2/os/glog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PriorityKey is the hash holding the max priority declared by the consumers of each queue
+	PriorityKey = "redisqueue:priority"
+)
+
+// PriorityStream returns the stream holding the messages of name with the given priority
+func PriorityStream(name string, priority uint8) string {
+	if priority == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s:priority:%d", name, priority)
+}
+
+// publishStream returns the stream of the published message, its priority is limited to
+// the max priority declared by the consumers, queues without priorities use a single stream
+func (r *Redis) publishStream(msg messageLib.IMessage, options queueLib.PublishOptions) (string, error) {
+	if options.Priority == 0 {
+		return msg.GetRoutingKey(), nil
+	}
+	max, err := r.client.HGet(PriorityKey, msg.GetRoutingKey()).Int()
+	if err == redis.Nil {
+		return msg.GetRoutingKey(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	priority := options.GetPriority(uint8(max))
+	queueLib.SetPriority(msg, priority)
+	return PriorityStream(msg.GetRoutingKey(), priority), nil
+}
+
+// priorityConsumer reads the priority streams of a queue, in every round a stream delivers
+// up to queueLib.PriorityWeight messages starting at the highest priority
+type priorityConsumer struct {
+	ctx         context.Context
+	queue       *Redis
+	name        string
+	consumer    string
+	streams     []string
+	handler     redisqueue.ConsumerFunc
+	concurrency int
+}
+
+func (r *Redis) newPriorityConsumer(ctx context.Context, name string, handler redisqueue.ConsumerFunc, options queueLib.ConsumeOptions) *priorityConsumer {
+	pc := &priorityConsumer{
+		ctx:         ctx,
+		queue:       r,
+		name:        name,
+		consumer:    options.ConsumerName,
+		streams:     make([]string, options.MaxPriority+1),
+		handler:     handler,
+		concurrency: options.Concurrency,
+	}
+	for p := range pc.streams {
+		pc.streams[p] = PriorityStream(name, uint8(p))
+	}
+	if pc.concurrency <= 0 {
+		pc.concurrency = 1
+	}
+	if pc.consumer == "" {
+		pc.consumer = r.options.Name
+	}
+	return pc
+}
+
+func (pc *priorityConsumer) run() {
+	r, options := pc.queue, pc.queue.options
+	err := r.client.HSet(PriorityKey, pc.name, len(pc.streams)-1).Err()
+	if err != nil {
+		glog.Error(pc.ctx, "redis priority declare error:", err)
+		return
+	}
+	for _, stream := range pc.streams {
+		err = r.client.XGroupCreateMkStream(stream, options.GroupName, "$").Err()
+		// ignoring the BUSYGROUP error makes this a noop
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			glog.Error(pc.ctx, "redis priority consumer group error:", err)
+			return
+		}
+	}
+	// the messages left pending by this consumer are delivered again first
+	for p := len(pc.streams) - 1; p >= 0; p-- {
+		pc.process(pc.read([]string{pc.streams[p], "0"}, 0, -1))
+	}
+	var reclaim <-chan time.Time
+	if options.VisibilityTimeout > 0 {
+		ticker := time.NewTicker(options.ReclaimInterval)
+		defer ticker.Stop()
+		reclaim = ticker.C
+	}
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-reclaim:
+			pc.reclaim()
+		default:
+		}
+		if pc.round() == 0 {
+			pc.process(pc.read(pc.blockingStreams(), 1, options.BlockingTimeout))
+		}
+	}
+}
+
+// round reads every stream from the highest priority once and returns the number of messages handled
+func (pc *priorityConsumer) round() int {
+	n := 0
+	for p := len(pc.streams) - 1; p >= 0; p-- {
+		msgs := pc.read([]string{pc.streams[p], ">"}, int64(queueLib.PriorityWeight(uint8(p))), -1)
+		pc.process(msgs)
+		n += len(msgs)
+	}
+	return n
+}
+
+// blockingStreams returns the XREADGROUP streams waiting for new messages on every priority
+func (pc *priorityConsumer) blockingStreams() []string {
+	streams := make([]string, 0, 2*len(pc.streams))
+	streams = append(streams, pc.streams...)
+	for range pc.streams {
+		streams = append(streams, ">")
+	}
+	return streams
+}
+
+// read reads the streams of the group, block < 0 does not wait for messages
+func (pc *priorityConsumer) read(streams []string, count int64, block time.Duration) []*redisqueue.Message {
+	r, options := pc.queue, pc.queue.options
+	res, err := r.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    options.GroupName,
+		Consumer: pc.consumer,
+		Streams:  streams,
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			glog.Warning(pc.ctx, "redis priority read error:", err)
+		}
+		return nil
+	}
+	var msgs []*redisqueue.Message
+	for _, stream := range res {
+		for _, m := range stream.Messages {
+			msgs = append(msgs, &redisqueue.Message{ID: m.ID, Stream: stream.Stream, Values: m.Values})
+		}
+	}
+	return msgs
+}
+
+// reclaim claims the messages other consumers did not acknowledge within the visibility timeout
+func (pc *priorityConsumer) reclaim() {
+	r, options := pc.queue, pc.queue.options
+	for p := len(pc.streams) - 1; p >= 0; p-- {
+		stream := pc.streams[p]
+		pending, err := r.client.XPendingExt(&redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  options.GroupName,
+			Start:  "-",
+			End:    "+",
+			Count:  int64(queueLib.PriorityWeight(uint8(p)) * pc.concurrency),
+		}).Result()
+		if err != nil && err != redis.Nil {
+			glog.Warning(pc.ctx, "redis priority pending error:", err)
+			continue
+		}
+		var ids []string
+		for _, m := range pending {
+			if m.Idle >= options.VisibilityTimeout {
+				ids = append(ids, m.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		claimed, err := r.client.XClaim(&redis.XClaimArgs{
+			Stream:   stream,
+			Group:    options.GroupName,
+			Consumer: pc.consumer,
+			MinIdle:  options.VisibilityTimeout,
+			Messages: ids,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			glog.Warning(pc.ctx, "redis priority claim error:", err)
+			continue
+		}
+		msgs := make([]*redisqueue.Message, 0, len(claimed))
+		for _, m := range claimed {
+			msgs = append(msgs, &redisqueue.Message{ID: m.ID, Stream: stream, Values: m.Values})
+		}
+		pc.process(msgs)
+	}
+}
+
+// process handles msgs with up to concurrency goroutines and acknowledges the successful ones,
+// the failed ones stay pending until they are reclaimed
+func (pc *priorityConsumer) process(msgs []*redisqueue.Message) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, pc.concurrency)
+	for _, msg := range msgs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(msg *redisqueue.Message) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := pc.handle(msg); err != nil {
+				if !errors.Is(err, queueLib.ErrShuttingDown) {
+					glog.Warning(pc.ctx, "redis priority handle error:", err)
+				}
+				return
+			}
+			err := pc.queue.client.XAck(msg.Stream, pc.queue.options.GroupName, msg.ID).Err()
+			if err != nil {
+				glog.Warning(pc.ctx, "redis priority ack error:", err)
+			}
+		}(msg)
+	}
+	wg.Wait()
+}
+
+func (pc *priorityConsumer) handle(msg *redisqueue.Message) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("redis priority consumer panic: %v", e)
+		}
+	}()
+	return pc.handler(msg)
+}
@@ -2,16 +2,30 @@ package redis
 
 import (
 	"context"
+	"errors"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/sdk/v2/message"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"sync"
+	"time"
 )
 
 // NewRedis redis模式
 func NewRedis(producerOptions *redisqueue.ProducerOptions,
 	consumerOptions *redisqueue.ConsumerOptions) (*Redis, error) {
 	var err error
-	r := &Redis{}
+	r := &Redis{
+		done:  make(chan struct{}),
+		Codec: messageLib.DefaultCodec,
+	}
+	if producerOptions == nil {
+		producerOptions = &redisqueue.ProducerOptions{}
+	}
+	r.client = r.newClient(producerOptions)
+	producerOptions.RedisClient = r.client
+	r.producerOptions = producerOptions
 	r.producer, err = r.newProducer(producerOptions)
 	if err != nil {
 		return nil, err
@@ -25,20 +39,51 @@ func NewRedis(producerOptions *redisqueue.ProducerOptions,
 
 // Redis cache implement
 type Redis struct {
-	client   *redis.Client
+	queueLib.MiddlewareChain
+	client   redis.UniversalClient
 	consumer *redisqueue.Consumer
-	producer *redisqueue.Producer
+	own      []*redisqueue.Consumer
+	// running are the consumers started by Run, Shutdown stops only them
+	running         []*redisqueue.Consumer
+	producer        *redisqueue.Producer
+	producerOptions *redisqueue.ProducerOptions
+	scheduler       sync.Once
+	stop            sync.Once
+	done            chan struct{}
+	drain           queueLib.Drain
+	dead            queueLib.DeadLetterQueues
+	mux             sync.Mutex
+	options         *redisqueue.ConsumerOptions
+	streams         []string
+	plain           int
+	priorities      []*priorityConsumer
+	Codec           messageLib.Codec
 }
 
-func (Redis) String() string {
+func (*Redis) String() string {
 	return "redis"
 }
 
+func (r *Redis) newClient(options *redisqueue.ProducerOptions) redis.UniversalClient {
+	if options.RedisClient != nil {
+		return options.RedisClient
+	}
+	if options.RedisOptions == nil {
+		return redis.NewClient(&redis.Options{})
+	}
+	return redis.NewClient(options.RedisOptions)
+}
+
 func (r *Redis) newConsumer(options *redisqueue.ConsumerOptions) (*redisqueue.Consumer, error) {
 	if options == nil {
 		options = &redisqueue.ConsumerOptions{}
 	}
-	return redisqueue.NewConsumerWithOptions(options)
+	c, err := redisqueue.NewConsumerWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	r.options = options
+	return c, nil
 }
 
 func (r *Redis) newProducer(options *redisqueue.ProducerOptions) (*redisqueue.Producer, error) {
@@ -49,30 +94,206 @@ func (r *Redis) newProducer(options *redisqueue.ProducerOptions) (*redisqueue.Pr
 }
 
 // Publish 消息入生产者
-func (r *Redis) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
-	err := r.producer.Enqueue(&redisqueue.Message{
+func (r *Redis) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, r.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(r.String(), message, err)
+	}()
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	codec, err := options.GetCodec(r.Codec)
+	if err != nil {
+		return err
+	}
+	body, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return err
+	}
+	stream, err := r.publishStream(message, options)
+	if err != nil {
+		return err
+	}
+	if delay := options.GetDelay(); delay > 0 {
+		return r.enqueueDelayed(stream, codec.ContentType(), body, message.GetHeaders(), time.Now().Add(delay))
+	}
+	err = r.producer.Enqueue(&redisqueue.Message{
 		ID:     message.GetId(),
-		Stream: message.GetRoutingKey(),
-		Values: message.GetValues(),
+		Stream: stream,
+		Values: fields(codec.ContentType(), body, message.GetHeaders()),
 	})
 	return err
 }
 
-// Consumer 监听消费者
+// Consumer 监听消费者, 设置ConsumerName或Concurrency大于1时使用独立的消费者读取, 否则共享队列配置的消费者
 func (r *Redis) Consumer(ctx context.Context, name string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
-	r.consumer.Register(name, func(msg *redisqueue.Message) error {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = r.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(r.String(), name, f)
+	f = queueLib.TraceConsumerFunc(r.String(), name, f)
+	f = queueLib.DropPermanent(name, f, options)
+	f = r.drain.Track(f)
+	r.dead.Add(name, options)
+	handler := r.handler(ctx, name, f, options)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if options.MaxPriority > 0 {
+		pc := r.newPriorityConsumer(ctx, name, handler, options)
+		r.priorities = append(r.priorities, pc)
+		r.streams = append(r.streams, pc.streams...)
+		return
+	}
+	if options.ConsumerName == "" && options.Concurrency <= 1 {
+		r.streams = append(r.streams, name)
+		r.plain++
+		r.consumer.Register(name, handler)
+		return
+	}
+	c, err := r.newOwnConsumer(options)
+	if err != nil {
+		glog.Errorf(ctx, "redis consumer %s error:%v", name, err)
+		return
+	}
+	c.Register(name, handler)
+	r.streams = append(r.streams, name)
+	r.own = append(r.own, c)
+}
+
+// newOwnConsumer returns a consumer of the queue group with the name and concurrency of options
+func (r *Redis) newOwnConsumer(options queueLib.ConsumeOptions) (*redisqueue.Consumer, error) {
+	own := *r.options
+	if options.ConsumerName != "" {
+		own.Name = options.ConsumerName
+	}
+	if options.Concurrency > 1 {
+		own.Concurrency = options.Concurrency
+		if own.BufferSize < own.Concurrency {
+			own.BufferSize = own.Concurrency
+		}
+	}
+	return redisqueue.NewConsumerWithOptions(&own)
+}
+
+// run runs c until it is shut down, its errors are logged
+func (r *Redis) run(c *redisqueue.Consumer) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for {
+			select {
+			case err := <-c.Errors:
+				if !errors.Is(err, queueLib.ErrShuttingDown) {
+					glog.Warning(context.Background(), "redis consumer error:", err)
+				}
+			case <-finished:
+				return
+			}
+		}
+	}()
+	c.Run()
+}
+
+// handler returns the redisqueue handler of the messages consumed from name
+func (r *Redis) handler(ctx context.Context, name string, f queueLib.ConsumerFunc, options queueLib.ConsumeOptions) redisqueue.ConsumerFunc {
+	return func(msg *redisqueue.Message) error {
 		m := new(message.Message)
-		m.SetValues(msg.Values)
-		m.SetRoutingKey(msg.Stream)
+		if count, ok := msg.Values[messageLib.ErrorCountKey]; ok {
+			m.SetErrorCount(gconv.Uint64(count))
+			delete(msg.Values, messageLib.ErrorCountKey)
+		}
+		m.SetHeaders(headers(msg.Values))
+		values, err := r.decode(msg.Values)
+		if err != nil {
+			glog.Warning(ctx, "redis decode msg error:", err)
+			return err
+		}
+		m.SetValues(values)
+		m.SetRoutingKey(name)
 		m.SetId(msg.ID)
-		return f(ctx, m)
-	})
+		// a message rejected on shutdown is left pending and reclaimed after the visibility timeout
+		err = f(ctx, m)
+		if err == nil || !options.DeadLetter.Enabled() || errors.Is(err, queueLib.ErrShuttingDown) {
+			return err
+		}
+		// the failed message is acked and enqueued again to its stream with its error count,
+		// or moved to the dead-letter stream once the attempts are exhausted
+		m.SetErrorIncr()
+		stream := msg.Stream
+		exhausted := options.DeadLetter.Exhausted(m, err)
+		if exhausted {
+			options.DeadLetter.SetDeadLetter(name, m, err)
+			stream = m.GetRoutingKey()
+		}
+		codec := messageLib.GetCodecOrDefault(gconv.String(msg.Values[messageLib.ContentTypeKey]), r.Codec)
+		body, err := codec.Marshal(m.GetValues())
+		if err != nil {
+			return err
+		}
+		values = fields(codec.ContentType(), body, m.GetHeaders())
+		if !exhausted {
+			values[messageLib.ErrorCountKey] = m.GetErrorCount()
+		}
+		return r.producer.Enqueue(&redisqueue.Message{
+			Stream: stream,
+			Values: values,
+		})
+	}
 }
 
 func (r *Redis) Run(ctx context.Context) {
-	r.consumer.Run()
+	r.startScheduler()
+	r.mux.Lock()
+	select {
+	case <-r.done:
+		r.mux.Unlock()
+		return
+	default:
+	}
+	priorities, plain, own := r.priorities, r.plain, r.own
+	r.running = append(r.running, own...)
+	if plain > 0 {
+		r.running = append(r.running, r.consumer)
+	}
+	r.mux.Unlock()
+	for _, pc := range priorities {
+		go pc.run()
+	}
+	for _, c := range own {
+		go r.run(c)
+	}
+	if plain > 0 {
+		r.run(r.consumer)
+		return
+	}
+	<-r.done
 }
 
+// Shutdown 停止接收新消息并等待处理中的消息直到ctx结束, 未确认的消息在可见超时后被重新认领
 func (r *Redis) Shutdown(ctx context.Context) {
-	r.consumer.Shutdown()
+	r.stop.Do(func() {
+		r.mux.Lock()
+		close(r.done)
+		consumers := r.running
+		r.mux.Unlock()
+		if err := r.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "redis queue shutdown before handlers finished:", err)
+		}
+		// the stop channels of a redisqueue consumer hold one signal, a consumer that never ran would block
+		stopped := make(chan struct{})
+		go func() {
+			for _, c := range consumers {
+				c.Shutdown()
+			}
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+	})
 }
@@ -133,3 +133,110 @@ func TestRedis_Consumer(t *testing.T) {
 		})
 	}
 }
+
+func TestRedis_Admin(t *testing.T) {
+	client := redis.NewClient(&redis.Options{})
+	r, err := NewRedis(&redisqueue.ProducerOptions{RedisClient: client}, &redisqueue.ConsumerOptions{
+		VisibilityTimeout: 60 * time.Second,
+		BlockingTimeout:   time.Second,
+		ReclaimInterval:   time.Second,
+		BufferSize:        100,
+		Concurrency:       1,
+		RedisClient:       client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	stream := "admin_test_queue"
+	defer client.Del(stream)
+	for i := 0; i < 3; i++ {
+		err = r.Publish(ctx, &message.Message{RoutingKey: stream, Values: map[string]interface{}{"key": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	streams, err := r.Streams(ctx, "admin_test_*")
+	if err != nil || len(streams) != 1 || streams[0] != stream {
+		t.Fatalf("Streams() = %v, %v", streams, err)
+	}
+	// a consumer that read the messages and died without acknowledging them
+	if err = client.XGroupCreate(stream, r.group(""), "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	err = client.XReadGroup(&redis.XReadGroupArgs{Group: r.group(""), Consumer: "dead", Streams: []string{stream, ">"}}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := r.Groups(ctx, stream)
+	if err != nil || len(groups) != 1 || groups[0].Pending != 3 {
+		t.Fatalf("Groups() = %+v, %v", groups, err)
+	}
+	pending, err := r.Pending(ctx, stream, "", 10)
+	if err != nil || len(pending) != 3 || pending[0].Consumer != "dead" {
+		t.Fatalf("Pending() = %+v, %v", pending, err)
+	}
+	claimed, err := r.Claim(ctx, stream, "", "alive", 0, 2)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("Claim() = %+v, %v", claimed, err)
+	}
+	if pending, _ = r.Pending(ctx, stream, "", 10); pending[0].Consumer != "alive" {
+		t.Errorf("claimed message consumer = %s, want alive", pending[0].Consumer)
+	}
+	if err = r.Replay(ctx, stream, "", "0"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.XReadGroup(&redis.XReadGroupArgs{Group: r.group(""), Consumer: "alive", Streams: []string{stream, ">"}}).Result()
+	if err != nil || len(res[0].Messages) != 3 {
+		t.Fatalf("messages replayed = %+v, %v", res, err)
+	}
+	if n, err := r.Trim(ctx, stream, 1, false); err != nil || n != 2 {
+		t.Errorf("Trim() = %d, %v, want 2", n, err)
+	}
+}
+
+func TestRedis_ConsumerName(t *testing.T) {
+	client := redis.NewClient(&redis.Options{})
+	r, err := NewRedis(&redisqueue.ProducerOptions{RedisClient: client}, &redisqueue.ConsumerOptions{
+		VisibilityTimeout: 60 * time.Second,
+		BlockingTimeout:   100 * time.Millisecond,
+		ReclaimInterval:   time.Second,
+		BufferSize:        100,
+		Concurrency:       1,
+		RedisClient:       client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	stream := "consumer_name_test_queue"
+	defer client.Del(stream)
+	received := make(chan struct{})
+	release := make(chan struct{})
+	r.Consumer(ctx, stream, func(ctx context.Context, msg messageLib.IMessage) error {
+		received <- struct{}{}
+		<-release
+		return nil
+	}, queueLib.WithConsumeOptionsConcurrency(2), queueLib.WithConsumeOptionsConsumerName("worker-1"))
+	go r.Run(ctx)
+	defer r.Shutdown(ctx)
+	for i := 0; i < 2; i++ {
+		err = r.Publish(ctx, &message.Message{RoutingKey: stream, Values: map[string]interface{}{"key": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// both messages are handled at the same time by the named consumer
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatal("messages were not handled concurrently")
+		}
+	}
+	pending, err := r.Pending(ctx, stream, "", 10)
+	if err != nil || len(pending) != 2 || pending[0].Consumer != "worker-1" {
+		t.Errorf("Pending() = %+v, %v", pending, err)
+	}
+	close(release)
+}
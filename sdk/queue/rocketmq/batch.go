@@ -0,0 +1,116 @@
+package rocketmq
+
+import (
+	"context"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/gogf/gf/v2/os/glog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch 批量发送, 同一topic的消息合并为一次SendSync; rocketmq批量消息不支持延时, 设置延时时逐条发送
+func (r *RocketMQ) PublishBatch(ctx context.Context, msgs []messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	options := queueLib.PublishOptions{}
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	if options.GetDelay() > 0 {
+		return queueLib.PublishEach(ctx, r, msgs, optionFuncs...)
+	}
+	spans := make([]trace.Span, 0, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			queueLib.EndSpan(span, err)
+			queueLib.ObservePublish(r.String(), msgs[i], err)
+		}
+	}()
+	var topics []string
+	batches := map[string][]*primitive.Message{}
+	for _, message := range msgs {
+		var span trace.Span
+		_, span = queueLib.StartPublishSpan(ctx, r.String(), message)
+		spans = append(spans, span)
+		msg, err := r.encode(message, options)
+		if err != nil {
+			return err
+		}
+		if _, ok := batches[msg.Topic]; !ok {
+			topics = append(topics, msg.Topic)
+		}
+		batches[msg.Topic] = append(batches[msg.Topic], msg)
+	}
+	p, err := r.getProducer(ctx, options)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if _, err = p.SendSync(ctx, batches[topic]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchConsumer 批量消费, 消费者组以ConsumeMessageBatchMaxSize每次收到已拉取的至多BatchSize条消息, 不等待BatchTimeout.
+// 失败的消息逐条发往消费者组的重试topic或死信队列, 其余消息正常确认; 同一消费者组的批量大小由其首个消费者决定,
+// 队列中间件按单条消息执行不作用于批量消费
+func (r *RocketMQ) BatchConsumer(ctx context.Context, topicName string, f queueLib.BatchConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
+	options := queueLib.GetDefaultConsumeOptions()
+	for _, optionFunc := range optionFuncs {
+		optionFunc(&options)
+	}
+	f = queueLib.MetricsBatchConsumerFunc(r.String(), topicName, f)
+	f = queueLib.DropPermanentBatch(topicName, f, options)
+	f = r.drain.TrackBatch(f)
+	size, _ := options.GetBatch()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	c, err := r.groupConsumer(ctx, options,
+		consumer.WithConsumeMessageBatchMaxSize(size),
+		consumer.WithPullBatchSize(int32(size)),
+	)
+	if err != nil {
+		glog.Error(ctx, "RocketMQ newConsumer error:", err)
+		return
+	}
+	err = c.Subscribe(
+		topicName,
+		consumer.MessageSelector{},
+		func(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+			raws := make([]*primitive.MessageExt, 0, len(msgs))
+			batch := make([]messageLib.IMessage, 0, len(msgs))
+			for _, msg := range msgs {
+				if len(msg.Body) == 0 {
+					continue
+				}
+				m, err := r.decode(msg)
+				if err != nil {
+					if err = r.reject(ctx, topicName, msg, err, options); err != nil {
+						return consumer.ConsumeRetryLater, err
+					}
+					continue
+				}
+				raws = append(raws, msg)
+				batch = append(batch, m)
+			}
+			if len(batch) == 0 {
+				return consumer.ConsumeSuccess, nil
+			}
+			errs := f(ctx, batch)
+			for i, m := range batch {
+				if err := r.settle(ctx, topicName, raws[i], m, queueLib.BatchResult(errs, i), options); err != nil {
+					glog.Warning(ctx, "RocketMQ retry batch:", err)
+					return consumer.ConsumeRetryLater, err
+				}
+			}
+			return consumer.ConsumeSuccess, nil
+		},
+	)
+	if err != nil {
+		glog.Errorf(ctx, "rocketmq consumer Subscribe error:%v", err)
+		return
+	}
+	r.topics++
+}
@@ -0,0 +1,30 @@
+package rocketmq
+
+import (
+	"fmt"
+	"time"
+)
+
+// delayTimeLevels are the default broker messageDelayLevel: 1s 5s 10s 30s 1m 2m 3m 4m 5m 6m 7m 8m 9m 10m 20m 30m 1h 2h
+var delayTimeLevels = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute, 5 * time.Minute,
+	6 * time.Minute, 7 * time.Minute, 8 * time.Minute, 9 * time.Minute, 10 * time.Minute,
+	20 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour,
+}
+
+// DelayTimeLevel returns the smallest delay level not shorter than delay, so that a message is never delivered earlier
+// than requested. delays longer than the last level return an error.
+func DelayTimeLevel(delay time.Duration) (int, error) {
+	for i, level := range delayTimeLevels {
+		if level >= delay {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("rocketmq: delay %s exceeds the longest delay level %s", delay, delayTimeLevels[len(delayTimeLevels)-1])
+}
+
+// MaxDelay 最长的延时等级, 更长的延时无法投递
+func (r *RocketMQ) MaxDelay() time.Duration {
+	return delayTimeLevels[len(delayTimeLevels)-1]
+}
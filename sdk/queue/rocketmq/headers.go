@@ -0,0 +1,37 @@
+package rocketmq
+
+import (
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"strings"
+)
+
+// HeadersProperty lists the user properties carrying message headers,
+// so that they are told apart from the broker's own properties
+const HeadersProperty = "__headers"
+
+// setHeaders 将消息头写入用户属性
+func setHeaders(msg *primitive.Message, headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(headers))
+	for k, v := range headers {
+		keys = append(keys, k)
+		msg.WithProperty(k, v)
+	}
+	msg.WithProperty(HeadersProperty, strings.Join(keys, ","))
+}
+
+// getHeaders 从用户属性读取消息头
+func getHeaders(msg *primitive.MessageExt) map[string]string {
+	list := msg.GetProperty(HeadersProperty)
+	if list == "" {
+		return nil
+	}
+	keys := strings.Split(list, ",")
+	headers := make(map[string]string, len(keys))
+	for _, k := range keys {
+		headers[k] = msg.GetProperty(k)
+	}
+	return headers
+}
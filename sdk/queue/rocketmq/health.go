@@ -0,0 +1,28 @@
+package rocketmq
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/health"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+)
+
+// Health rocketmq客户端不暴露连接状态和堆积, 消费者或生产者启动失败时报告Down, 否则报告订阅的topic数
+func (r *RocketMQ) Health(ctx context.Context) health.Status {
+	if r.drain.Closed() {
+		return health.Down(r.String(), queueLib.ErrShuttingDown)
+	}
+	r.producerMux.Lock()
+	err := r.producerErr
+	r.producerMux.Unlock()
+	if err != nil {
+		return health.Down(r.String(), err)
+	}
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if r.startErr != nil {
+		return health.Down(r.String(), r.startErr)
+	}
+	s := health.Up(r.String())
+	s.Consumers = r.topics
+	return s
+}
@@ -0,0 +1,92 @@
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	// RetryTopicPrefix and DLQTopicPrefix are the prefixes of the retry and dead-letter topics rocketmq keeps per consumer group
+	RetryTopicPrefix = "%RETRY%"
+	DLQTopicPrefix   = "%DLQ%"
+	// defaultMaxReconsumeTimes is the client's default when MaxReconsumeTimes is not set
+	defaultMaxReconsumeTimes = 16
+)
+
+// settle handles the result of a consumed message on its own, so that the other messages of a batch are acked.
+// a failed message is dead-lettered once its attempts are exhausted, otherwise it is sent back to the retry topic
+// of the consumer group only; an error means msg was not sent anywhere and the whole batch has to be consumed again
+func (r *RocketMQ) settle(ctx context.Context, topicName string, msg *primitive.MessageExt, m messageLib.IMessage, err error, options queueLib.ConsumeOptions) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, queueLib.ErrShuttingDown) {
+		return r.sendBack(ctx, topicName, msg, m.GetErrorCount(), options)
+	}
+	m.SetErrorIncr()
+	if options.DeadLetter.Exhausted(m, err) {
+		return r.deadLetter(ctx, options.DeadLetter.GetQueue(topicName), msg, m.GetErrorCount(), err, options)
+	}
+	if !options.DeadLetter.Enabled() && m.GetErrorCount() > maxReconsumeTimes(options) {
+		// what the broker does with a message consumed MaxReconsumeTimes times
+		return r.deadLetter(ctx, DLQTopicPrefix+options.GroupName, msg, m.GetErrorCount(), err, options)
+	}
+	glog.Warning(ctx, "RocketMQ retry msg:", m.GetId(), err)
+	return r.sendBack(ctx, topicName, msg, m.GetErrorCount(), options)
+}
+
+// sendBack sends msg to the retry topic of the consumer group, so that the other groups of the topic don't consume it again.
+// it is delayed by the level rocketmq uses for the attempt
+func (r *RocketMQ) sendBack(ctx context.Context, topicName string, msg *primitive.MessageExt, count uint64, options queueLib.ConsumeOptions) error {
+	retry := resend(RetryTopicPrefix+options.GroupName, msg, count)
+	// the consumer hands the messages of the retry topic to the callback of this topic
+	retry.WithProperty(primitive.PropertyRetryTopic, topicName)
+	level := 2 + int(count)
+	if level > len(delayTimeLevels) {
+		level = len(delayTimeLevels)
+	}
+	retry.WithDelayTimeLevel(level)
+	if err := r.send(ctx, retry, options); err != nil {
+		glog.Warning(ctx, "RocketMQ retry send error:", err)
+		return err
+	}
+	return nil
+}
+
+// resend returns a copy of the body, tag and headers of msg to topic with the attempts so far
+func resend(topic string, msg *primitive.MessageExt, count uint64) *primitive.Message {
+	out := primitive.NewMessage(topic, msg.Body)
+	out.WithTag(msg.GetTags())
+	out.WithProperty(messageLib.ContentTypeKey, msg.GetProperty(messageLib.ContentTypeKey))
+	setHeaders(out, getHeaders(msg))
+	out.WithProperty(messageLib.ErrorCountKey, gconv.String(count))
+	return out
+}
+
+// send sends msg with the producer of the consumer group
+func (r *RocketMQ) send(ctx context.Context, msg *primitive.Message, options queueLib.ConsumeOptions) error {
+	p, err := r.getProducer(ctx, queueLib.PublishOptions{GroupName: options.GroupName})
+	if err != nil {
+		return err
+	}
+	_, err = p.SendSync(ctx, msg)
+	return err
+}
+
+// errorCount returns the attempts of msg, those counted when it was sent back and the broker's redeliveries
+func errorCount(msg *primitive.MessageExt) uint64 {
+	return gconv.Uint64(msg.GetProperty(messageLib.ErrorCountKey)) + uint64(msg.ReconsumeTimes)
+}
+
+// maxReconsumeTimes returns how many times a message is retried without a dead-letter queue
+func maxReconsumeTimes(options queueLib.ConsumeOptions) uint64 {
+	if options.MaxReconsumeTimes <= 0 {
+		return defaultMaxReconsumeTimes
+	}
+	return uint64(options.MaxReconsumeTimes)
+}
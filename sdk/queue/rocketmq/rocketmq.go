@@ -2,7 +2,7 @@ package rocketmq
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	messageLib "github.com/168yy/plus-core/core/v2/message"
 	queueLib "github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/sdk/v2/message"
@@ -11,7 +11,6 @@ import (
 	"github.com/apache/rocketmq-client-go/v2/producer"
 	"github.com/apache/rocketmq-client-go/v2/rlog"
 	"github.com/gogf/gf/v2/os/glog"
-	"github.com/gogf/gf/v2/util/gconv"
 	"sync"
 )
 
@@ -26,6 +25,7 @@ func NewRocketMQ(
 		Credentials: credentials,
 		consumers:   map[string]rocketmq.PushConsumer{},
 		producers:   map[string]rocketmq.Producer{},
+		Codec:       messageLib.DefaultCodec,
 	}
 
 	rlog.SetLogger(&QLoger{Logger: logger})
@@ -34,11 +34,20 @@ func NewRocketMQ(
 
 // RocketMQ cache implement
 type RocketMQ struct {
+	queueLib.MiddlewareChain
 	Urls        []string
 	consumers   map[string]rocketmq.PushConsumer
 	producers   map[string]rocketmq.Producer
 	mux         sync.RWMutex
+	producerMux sync.Mutex
+	drain       queueLib.Drain
+	topics      int
+	// startErr and producerErr are the last errors starting the consumers and a producer, reported by Health
+	startErr    error
+	producerErr error
+	stop        sync.Once
 	Credentials *primitive.Credentials
+	Codec       messageLib.Codec
 }
 
 func (r *RocketMQ) String() string {
@@ -55,33 +64,35 @@ type RocketProducerOptions struct {
 	RetryTimes int
 }
 
-func (r *RocketMQ) newConsumer(ctx context.Context, opt queueLib.ConsumeOptions) (rocketmq.PushConsumer, error) {
-	if r.Credentials != nil {
-		return rocketmq.NewPushConsumer(
-			consumer.WithGroupName(opt.GroupName),
-			consumer.WithNsResolver(primitive.NewPassthroughResolver(r.Urls)),
-			consumer.WithConsumerModel(consumer.Clustering),
-			consumer.WithMaxReconsumeTimes(opt.MaxReconsumeTimes),
-			consumer.WithAutoCommit(opt.AutoCommit),
-			consumer.WithCredentials(*r.Credentials),
-			//consumer.WithCredentials(primitive.Credentials{
-			//	AccessKey: "RocketMQ",
-			//	SecretKey: "12345678",
-			//}),
-		)
-	}
-	return rocketmq.NewPushConsumer(
+func (r *RocketMQ) newConsumer(ctx context.Context, opt queueLib.ConsumeOptions, extra ...consumer.Option) (rocketmq.PushConsumer, error) {
+	options := []consumer.Option{
 		consumer.WithGroupName(opt.GroupName),
 		consumer.WithNsResolver(primitive.NewPassthroughResolver(r.Urls)),
 		consumer.WithConsumerModel(consumer.Clustering),
 		consumer.WithMaxReconsumeTimes(opt.MaxReconsumeTimes),
 		consumer.WithAutoCommit(opt.AutoCommit),
-		//consumer.WithCredentials(*r.Credentials),
 		//consumer.WithCredentials(primitive.Credentials{
 		//	AccessKey: "RocketMQ",
 		//	SecretKey: "12345678",
 		//}),
-	)
+	}
+	if r.Credentials != nil {
+		options = append(options, consumer.WithCredentials(*r.Credentials))
+	}
+	return rocketmq.NewPushConsumer(append(options, extra...)...)
+}
+
+// groupConsumer returns the push consumer of the group, it will be created with extra if not exist; r.mux must be held
+func (r *RocketMQ) groupConsumer(ctx context.Context, options queueLib.ConsumeOptions, extra ...consumer.Option) (rocketmq.PushConsumer, error) {
+	if c, ok := r.consumers[options.GroupName]; ok {
+		return c, nil
+	}
+	c, err := r.newConsumer(ctx, options, extra...)
+	if err != nil {
+		return nil, err
+	}
+	r.consumers[options.GroupName] = c
+	return c, nil
 }
 
 func (r *RocketMQ) newProducer(ctx context.Context, opt queueLib.PublishOptions) (rocketmq.Producer, error) {
@@ -110,76 +121,119 @@ func (r *RocketMQ) newProducer(ctx context.Context, opt queueLib.PublishOptions)
 	)
 }
 
+// getProducer returns the started producer of the group, it will be created if not exist
+func (r *RocketMQ) getProducer(ctx context.Context, options queueLib.PublishOptions) (rocketmq.Producer, error) {
+	r.producerMux.Lock()
+	defer r.producerMux.Unlock()
+	if p, ok := r.producers[options.GroupName]; ok {
+		return p, nil
+	}
+	p, err := r.newProducer(ctx, options)
+	if err != nil {
+		glog.Error(ctx, "RocketMQ newProducer error:", err)
+		return nil, err
+	}
+	err = p.Start()
+	r.producerErr = err
+	if err != nil {
+		glog.Error(ctx, "RocketMQ producer Start error:", err)
+		return nil, err
+	}
+	r.producers[options.GroupName] = p
+	return p, nil
+}
+
 // Publish 消息入生产者
-func (r *RocketMQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) error {
+func (r *RocketMQ) Publish(ctx context.Context, message messageLib.IMessage, optionFuncs ...func(*queueLib.PublishOptions)) (err error) {
+	ctx, span := queueLib.StartPublishSpan(ctx, r.String(), message)
+	defer func() {
+		queueLib.EndSpan(span, err)
+		queueLib.ObservePublish(r.String(), message, err)
+	}()
 	options := queueLib.PublishOptions{}
 	for _, optionFunc := range optionFuncs {
 		optionFunc(&options)
 	}
-	var p rocketmq.Producer
-	var err error
-	var ok bool
-	if p, ok = r.producers[options.GroupName]; !ok {
-		p, err = r.newProducer(ctx, options)
-		if err != nil {
-			glog.Error(ctx, "RocketMQ newConsumer error:", err)
-			return err
-		}
-		r.producers[options.GroupName] = p
+	p, err := r.getProducer(ctx, options)
+	if err != nil {
+		return err
 	}
-	// encode message
-	rb, err := json.Marshal(message.GetValues())
+	msg, err := r.encode(message, options)
 	if err != nil {
 		return err
 	}
-	_, err = p.SendSync(
-		ctx,
-		&primitive.Message{
-			Topic: message.GetRoutingKey(),
-			Body:  rb,
-		})
+	_, err = p.SendSync(ctx, msg)
 	return err
 }
 
+// encode builds the rocketmq message sent to the topic of message
+func (r *RocketMQ) encode(message messageLib.IMessage, options queueLib.PublishOptions) (*primitive.Message, error) {
+	codec, err := options.GetCodec(r.Codec)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := codec.Marshal(message.GetValues())
+	if err != nil {
+		return nil, err
+	}
+	msg := &primitive.Message{
+		Topic: message.GetRoutingKey(),
+		Body:  rb,
+	}
+	if options.Topic != "" {
+		// the consumer takes the tag as the routing key
+		msg.Topic = options.Topic
+		msg.WithTag(message.GetRoutingKey())
+	}
+	msg.WithProperty(messageLib.ContentTypeKey, codec.ContentType())
+	setHeaders(msg, message.GetHeaders())
+	if delay := options.GetDelay(); delay > 0 {
+		level, err := DelayTimeLevel(delay)
+		if err != nil {
+			return nil, err
+		}
+		msg.WithDelayTimeLevel(level)
+	}
+	return msg, nil
+}
+
 // Consumer 监听消费者
 func (r *RocketMQ) Consumer(ctx context.Context, topicName string, f queueLib.ConsumerFunc, optionFuncs ...func(*queueLib.ConsumeOptions)) {
 	options := queueLib.GetDefaultConsumeOptions()
 	for _, optionFunc := range optionFuncs {
 		optionFunc(&options)
 	}
-	var c rocketmq.PushConsumer
-	var err error
-	var ok bool
+	f = r.Wrap(f, options)
+	f = queueLib.MetricsConsumerFunc(r.String(), topicName, f)
+	f = queueLib.TraceConsumerFunc(r.String(), topicName, f)
+	f = queueLib.DropPermanent(topicName, f, options)
+	f = r.drain.Track(f)
 	r.mux.Lock()
 	defer r.mux.Unlock()
-	if c, ok = r.consumers[options.GroupName]; !ok {
-		c, err = r.newConsumer(ctx, options)
-		if err != nil {
-			glog.Error(ctx, "RocketMQ newConsumer error:", err)
-			return
-		}
-		r.consumers[options.GroupName] = c
+	c, err := r.groupConsumer(ctx, options)
+	if err != nil {
+		glog.Error(ctx, "RocketMQ newConsumer error:", err)
+		return
 	}
 	err = c.Subscribe(
 		topicName,
 		consumer.MessageSelector{},
 		func(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
-			for i := range msgs {
-				if len(msgs[i].Body) > 0 {
-					glog.Debugf(ctx, "rocketmq consumed: %v\n", msgs[i])
-					m := new(message.Message)
-					m.SetValues(gconv.Map(msgs[i].Body))
-					m.SetValues(map[string]interface{}{
-						"body": string(msgs[i].Body),
-					})
-					m.SetRoutingKey(msgs[i].GetTags())
-					m.SetId(msgs[i].MsgId)
-					m.SetErrorCount(uint64(msgs[i].ReconsumeTimes))
-					err = f(ctx, m)
-					if err != nil {
-						glog.Warning(ctx, "RocketMQ Rollback msg:", m)
+			for _, msg := range msgs {
+				if len(msg.Body) == 0 {
+					continue
+				}
+				glog.Debugf(ctx, "rocketmq consumed: %v\n", msg)
+				m, err := r.decode(msg)
+				if err != nil {
+					if err = r.reject(ctx, topicName, msg, err, options); err != nil {
 						return consumer.Rollback, err
 					}
+					continue
+				}
+				if err = r.settle(ctx, topicName, msg, m, f(ctx, m), options); err != nil {
+					glog.Warning(ctx, "RocketMQ Rollback msg:", m)
+					return consumer.Rollback, err
 				}
 			}
 			return consumer.ConsumeSuccess, nil
@@ -189,30 +243,98 @@ func (r *RocketMQ) Consumer(ctx context.Context, topicName string, f queueLib.Co
 		glog.Errorf(ctx, "rocketmq consumer Subscribe error:%v", err)
 		return
 	}
+	r.topics++
+}
+
+// decode returns the message of the body and properties of msg
+func (r *RocketMQ) decode(msg *primitive.MessageExt) (*message.Message, error) {
+	codec := messageLib.GetCodecOrDefault(msg.GetProperty(messageLib.ContentTypeKey), r.Codec)
+	values, err := codec.Unmarshal(msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	m := new(message.Message)
+	m.SetValues(values)
+	m.SetHeaders(getHeaders(msg))
+	m.SetRoutingKey(msg.GetTags())
+	m.SetId(msg.MsgId)
+	m.SetErrorCount(errorCount(msg))
+	return m, nil
+}
+
+// reject sends a message that can't be decoded to the dead-letter topic, it is acked if the dead-letter queue is disabled
+func (r *RocketMQ) reject(ctx context.Context, topicName string, msg *primitive.MessageExt, err error, options queueLib.ConsumeOptions) error {
+	glog.Warning(ctx, "RocketMQ decode msg error:", err)
+	if !options.DeadLetter.Enabled() {
+		return nil
+	}
+	return r.deadLetter(ctx, options.DeadLetter.GetQueue(topicName), msg, errorCount(msg)+1, err, options)
+}
+
+// deadLetter sends the raw message body to the dead-letter topic with the last error as user property
+func (r *RocketMQ) deadLetter(ctx context.Context, topic string, msg *primitive.MessageExt, count uint64, err error, options queueLib.ConsumeOptions) error {
+	dlq := resend(topic, msg, count)
+	dlq.WithProperty(messageLib.ErrorKey, err.Error())
+	if err = r.send(ctx, dlq, options); err != nil {
+		glog.Warning(ctx, "RocketMQ dead letter send error:", err)
+		return err
+	}
+	glog.Warning(ctx, "RocketMQ dead letter msg:", msg.MsgId)
+	return nil
 }
 
 func (r *RocketMQ) Run(ctx context.Context) {
-	for _, pushConsumer := range r.consumers {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.startErr = nil
+	for group, pushConsumer := range r.consumers {
 		err := pushConsumer.Start()
 		if err != nil {
 			glog.Warning(ctx, "rocketmq consumer Start error", err)
+			r.startErr = fmt.Errorf("rocketmq: start consumer group %s: %w", group, err)
 			continue
 		}
 	}
 	return
 }
 
-func (r *RocketMQ) Shutdown(ctx context.Context) {
-	for _, pd := range r.producers {
-		err := pd.Shutdown()
-		if err != nil {
-			glog.Warning(ctx, "rocketmq producer Close error", err)
-		}
+// Pause 暂停所有消费者从broker拉取消息
+func (r *RocketMQ) Pause(ctx context.Context) error {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for _, pushConsumer := range r.consumers {
+		pushConsumer.Suspend()
 	}
+	return nil
+}
+
+// Resume 恢复Pause暂停的消费者
+func (r *RocketMQ) Resume(ctx context.Context) error {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
 	for _, pushConsumer := range r.consumers {
-		err := pushConsumer.Shutdown()
-		if err != nil {
-			glog.Warning(ctx, "rocketmq consumer Close error", err)
-		}
+		pushConsumer.Resume()
 	}
+	return nil
+}
+
+// Shutdown 停止接收新消息并等待处理中的消息直到ctx结束, 之后关闭消费者和生产者
+func (r *RocketMQ) Shutdown(ctx context.Context) {
+	r.stop.Do(func() {
+		if err := r.drain.Close(ctx); err != nil {
+			glog.Warning(ctx, "rocketmq shutdown before handlers finished:", err)
+		}
+		for _, pushConsumer := range r.consumers {
+			err := pushConsumer.Shutdown()
+			if err != nil {
+				glog.Warning(ctx, "rocketmq consumer Close error", err)
+			}
+		}
+		for _, pd := range r.producers {
+			err := pd.Shutdown()
+			if err != nil {
+				glog.Warning(ctx, "rocketmq producer Close error", err)
+			}
+		}
+	})
 }
@@ -22,6 +22,7 @@ import (
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gcfg"
 	"github.com/gogf/gf/v2/os/glog"
+	"sort"
 )
 
 type Application struct {
@@ -156,3 +157,28 @@ func (a *Application) GetQueueMessage(id, routingKey string, value map[string]in
 	m.SetValues(value)
 	return m, nil
 }
+
+// Shutdown 按名称顺序停止所有任务服务后关闭队列, 用于收到SIGTERM时退出, ctx的deadline限制等待处理中消息的时间
+func (a *Application) Shutdown(ctx context.Context) {
+	services := a.taskServiceReg.GetAll()
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stopped := map[task.TasksService]struct{}{}
+	for _, name := range names {
+		service := services[name]
+		if _, ok := stopped[service]; ok {
+			// registered under several names
+			continue
+		}
+		stopped[service] = struct{}{}
+		glog.Infof(ctx, "task service %s stop ...", name)
+		service.Stop(ctx)
+	}
+	for name, q := range a.queueReg.GetAll() {
+		glog.Infof(ctx, "queue %s shutdown ...", name)
+		q.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,76 @@
+package task
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"sync"
+)
+
+// gate holds the messages of a paused service until it is resumed or stopped
+type gate struct {
+	mux     sync.Mutex
+	resumed chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newGate() *gate {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &gate{resumed: resumed, stopped: make(chan struct{})}
+}
+
+func (g *gate) paused() bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	select {
+	case <-g.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (g *gate) pause() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	select {
+	case <-g.resumed:
+		g.resumed = make(chan struct{})
+	default:
+	}
+}
+
+func (g *gate) resume() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	select {
+	case <-g.resumed:
+	default:
+		close(g.resumed)
+	}
+}
+
+func (g *gate) stop() {
+	g.once.Do(func() {
+		close(g.stopped)
+	})
+}
+
+// Middleware waits while the gate is paused, queue.ErrShuttingDown once it is stopped
+func (g *gate) Middleware(next queue.ConsumerFunc) queue.ConsumerFunc {
+	return func(ctx context.Context, msg message.IMessage) error {
+		g.mux.Lock()
+		resumed := g.resumed
+		g.mux.Unlock()
+		select {
+		case <-resumed:
+		case <-g.stopped:
+			return queue.ErrShuttingDown
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return next(ctx, msg)
+	}
+}
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/config"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+const (
+	SrvName = "KafkaTask"
+)
+
+var insKafka = tKafka{
+	Routers:     []task.KafkaTask{},
+	Middlewares: []queue.Middleware{queue.Recovery()},
+}
+
+type tKafka struct {
+	Routers     []task.KafkaTask
+	Middlewares []queue.Middleware
+}
+
+func Service() *tKafka {
+	return &insKafka
+}
+
+func (t *tKafka) String() string {
+	return SrvName
+}
+
+// AddTasks 添加任务, 多次调用时追加
+func (t *tKafka) AddTasks(tasks ...task.KafkaTask) task.KafkaService {
+	t.Routers = append(t.Routers, tasks...)
+	return t
+}
+
+// Routes 返回所有任务的SubTask的routing key, 按topic和消费组区分
+func (t *tKafka) Routes(ctx context.Context) []task.Route {
+	routes := make([]task.Route, 0, len(t.Routers))
+	for _, worker := range t.Routers {
+		if spec := worker.GetSpec(ctx); spec != nil {
+			for _, subTask := range spec.SubTasks {
+				routes = append(routes, task.Route{Queue: spec.TopicName + "/" + spec.GroupID, RoutingKey: subTask.RoutingKey()})
+			}
+		}
+	}
+	return routes
+}
+
+// Use 注册中间件, 应用到所有任务的消费者
+func (t *tKafka) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
+func (t *tKafka) Start(ctx context.Context) {
+	glog.Info(ctx, "Kafka task start ...")
+	mQueue := sdk.Runtime.QueueRegistry().Get(config.KafkaQueueName) // get kafka instance
+	if mQueue != nil {
+		for _, worker := range t.Routers {
+			spec := worker.GetSpec(ctx)
+			if spec == nil {
+				glog.Warning(ctx, "get tKafka spec is nil ignore...")
+				continue
+			}
+			for i := 0; i < spec.ConsumerNum; i++ {
+				// Consumer
+				mQueue.Consumer(ctx, spec.TopicName, worker.Handle,
+					queue.WithKafkaGroupID(spec.GroupID),
+					queue.WithConsumeOptionsMiddlewares(t.Middlewares...),
+				)
+			}
+		}
+		go mQueue.Run(ctx)
+	} else {
+		panic(gerror.New("sdk.Runtime.GetKafkaQueue is nil!"))
+	}
+}
+
+func (t *tKafka) Stop(ctx context.Context) {
+	if mQueue := sdk.Runtime.QueueRegistry().Get(config.KafkaQueueName); mQueue != nil {
+		glog.Info(ctx, "Kafka task stop ...")
+		mQueue.Shutdown(ctx)
+	}
+}
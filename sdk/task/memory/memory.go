@@ -15,12 +15,14 @@ const (
 )
 
 var instMemory = tMemory{
-	Routers: []task.MemoryTask{},
+	Routers:     []task.MemoryTask{},
+	Middlewares: []queue.Middleware{queue.Recovery()},
 }
 
 type tMemory struct {
-	Queue   queue.IQueue
-	Routers []task.MemoryTask
+	Queue       queue.IQueue
+	Routers     []task.MemoryTask
+	Middlewares []queue.Middleware
 }
 
 func Service() *tMemory {
@@ -31,21 +33,50 @@ func (t *tMemory) String() string {
 	return SrvName
 }
 
+// AddTasks 添加任务, 多次调用时追加
 func (t *tMemory) AddTasks(tasks ...task.MemoryTask) task.MemoryService {
-	t.Routers = tasks
+	t.Routers = append(t.Routers, tasks...)
 	return t
 }
 
+// Routes 返回所有任务处理的routing key, memory队列以routing key为队列
+func (t *tMemory) Routes(ctx context.Context) []task.Route {
+	routes := make([]task.Route, 0, len(t.Routers))
+	for _, worker := range t.Routers {
+		routes = append(routes, task.Route{RoutingKey: worker.GetSpec().RoutingKey})
+	}
+	return routes
+}
+
+// Use 注册中间件, 应用到所有任务的消费者
+func (t *tMemory) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
 func (t *tMemory) Start(ctx context.Context) {
 	glog.Info(ctx, "MemoryMq task start ...")
 	t.Queue = sdk.Runtime.QueueRegistry().Get(config.MemoryQueueName)
 	if t.Queue != nil {
 		for _, worker := range t.Routers {
 			sp := worker.GetSpec()
-			t.Queue.Consumer(ctx, sp.RoutingKey, worker.Handle)
+			middlewares := t.Middlewares
+			if sp.Retry != nil {
+				middlewares = append([]queue.Middleware{task.Retry(t.Queue, sp.Retry, "")}, t.Middlewares...)
+			}
+			t.Queue.Consumer(ctx, sp.RoutingKey, worker.Handle,
+				queue.WithConsumeOptionsConcurrency(sp.Concurrency),
+				queue.WithConsumeOptionsMiddlewares(middlewares...),
+			)
 		}
 		go t.Queue.Run(ctx)
 	} else {
 		glog.Warning(ctx, "MemoryMq is nil ...")
 	}
 }
+
+func (t *tMemory) Stop(ctx context.Context) {
+	if t.Queue != nil {
+		glog.Info(ctx, "MemoryMq task stop ...")
+		t.Queue.Shutdown(ctx)
+	}
+}
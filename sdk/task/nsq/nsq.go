@@ -4,6 +4,8 @@ import (
 	"context"
 	"github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/config"
 	"github.com/gogf/gf/v2/os/glog"
 )
 
@@ -12,12 +14,13 @@ const (
 )
 
 var insNsq = tNsq{
-	Routers: []task.NsqTask{},
+	Routers:     []task.NsqTask{},
+	Middlewares: []queue.Middleware{queue.Recovery()},
 }
 
 type tNsq struct {
-	Queue   queue.IQueue
-	Routers []task.NsqTask
+	Routers     []task.NsqTask
+	Middlewares []queue.Middleware
 }
 
 func Service() *tNsq {
@@ -28,24 +31,63 @@ func (t *tNsq) String() string {
 	return SrvName
 }
 
+// AddTasks 添加任务, 多次调用时追加
 func (t *tNsq) AddTasks(tasks ...task.NsqTask) task.NsqService {
-	t.Routers = tasks
+	t.Routers = append(t.Routers, tasks...)
 	return t
 }
 
+// Routes 返回所有任务处理的topic, 按channel区分
+func (t *tNsq) Routes(ctx context.Context) []task.Route {
+	routes := make([]task.Route, 0, len(t.Routers))
+	for _, worker := range t.Routers {
+		if spec := worker.GetSpec(ctx); spec != nil {
+			for _, topic := range spec.GetTopics() {
+				routes = append(routes, task.Route{Queue: spec.QueueName, RoutingKey: topic})
+			}
+		}
+	}
+	return routes
+}
+
+// Use 注册中间件, 应用到所有任务的消费者
+func (t *tNsq) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
+// Start 每个任务的每个topic注册一个消费者, RoutingMap中的topic交给对应的SubTask, RoutingKey交给任务自身
 func (t *tNsq) Start(ctx context.Context) {
-	glog.Info(ctx, "RocketMq task start ...")
-	t.Queue = nil // todo get rocketmq instance
-	if t.Queue != nil {
-		for _, worker := range t.Routers {
-			spec := worker.GetSpec(ctx)
-			if spec == nil {
-				continue
+	glog.Info(ctx, "Nsq task start ...")
+	mQueue := sdk.Runtime.QueueRegistry().Get(config.NsqQueueName) // get nsq instance
+	if mQueue == nil {
+		glog.Warning(ctx, "sdk.Runtime.GetNsqQueue is nil ...")
+		return
+	}
+	for _, worker := range t.Routers {
+		spec := worker.GetSpec(ctx)
+		if spec == nil {
+			glog.Warning(ctx, "get tNsq spec is nil ignore...")
+			continue
+		}
+		for _, topic := range spec.GetTopics() {
+			f := queue.ConsumerFunc(worker.Handle)
+			if handler, ok := spec.Route(topic); ok {
+				f = task.WrapHandler(handler)
 			}
-			// Consumer
-			go t.Queue.Consumer(ctx, spec.QueueName, worker.Handle)
+			// Consumer, registration errors are logged by the queue
+			mQueue.Consumer(ctx, topic, f,
+				queue.WithNsqChannel(spec.QueueName),
+				queue.WithConsumeOptionsConcurrency(spec.ConsumerNum),
+				queue.WithConsumeOptionsMiddlewares(t.Middlewares...),
+			)
 		}
-	} else {
-		glog.Warning(ctx, "RocketMq is nil ...")
+	}
+	go mQueue.Run(ctx)
+}
+
+func (t *tNsq) Stop(ctx context.Context) {
+	if mQueue := sdk.Runtime.QueueRegistry().Get(config.NsqQueueName); mQueue != nil {
+		glog.Info(ctx, "Nsq task stop ...")
+		mQueue.Shutdown(ctx)
 	}
 }
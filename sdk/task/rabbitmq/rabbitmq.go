@@ -16,11 +16,13 @@ const (
 )
 
 var insRabbitMq = tRabbitMq{
-	Routers: []task.RabbitMqTask{},
+	Routers:     []task.RabbitMqTask{},
+	Middlewares: []queue.Middleware{queue.Recovery()},
 }
 
 type tRabbitMq struct {
-	Routers []task.RabbitMqTask
+	Routers     []task.RabbitMqTask
+	Middlewares []queue.Middleware
 }
 
 func Service() *tRabbitMq {
@@ -31,11 +33,30 @@ func (t *tRabbitMq) String() string {
 	return SrvName
 }
 
+// AddTasks 添加任务, 多次调用时追加
 func (t *tRabbitMq) AddTasks(tasks ...task.RabbitMqTask) task.RabbitMqService {
-	t.Routers = tasks
+	t.Routers = append(t.Routers, tasks...)
 	return t
 }
 
+// Routes 返回所有任务的队列绑定的routing key
+func (t *tRabbitMq) Routes(ctx context.Context) []task.Route {
+	routes := make([]task.Route, 0, len(t.Routers))
+	for _, worker := range t.Routers {
+		if spec := worker.GetSpec(ctx); spec != nil {
+			for _, key := range spec.GetRoutingKeys() {
+				routes = append(routes, task.Route{Queue: spec.QueueName, RoutingKey: key})
+			}
+		}
+	}
+	return routes
+}
+
+// Use 注册中间件, 应用到所有任务的消费者
+func (t *tRabbitMq) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
 func (t *tRabbitMq) Start(ctx context.Context) {
 	glog.Info(ctx, "RabbitMq task start ...")
 	mQueue := sdk.Runtime.QueueRegistry().Get(config.RabbitmqQueueName) // get rabbitmq instance
@@ -46,6 +67,12 @@ func (t *tRabbitMq) Start(ctx context.Context) {
 				glog.Warning(ctx, "get tRabbitMq spec is nil ignore...")
 				continue
 			}
+			middlewares := t.Middlewares
+			if spec.Retry != nil {
+				// the retry goes to the queue of the task only, not to every queue bound to its routing key
+				retry := task.Retry(mQueue, spec.Retry, "", queue.WithRabbitMqPublishOptionsQueue(spec.QueueName))
+				middlewares = append([]queue.Middleware{retry}, t.Middlewares...)
+			}
 			for i := 0; i < spec.ConsumerNum; i++ {
 				// Consumer
 				mQueue.Consumer(ctx, spec.QueueName, worker.Handle,
@@ -56,6 +83,7 @@ func (t *tRabbitMq) Start(ctx context.Context) {
 					queue.WithRabbitMqConsumeOptionsConsumerName(fmt.Sprintf("%s.%02d", spec.TaskName, i+1)),
 					queue.WithRabbitMqConsumeOptionsConsumerAutoAck(spec.AutoAck),
 					queue.WithRabbitMqConsumeOptionsQOSPrefetch(spec.Prefetch),
+					queue.WithConsumeOptionsMiddlewares(middlewares...),
 				)
 			}
 		}
@@ -63,3 +91,27 @@ func (t *tRabbitMq) Start(ctx context.Context) {
 		panic(gerror.New("sdk.Runtime.GetRabbitQueue is nil!"))
 	}
 }
+
+func (t *tRabbitMq) Stop(ctx context.Context) {
+	if mQueue := sdk.Runtime.QueueRegistry().Get(config.RabbitmqQueueName); mQueue != nil {
+		glog.Info(ctx, "RabbitMq task stop ...")
+		mQueue.Shutdown(ctx)
+	}
+}
+
+// Pause 关闭消费者, 消息留在broker直到Resume
+func (t *tRabbitMq) Pause(ctx context.Context) error {
+	q, ok := sdk.Runtime.QueueRegistry().Get(config.RabbitmqQueueName).(queue.IPausable)
+	if !ok {
+		return gerror.Newf("queue %s cannot be paused", config.RabbitmqQueueName)
+	}
+	return q.Pause(ctx)
+}
+
+func (t *tRabbitMq) Resume(ctx context.Context) error {
+	q, ok := sdk.Runtime.QueueRegistry().Get(config.RabbitmqQueueName).(queue.IPausable)
+	if !ok {
+		return gerror.Newf("queue %s cannot be paused", config.RabbitmqQueueName)
+	}
+	return q.Resume(ctx)
+}
@@ -15,11 +15,13 @@ const (
 )
 
 var insRocketmq = tRocketMq{
-	Routers: []task.RocketMqTask{},
+	Routers:     []task.RocketMqTask{},
+	Middlewares: []queue.Middleware{queue.Recovery()},
 }
 
 type tRocketMq struct {
-	Routers []task.RocketMqTask
+	Routers     []task.RocketMqTask
+	Middlewares []queue.Middleware
 }
 
 func Service() *tRocketMq {
@@ -30,11 +32,30 @@ func (t *tRocketMq) String() string {
 	return SrvName
 }
 
+// AddTasks 添加任务, 多次调用时追加
 func (t *tRocketMq) AddTasks(tasks ...task.RocketMqTask) task.RocketMqService {
-	t.Routers = tasks
+	t.Routers = append(t.Routers, tasks...)
 	return t
 }
 
+// Routes 返回所有任务的SubTask的tag, 按topic和消费组区分
+func (t *tRocketMq) Routes(ctx context.Context) []task.Route {
+	routes := make([]task.Route, 0, len(t.Routers))
+	for _, worker := range t.Routers {
+		if spec := worker.GetSpec(ctx); spec != nil {
+			for _, subTask := range spec.SubTasks {
+				routes = append(routes, task.Route{Queue: spec.TopicName + "/" + spec.GroupName, RoutingKey: subTask.RoutingKey()})
+			}
+		}
+	}
+	return routes
+}
+
+// Use 注册中间件, 应用到所有任务的消费者
+func (t *tRocketMq) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
 func (t *tRocketMq) Start(ctx context.Context) {
 	glog.Info(ctx, "RocketMq task start ...")
 	mQueue := sdk.Runtime.QueueRegistry().Get(config.RocketQueueName) // get rabbitmq instance
@@ -45,12 +66,22 @@ func (t *tRocketMq) Start(ctx context.Context) {
 				glog.Warning(ctx, "get tRocketMq spec is nil ignore...")
 				continue
 			}
+			middlewares := t.Middlewares
+			if spec.Retry != nil {
+				// the retry keeps its routing key as the tag of the SubTask
+				retry := task.Retry(mQueue, spec.Retry, "",
+					queue.WithRocketMqPublishGroupName(spec.GroupName),
+					queue.WithRocketMqPublishTopic(spec.TopicName),
+				)
+				middlewares = append([]queue.Middleware{retry}, t.Middlewares...)
+			}
 			for i := 0; i < spec.ConsumerNum; i++ {
 				// Consumer
 				mQueue.Consumer(ctx, spec.TopicName, worker.Handle,
 					queue.WithRocketMqGroupName(spec.GroupName),
 					queue.WithRocketMqAutoCommit(spec.AutoCommit),
 					queue.WithRocketMqMaxReconsumeTimes(spec.MaxReconsumeTimes),
+					queue.WithConsumeOptionsMiddlewares(middlewares...),
 				)
 			}
 		}
@@ -59,3 +90,27 @@ func (t *tRocketMq) Start(ctx context.Context) {
 		panic(gerror.New("sdk.Runtime.GetRocketQueue is nil!"))
 	}
 }
+
+func (t *tRocketMq) Stop(ctx context.Context) {
+	if mQueue := sdk.Runtime.QueueRegistry().Get(config.RocketQueueName); mQueue != nil {
+		glog.Info(ctx, "RocketMq task stop ...")
+		mQueue.Shutdown(ctx)
+	}
+}
+
+// Pause 暂停消费者从broker拉取消息, 消息留在broker直到Resume
+func (t *tRocketMq) Pause(ctx context.Context) error {
+	q, ok := sdk.Runtime.QueueRegistry().Get(config.RocketQueueName).(queue.IPausable)
+	if !ok {
+		return gerror.Newf("queue %s cannot be paused", config.RocketQueueName)
+	}
+	return q.Pause(ctx)
+}
+
+func (t *tRocketMq) Resume(ctx context.Context) error {
+	q, ok := sdk.Runtime.QueueRegistry().Get(config.RocketQueueName).(queue.IPausable)
+	if !ok {
+		return gerror.Newf("queue %s cannot be paused", config.RocketQueueName)
+	}
+	return q.Resume(ctx)
+}
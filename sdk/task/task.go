@@ -2,7 +2,11 @@ package task
 
 import (
 	"context"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/queue"
 	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/gogf/gf/v2/os/glog"
+	"sync"
 )
 
 const (
@@ -11,10 +15,39 @@ const (
 
 var insService = tService{
 	Services: []task.IService{},
+	entries:  map[string]*entry{},
 }
 
+// tService 管理所有后端的任务服务, 按注册顺序启动, 按相反顺序停止.
+// 注册到Runtime.TaskRegister()后由Runtime.Shutdown停止
 type tService struct {
-	Services []task.IService
+	Services    []task.IService
+	Middlewares []queue.Middleware
+	mux         sync.Mutex
+	entries     map[string]*entry
+}
+
+// entry is the lifecycle of a registered service
+type entry struct {
+	service task.IService
+	// gate holds the deliveries of a paused service not implementing task.IPausableService,
+	// nil if the service takes no middlewares
+	gate    *gate
+	paused  bool
+	started bool
+	stopped bool
+}
+
+func (e *entry) state() string {
+	switch {
+	case e.stopped:
+		return task.StateStopped
+	case e.paused || e.gate != nil && e.gate.paused():
+		return task.StatePaused
+	case e.started:
+		return task.StateRunning
+	}
+	return task.StateIdle
 }
 
 func Services() *tService {
@@ -25,13 +58,156 @@ func (t *tService) String() string {
 	return SrvName
 }
 
+// AddServices 注册任务服务, 重复的服务或routing key记录错误日志后忽略
 func (t *tService) AddServices(services ...task.IService) task.TasksService {
-	t.Services = services
+	ctx := context.Background()
+	for _, service := range services {
+		if err := t.Register(ctx, service); err != nil {
+			glog.Error(ctx, err)
+		}
+	}
 	return t
 }
 
+// Register 注册任务服务, 服务名重复或同一队列的routing key由多个任务处理时返回task.ErrDuplicate, 任务需在注册前添加
+func (t *tService) Register(ctx context.Context, services ...task.IService) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, service := range services {
+		name := service.String()
+		if _, ok := t.entries[name]; ok {
+			return fmt.Errorf("%w: service %s", task.ErrDuplicate, name)
+		}
+		if err := checkRoutes(ctx, service); err != nil {
+			return err
+		}
+		e := &entry{service: service}
+		_, pausable := service.(task.IPausableService)
+		if s, ok := service.(task.IMiddlewareService); ok && !pausable {
+			e.gate = newGate()
+			s.Use(e.gate.Middleware)
+		}
+		t.Services = append(t.Services, service)
+		t.entries[name] = e
+	}
+	return nil
+}
+
+// checkRoutes returns task.ErrDuplicate if a routing key is handled by two tasks of a queue,
+// the routes of different services are consumed from different backends and never conflict
+func checkRoutes(ctx context.Context, service task.IService) error {
+	s, ok := service.(task.IRoutingService)
+	if !ok {
+		return nil
+	}
+	seen := map[task.Route]struct{}{}
+	for _, route := range s.Routes(ctx) {
+		if _, ok := seen[route]; ok {
+			return fmt.Errorf("%w: routing key %s of queue %q is handled twice by %s", task.ErrDuplicate, route.RoutingKey, route.Queue, s.String())
+		}
+		seen[route] = struct{}{}
+	}
+	return nil
+}
+
+// Use 注册所有任务服务共用的中间件, 在Start时应用到支持中间件的服务
+func (t *tService) Use(middlewares ...queue.Middleware) {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+}
+
+// Start 启动未启动的任务服务, 启动前暂停的服务启动后保持暂停
 func (t *tService) Start(ctx context.Context) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	for _, service := range t.Services {
+		e := t.entries[service.String()]
+		if e.started || e.stopped {
+			continue
+		}
+		if err := checkRoutes(ctx, service); err != nil {
+			// tasks added after Register, the first matching task handles the key
+			glog.Warning(ctx, err)
+		}
+		if s, ok := service.(task.IMiddlewareService); ok && len(t.Middlewares) > 0 {
+			s.Use(t.Middlewares...)
+		}
 		service.Start(ctx)
+		e.started = true
+	}
+}
+
+// Stop 按启动的相反顺序停止所有任务服务, 暂停中的消息以queue.ErrShuttingDown交还队列
+func (t *tService) Stop(ctx context.Context) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for i := len(t.Services) - 1; i >= 0; i-- {
+		e := t.entries[t.Services[i].String()]
+		if e.stopped {
+			continue
+		}
+		if e.gate != nil {
+			e.gate.stop()
+		}
+		t.Services[i].Stop(ctx)
+		e.stopped = true
+	}
+}
+
+// Pause 暂停服务的所有消费者, 处理中的消息继续完成. 实现task.IPausableService的服务停止从broker接收消息,
+// 其他服务之后收到的消息等待Resume
+func (t *tService) Pause(name string) error {
+	return t.setPaused(name, true)
+}
+
+// Resume 恢复暂停的服务
+func (t *tService) Resume(name string) error {
+	return t.setPaused(name, false)
+}
+
+func (t *tService) setPaused(name string, paused bool) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	e, ok := t.entries[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %s", task.ErrUnknownService, name)
+	case e.stopped:
+		return fmt.Errorf("task: service %s is stopped", name)
+	}
+	if s, ok := e.service.(task.IPausableService); ok {
+		ctx := context.Background()
+		pause := s.Resume
+		if paused {
+			pause = s.Pause
+		}
+		if err := pause(ctx); err != nil {
+			return err
+		}
+		e.paused = paused
+		return nil
+	}
+	if e.gate == nil {
+		return fmt.Errorf("task: service %s cannot be paused", name)
+	}
+	if paused {
+		e.gate.pause()
+	} else {
+		e.gate.resume()
+	}
+	return nil
+}
+
+// Status 按注册顺序返回所有任务服务的状态
+func (t *tService) Status(ctx context.Context) []task.Status {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	status := make([]task.Status, 0, len(t.Services))
+	for _, service := range t.Services {
+		s := task.Status{Name: service.String(), State: t.entries[service.String()].state()}
+		if r, ok := service.(task.IRoutingService); ok {
+			s.Routes = r.Routes(ctx)
+		}
+		status = append(status, s)
 	}
+	return status
 }
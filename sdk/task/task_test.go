@@ -0,0 +1,121 @@
+package task
+
+import (
+	"context"
+	"errors"
+	messageLib "github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/168yy/plus-core/sdk/v2"
+	"github.com/168yy/plus-core/sdk/v2/config"
+	"github.com/168yy/plus-core/sdk/v2/message"
+	memoryQueue "github.com/168yy/plus-core/sdk/v2/queue/memory"
+	"github.com/168yy/plus-core/sdk/v2/task/memory"
+	"testing"
+	"time"
+)
+
+type memoryTask struct {
+	routingKey string
+	handled    chan string
+}
+
+func (m *memoryTask) GetSpec() *task.MemorySpec {
+	return &task.MemorySpec{TaskName: m.routingKey, RoutingKey: m.routingKey}
+}
+
+func (m *memoryTask) Handle(ctx context.Context, msg messageLib.IMessage) error {
+	m.handled <- msg.GetId()
+	return nil
+}
+
+// routingService is a service of another backend handling routes
+type routingService struct {
+	name   string
+	routes []task.Route
+}
+
+func (r *routingService) String() string                          { return r.name }
+func (r *routingService) Start(ctx context.Context)               {}
+func (r *routingService) Stop(ctx context.Context)                {}
+func (r *routingService) Routes(ctx context.Context) []task.Route { return r.routes }
+
+func TestServices(t *testing.T) {
+	ctx := context.Background()
+	q := memoryQueue.NewMemory(100)
+	if err := sdk.Runtime.QueueRegistry().Register(config.MemoryQueueName, q); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdk.Runtime.TaskRegister().Register(SrvName, Services()); err != nil {
+		t.Fatal(err)
+	}
+	// a second name must not stop the services twice
+	if err := sdk.Runtime.TaskRegister().Register("tasks", Services()); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan string, 10)
+	memory.Service().AddTasks(&memoryTask{routingKey: "orders", handled: handled})
+	memory.Service().AddTasks(&memoryTask{routingKey: "users", handled: handled})
+	if err := Services().Register(ctx, memory.Service()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Services().Register(ctx, memory.Service()); !errors.Is(err, task.ErrDuplicate) {
+		t.Errorf("Register() same service error = %v, want %v", err, task.ErrDuplicate)
+	}
+	// the same routing key of another backend is another queue
+	if err := Services().Register(ctx, &routingService{name: "other", routes: []task.Route{{Queue: "users", RoutingKey: "users"}}}); err != nil {
+		t.Errorf("Register() routing key of another backend error = %v", err)
+	}
+	route := task.Route{Queue: "orders/group", RoutingKey: "orders"}
+	if err := Services().Register(ctx, &routingService{name: "dup", routes: []task.Route{route, route}}); !errors.Is(err, task.ErrDuplicate) {
+		t.Errorf("Register() duplicate routing key error = %v, want %v", err, task.ErrDuplicate)
+	}
+	Services().Start(ctx)
+	publish := func(id, routingKey string) {
+		if err := q.Publish(ctx, &message.Message{Id: id, RoutingKey: routingKey}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want string) {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("handled %s, want %s", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s was not handled", want)
+		}
+	}
+	state := func(want string) {
+		status := Services().Status(ctx)
+		if len(status) != 2 || status[0].State != want || len(status[0].Routes) != 2 {
+			t.Errorf("Status() = %+v, want %s with 2 routes", status, want)
+		}
+	}
+	publish("1", "orders")
+	expect("1")
+	state(task.StateRunning)
+
+	if err := Services().Pause(memory.SrvName); err != nil {
+		t.Fatal(err)
+	}
+	state(task.StatePaused)
+	publish("2", "users")
+	select {
+	case id := <-handled:
+		t.Fatalf("handled %s while paused", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := Services().Resume(memory.SrvName); err != nil {
+		t.Fatal(err)
+	}
+	expect("2")
+	state(task.StateRunning)
+
+	if err := Services().Pause("missing"); !errors.Is(err, task.ErrUnknownService) {
+		t.Errorf("Pause() missing service error = %v, want %v", err, task.ErrUnknownService)
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	sdk.Runtime.Shutdown(stopCtx)
+	state(task.StateStopped)
+}
@@ -0,0 +1,175 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+)
+
+const (
+	DefaultTable = "task_workflow"
+)
+
+// Schema 工作流状态表结构(mysql), 其他数据库按字段自行建表
+const Schema = `CREATE TABLE IF NOT EXISTS %s (
+  id VARCHAR(64) NOT NULL,
+  workflow VARCHAR(64) NOT NULL DEFAULT '',
+  status VARCHAR(16) NOT NULL DEFAULT '',
+  step INT NOT NULL DEFAULT 0,
+  data TEXT,
+  error VARCHAR(1024) NOT NULL DEFAULT '',
+  created_at DATETIME NULL,
+  updated_at DATETIME NULL,
+  PRIMARY KEY (id),
+  KEY idx_workflow_status (workflow, status)
+)`
+
+// Entity 工作流状态记录
+type Entity struct {
+	Id        string      `orm:"id"`
+	Workflow  string      `orm:"workflow"`
+	Status    string      `orm:"status"`
+	Step      int         `orm:"step"`
+	Data      string      `orm:"data"`
+	Error     string      `orm:"error"`
+	CreatedAt *gtime.Time `orm:"created_at"`
+	UpdatedAt *gtime.Time `orm:"updated_at"`
+}
+
+// NewDbStore 使用数据库表保存工作流状态, db为空时使用 g.DB(), table为空时使用DefaultTable
+func NewDbStore(db gdb.DB, table string) *DbStore {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &DbStore{
+		DB:    db,
+		Table: table,
+	}
+}
+
+// DbStore implements task.IWorkflowStore and task.IWorkflowLister with gdb
+type DbStore struct {
+	DB    gdb.DB
+	Table string
+}
+
+func (s *DbStore) db() gdb.DB {
+	if s.DB == nil {
+		s.DB = g.DB()
+	}
+	return s.DB
+}
+
+func (s *DbStore) Load(ctx context.Context, id string) (*task.WorkflowState, error) {
+	var row *Entity
+	err := s.db().Model(s.Table).Ctx(ctx).Where("id", id).Scan(&row)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return row.state()
+}
+
+// Create 插入新的记录, 主键冲突时返回task.ErrWorkflowExists
+func (s *DbStore) Create(ctx context.Context, state *task.WorkflowState) error {
+	row, err := s.row(state)
+	if err != nil {
+		return err
+	}
+	row["id"] = state.Id
+	row["created_at"] = gtime.New(state.CreatedAt)
+	if _, err = s.db().Model(s.Table).Ctx(ctx).Data(row).Insert(); err == nil {
+		return nil
+	}
+	// the duplicate key error differs by driver, the id decides
+	if n, cErr := s.db().Model(s.Table).Ctx(ctx).Where("id", state.Id).Count(); cErr == nil && n > 0 {
+		return fmt.Errorf("%w: %s", task.ErrWorkflowExists, state.Id)
+	}
+	return err
+}
+
+// Save 更新已有的记录, 不存在时插入
+func (s *DbStore) Save(ctx context.Context, state *task.WorkflowState) error {
+	row, err := s.row(state)
+	if err != nil {
+		return err
+	}
+	result, err := s.db().Model(s.Table).Ctx(ctx).Data(row).Where("id", state.Id).Update()
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+		return err
+	}
+	// mysql counts the changed rows only
+	if n, err := s.db().Model(s.Table).Ctx(ctx).Where("id", state.Id).Count(); err != nil || n > 0 {
+		return err
+	}
+	row["id"] = state.Id
+	row["created_at"] = gtime.New(state.CreatedAt)
+	_, err = s.db().Model(s.Table).Ctx(ctx).Data(row).Insert()
+	return err
+}
+
+// row returns the columns of state updated by Save
+func (s *DbStore) row(state *task.WorkflowState) (g.Map, error) {
+	data, err := json.Marshal(state.Data)
+	if err != nil {
+		return nil, err
+	}
+	return g.Map{
+		"workflow":   state.Workflow,
+		"status":     state.Status,
+		"step":       state.Step,
+		"data":       string(data),
+		"error":      state.Error,
+		"updated_at": gtime.New(state.UpdatedAt),
+	}, nil
+}
+
+// Unfinished 返回workflow所有运行中和补偿中的实例
+func (s *DbStore) Unfinished(ctx context.Context, workflow string) ([]*task.WorkflowState, error) {
+	var rows []*Entity
+	err := s.db().Model(s.Table).Ctx(ctx).
+		Where("workflow", workflow).
+		WhereIn("status", g.Slice{task.WorkflowRunning, task.WorkflowCompensating}).
+		OrderAsc("created_at").
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*task.WorkflowState, 0, len(rows))
+	for _, row := range rows {
+		state, err := row.state()
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (e *Entity) state() (*task.WorkflowState, error) {
+	state := &task.WorkflowState{
+		Id:       e.Id,
+		Workflow: e.Workflow,
+		Status:   e.Status,
+		Step:     e.Step,
+		Error:    e.Error,
+	}
+	if e.Data != "" {
+		if err := json.Unmarshal([]byte(e.Data), &state.Data); err != nil {
+			return nil, err
+		}
+	}
+	if e.CreatedAt != nil {
+		state.CreatedAt = e.CreatedAt.Time
+	}
+	if e.UpdatedAt != nil {
+		state.UpdatedAt = e.UpdatedAt.Time
+	}
+	return state, nil
+}
@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	cacheLib "github.com/168yy/plus-core/core/v2/cache"
+	queueLib "github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/core/v2/task"
+	"github.com/168yy/plus-core/sdk/v2"
+)
+
+const (
+	DefaultPrefix = "task:workflow:"
+)
+
+// New 创建在q上编排steps的工作流, 状态保存在store, 消费者需订阅其RoutingKey
+func New(name string, q queueLib.IQueue, store task.IWorkflowStore, steps ...task.Step) *task.Workflow {
+	return &task.Workflow{
+		Name:       name,
+		Steps:      steps,
+		Queue:      q,
+		Store:      store,
+		NewMessage: sdk.Runtime.GetQueueMessage,
+	}
+}
+
+// NewCacheStore 使用缓存保存工作流状态, 支持memory和gredis缓存, 多实例部署需使用gredis.
+// expire为状态的过期秒数, 0不过期; 缓存无法列出未完成的工作流, Resume需指定id
+func NewCacheStore(cache cacheLib.ICache, prefix string, expire int) *CacheStore {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &CacheStore{
+		cache:  cache,
+		prefix: prefix,
+		expire: expire,
+	}
+}
+
+// CacheStore implements task.IWorkflowStore with cacheLib.ICache
+type CacheStore struct {
+	cache  cacheLib.ICache
+	prefix string
+	expire int
+}
+
+func (s *CacheStore) Load(ctx context.Context, id string) (*task.WorkflowState, error) {
+	v, err := s.cache.Get(ctx, s.prefix+id)
+	if err != nil || v == nil || v.IsEmpty() {
+		return nil, err
+	}
+	state := &task.WorkflowState{}
+	if err = json.Unmarshal(v.Bytes(), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Create 以SetNX保存新的工作流
+func (s *CacheStore) Create(ctx context.Context, state *task.WorkflowState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ok, err := s.cache.SetNX(ctx, s.prefix+state.Id, string(b), s.expire)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", task.ErrWorkflowExists, state.Id)
+	}
+	return nil
+}
+
+func (s *CacheStore) Save(ctx context.Context, state *task.WorkflowState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, s.prefix+state.Id, string(b), s.expire)
+}
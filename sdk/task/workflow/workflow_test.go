@@ -0,0 +1,249 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/168yy/plus-core/core/v2/message"
+	"github.com/168yy/plus-core/core/v2/queue"
+	"github.com/168yy/plus-core/core/v2/task"
+	cacheMemory "github.com/168yy/plus-core/sdk/v2/cache/memory"
+	"github.com/168yy/plus-core/sdk/v2/queue/memory"
+	_ "github.com/gogf/gf/contrib/drivers/sqlite/v2"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/util/gconv"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const sqliteSchema = `CREATE TABLE %s (
+  id VARCHAR(64) NOT NULL PRIMARY KEY,
+  workflow VARCHAR(64) NOT NULL DEFAULT '',
+  status VARCHAR(16) NOT NULL DEFAULT '',
+  step INTEGER NOT NULL DEFAULT 0,
+  data TEXT,
+  error VARCHAR(1024) NOT NULL DEFAULT '',
+  created_at DATETIME NULL,
+  updated_at DATETIME NULL
+)`
+
+// journal records the steps and compensations run by the order workflow
+type journal struct {
+	mux  sync.Mutex
+	runs []string
+}
+
+func (j *journal) add(run string) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	j.runs = append(j.runs, run)
+}
+
+func (j *journal) String() string {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return fmt.Sprint(j.runs)
+}
+
+// orderSteps reserves the stock, charges the payment and ships the order, the payment fails if data["fail"] is set
+func orderSteps(j *journal) []task.Step {
+	return []task.Step{
+		{
+			Name: "reserve",
+			Forward: func(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+				j.add("reserve")
+				return map[string]interface{}{"reservation": "r-" + gconv.String(data["order"])}, nil
+			},
+			Compensate: func(ctx context.Context, data map[string]interface{}) error {
+				j.add("release " + gconv.String(data["reservation"]))
+				return nil
+			},
+		},
+		{
+			Name: "charge",
+			Forward: func(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+				j.add("charge " + gconv.String(data["reservation"]))
+				if gconv.Bool(data["fail"]) {
+					return nil, errors.New("card declined")
+				}
+				return map[string]interface{}{"payment": "p-" + gconv.String(data["order"])}, nil
+			},
+			Compensate: func(ctx context.Context, data map[string]interface{}) error {
+				j.add("refund " + gconv.String(data["payment"]))
+				return nil
+			},
+		},
+		{
+			Name: "ship",
+			Forward: func(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+				j.add("ship " + gconv.String(data["payment"]))
+				return nil, nil
+			},
+		},
+	}
+}
+
+// wait returns the state of the workflow once it is finished
+func wait(t *testing.T, w *task.Workflow, id string) *task.WorkflowState {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		state, err := w.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != nil && state.Finished() {
+			return state
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("workflow %s not finished", id)
+	return nil
+}
+
+func TestWorkflow_Saga(t *testing.T) {
+	ctx := context.Background()
+	q := memory.NewMemory(100)
+	j := &journal{}
+	w := New("order", q, NewCacheStore(cacheMemory.NewMemory(), "", 0), orderSteps(j)...)
+	q.Consumer(ctx, w.RoutingKey(), task.WrapHandler(w))
+
+	if err := w.Start(ctx, "o1", map[string]interface{}{"order": "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	state := wait(t, w, "o1")
+	if state.Status != task.WorkflowCompleted || gconv.String(state.Data["payment"]) != "p-o1" {
+		t.Fatalf("state = %+v", state)
+	}
+	if got := j.String(); got != "[reserve charge r-o1 ship p-o1]" {
+		t.Fatalf("runs = %s", got)
+	}
+	if err := w.Start(ctx, "o1", nil); !errors.Is(err, task.ErrWorkflowExists) {
+		t.Fatalf("err = %v, want ErrWorkflowExists", err)
+	}
+
+	j = &journal{}
+	w.Steps = orderSteps(j)
+	if err := w.Start(ctx, "o2", map[string]interface{}{"order": "o2", "fail": true}); err != nil {
+		t.Fatal(err)
+	}
+	state = wait(t, w, "o2")
+	if state.Status != task.WorkflowCompensated || state.Error != "charge: card declined" {
+		t.Fatalf("state = %+v", state)
+	}
+	if got := j.String(); got != "[reserve charge r-o2 release r-o2]" {
+		t.Fatalf("runs = %s", got)
+	}
+}
+
+// flakyQueue fails the first publish of the step
+type flakyQueue struct {
+	queue.IQueue
+	step   int
+	failed int32
+}
+
+func (q *flakyQueue) Publish(ctx context.Context, msg message.IMessage, optionFuncs ...func(*queue.PublishOptions)) error {
+	if gconv.Int(msg.GetValues()[task.WorkflowStepKey]) == q.step && atomic.CompareAndSwapInt32(&q.failed, 0, 1) {
+		return errors.New("broker unavailable")
+	}
+	return q.IQueue.Publish(ctx, msg, optionFuncs...)
+}
+
+func TestWorkflow_Redelivered(t *testing.T) {
+	ctx := context.Background()
+	q := memory.NewMemory(100)
+	j := &journal{}
+	// the charge message is lost after reserve saved the state, the redelivered reserve message publishes it again
+	w := New("order", &flakyQueue{IQueue: q, step: 1}, NewCacheStore(cacheMemory.NewMemory(), "", 0), orderSteps(j)...)
+	q.Consumer(ctx, w.RoutingKey(), task.WrapHandler(w))
+
+	if err := w.Start(ctx, "o4", map[string]interface{}{"order": "o4"}); err != nil {
+		t.Fatal(err)
+	}
+	if state := wait(t, w, "o4"); state.Status != task.WorkflowCompleted {
+		t.Fatalf("state = %+v", state)
+	}
+	if got := j.String(); got != "[reserve charge r-o4 ship p-o4]" {
+		t.Fatalf("runs = %s", got)
+	}
+}
+
+func TestWorkflow_Retry(t *testing.T) {
+	ctx := context.Background()
+	q := memory.NewMemory(100)
+	failures := 1
+	w := New("retry", q, NewCacheStore(cacheMemory.NewMemory(), "", 0), task.Step{
+		Name: "flaky",
+		Forward: func(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("unavailable")
+			}
+			return map[string]interface{}{"done": true}, nil
+		},
+		Retry: &task.RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond},
+	})
+	q.Consumer(ctx, w.RoutingKey(), task.WrapHandler(w))
+
+	if err := w.Start(ctx, "r1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if state := wait(t, w, "r1"); state.Status != task.WorkflowCompleted || !gconv.Bool(state.Data["done"]) {
+		t.Fatalf("state = %+v", state)
+	}
+}
+
+func TestWorkflow_Resume(t *testing.T) {
+	ctx := context.Background()
+	db, err := gdb.New(gdb.ConfigNode{
+		Type: "sqlite",
+		Link: "sqlite::@file(" + filepath.Join(t.TempDir(), "workflow.db") + ")",
+		// the test polls the state while the consumer saves it
+		MaxOpenConnCount: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(ctx, fmt.Sprintf(sqliteSchema, DefaultTable)); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDbStore(db, "")
+	// the process stopped after reserving o3, before the charge message was published
+	now := time.Now()
+	err = store.Save(ctx, &task.WorkflowState{
+		Id:        "o3",
+		Workflow:  "order",
+		Status:    task.WorkflowRunning,
+		Step:      1,
+		Data:      map[string]interface{}{"order": "o3", "reservation": "r-o3"},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Create(ctx, &task.WorkflowState{Id: "o3", Workflow: "order"}); !errors.Is(err, task.ErrWorkflowExists) {
+		t.Fatalf("Create existing = %v, want ErrWorkflowExists", err)
+	}
+
+	q := memory.NewMemory(100)
+	j := &journal{}
+	w := New("order", q, store, orderSteps(j)...)
+	q.Consumer(ctx, w.RoutingKey(), task.WrapHandler(w))
+	resumed, err := w.Resume(ctx)
+	if err != nil || resumed != 1 {
+		t.Fatalf("Resume = %d, %v", resumed, err)
+	}
+	if state := wait(t, w, "o3"); state.Status != task.WorkflowCompleted {
+		t.Fatalf("state = %+v", state)
+	}
+	if got := j.String(); got != "[charge r-o3 ship p-o3]" {
+		t.Fatalf("runs = %s", got)
+	}
+	if states, err := store.Unfinished(ctx, "order"); err != nil || len(states) != 0 {
+		t.Fatalf("Unfinished = %v, %v", states, err)
+	}
+}